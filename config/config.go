@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strings"
 )
 
 var (
@@ -20,28 +21,70 @@ var (
 	ClientSecret string
 	// S3Bucket is the bucket where all published plugin packages are going to be stored.
 	S3Bucket string
-	// IndexFile contains the path to the database file where we store everything that is published.
+	// RepositoryDriver selects the database storing plugin metadata. It can be either "bleve" or "sqlite",
+	// the latter only in binaries built with the sqlite tag.
+	RepositoryDriver string
+	// IndexFile contains the path to the Bleve index where we store everything that is published.
 	IndexFile string
+	// SQLiteFile contains the path to the SQLite database where we store everything that is published.
+	SQLiteFile string
 	// IdentityService is the address to Hooklift identity service
 	IdentityService string
+	// StorageDriver selects where plugin packages are stored. It can be either "s3" or "local".
+	StorageDriver string
+	// StorageDir is the directory where the local storage driver keeps plugin packages.
+	StorageDir string
+	// ReservedNames is the list of plugin names nobody is allowed to publish.
+	ReservedNames []string
 )
 
+// defaultReservedNames is used when RESERVED_NAMES is not set.
+var defaultReservedNames = []string{
+	"admin",
+	"api",
+	"files",
+	"hooklift",
+	"latest",
+	"lift",
+	"plugin",
+	"plugins",
+	"publish",
+	"registry",
+	"search",
+}
+
 // Read loads the configuration values.
 func Read() {
-	if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
-		log.Fatal("AWS_ACCESS_KEY_ID with permissions to send emails using Amazon SES is required")
+	StorageDriver = os.Getenv("STORAGE_DRIVER")
+	if StorageDriver == "" {
+		StorageDriver = "s3"
 	}
 
-	if os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
-		log.Fatal("AWS_SECRET_ACCESS_KEY with permissions to send emails using Amazon SES is required")
-	}
+	switch StorageDriver {
+	case "s3":
+		if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+			log.Fatal("AWS_ACCESS_KEY_ID with permissions to read and write to Amazon S3 is required")
+		}
 
-	if os.Getenv("AWS_REGION") == "" {
-		os.Setenv("AWS_REGION", "us-east-1")
-	}
+		if os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+			log.Fatal("AWS_SECRET_ACCESS_KEY with permissions to read and write to Amazon S3 is required")
+		}
+
+		if os.Getenv("AWS_REGION") == "" {
+			os.Setenv("AWS_REGION", "us-east-1")
+		}
 
-	if os.Getenv("S3_BUCKET") == "" {
-		S3Bucket = "hooklift-lift-registry"
+		S3Bucket = os.Getenv("S3_BUCKET")
+		if S3Bucket == "" {
+			S3Bucket = "hooklift-lift-registry"
+		}
+	case "local":
+		StorageDir = os.Getenv("STORAGE_DIR")
+		if StorageDir == "" {
+			StorageDir = "tmp/files"
+		}
+	default:
+		log.Fatalf("unsupported STORAGE_DRIVER %q, it must be either s3 or local", StorageDriver)
 	}
 
 	port := os.Getenv("PORT")
@@ -55,9 +98,24 @@ func Read() {
 		PrimaryDomain = "localhost:" + Port
 	}
 
-	IndexFile = os.Getenv("INDEX_FILE")
-	if IndexFile == "" {
-		IndexFile = "tmp/registry.bleve"
+	RepositoryDriver = os.Getenv("REPOSITORY_DRIVER")
+	if RepositoryDriver == "" {
+		RepositoryDriver = "bleve"
+	}
+
+	switch RepositoryDriver {
+	case "bleve":
+		IndexFile = os.Getenv("INDEX_FILE")
+		if IndexFile == "" {
+			IndexFile = "tmp/registry.bleve"
+		}
+	case "sqlite":
+		SQLiteFile = os.Getenv("SQLITE_FILE")
+		if SQLiteFile == "" {
+			SQLiteFile = "tmp/registry.db"
+		}
+	default:
+		log.Fatalf("unsupported REPOSITORY_DRIVER %q, it must be either bleve or sqlite", RepositoryDriver)
 	}
 
 	// For development purposes, use the following command to regenerate cert:
@@ -83,4 +141,15 @@ func Read() {
 	if IdentityService == "" {
 		IdentityService = "https://localhost:9000"
 	}
+
+	// Comma separated list of plugin names, i.e. lift,hooklift,admin
+	ReservedNames = defaultReservedNames
+	if names := os.Getenv("RESERVED_NAMES"); names != "" {
+		ReservedNames = make([]string, 0)
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				ReservedNames = append(ReservedNames, name)
+			}
+		}
+	}
 }
@@ -0,0 +1,62 @@
+package files
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// Supported hashing algorithms. Their names match the ones used by plugin manifests.
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+)
+
+// digestReader computes the digests and size of all the content read through it.
+type digestReader struct {
+	reader io.Reader
+	sha256 hash.Hash
+	sha512 hash.Hash
+	size   int64
+}
+
+func newDigestReader(r io.Reader) *digestReader {
+	d := &digestReader{
+		sha256: sha256.New(),
+		sha512: sha512.New(),
+	}
+	d.reader = io.TeeReader(r, io.MultiWriter(d.sha256, d.sha512))
+	return d
+}
+
+// Read reads from the underlined reader, hashing the data as it goes.
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.reader.Read(p)
+	d.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes read so far.
+func (d *digestReader) Size() int64 {
+	return d.size
+}
+
+// Digests returns the hex encoded digests of the content read so far, keyed by algorithm.
+func (d *digestReader) Digests() map[string]string {
+	return map[string]string{
+		SHA256: hex.EncodeToString(d.sha256.Sum(nil)),
+		SHA512: hex.EncodeToString(d.sha512.Sum(nil)),
+	}
+}
+
+// computeDigests reads r until EOF and returns the digests of its content.
+func computeDigests(r io.Reader) (map[string]string, error) {
+	d := newDigestReader(r)
+	if _, err := io.Copy(ioutil.Discard, d); err != nil {
+		return nil, err
+	}
+	return d.Digests(), nil
+}
@@ -0,0 +1,129 @@
+package files
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	version "github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
+)
+
+// Files are namespaced by the account that uploaded them, plugin name and plugin
+// version, so that publishers can never overwrite each other's packages:
+//
+//	<account>/<plugin>/<version>/<file>
+//
+// All segments are derived server-side, the account comes from the access token
+// and the plugin version is normalized the same way plugin manifests are.
+
+// Prefix returns the namespace under which files for the given plugin version are stored.
+func Prefix(accountID, plugin, pluginVersion string) (string, error) {
+	ver, err := version.NewVersion(pluginVersion)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid plugin version")
+	}
+
+	for _, s := range []string{accountID, plugin} {
+		if !validSegment(s) {
+			return "", errors.Errorf("invalid key segment %q", s)
+		}
+	}
+
+	return path.Join(accountID, plugin, ver.String()), nil
+}
+
+// stagingDir holds the files uploaded to publish sessions until they are published. Staged
+// keys are never served by /files.
+const stagingDir = ".staging"
+
+// StagingPrefix returns the namespace under which the files sent in one upload to a publish
+// session are kept until the session is finalized:
+//
+//	.staging/<session>/<upload>/<file>
+//
+// Every upload gets its own namespace, so that uploads never replace files already staged,
+// which may be being verified or published at the time.
+func StagingPrefix(sessionID, uploadID string) (string, error) {
+	for _, s := range []string{sessionID, uploadID} {
+		if !validSegment(s) {
+			return "", errors.Errorf("invalid key segment %q", s)
+		}
+	}
+
+	return path.Join(stagingDir, sessionID, uploadID), nil
+}
+
+// SessionPrefix returns the namespace holding every upload to a publish session.
+func SessionPrefix(sessionID string) (string, error) {
+	if !validSegment(sessionID) {
+		return "", errors.Errorf("invalid key segment %q", sessionID)
+	}
+
+	return path.Join(stagingDir, sessionID), nil
+}
+
+// StagedSessions returns the IDs of the publish sessions with files staged in provider,
+// whether or not the sessions still exist.
+func StagedSessions(ctx context.Context, provider StorageProvider) ([]string, error) {
+	keys, err := provider.List(ctx, stagingDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed listing staged files")
+	}
+
+	seen := make(map[string]bool)
+	ids := make([]string, 0)
+	for _, key := range keys {
+		segments := strings.SplitN(key, "/", 3)
+		if len(segments) < 3 || seen[segments[1]] {
+			continue
+		}
+
+		seen[segments[1]] = true
+		ids = append(ids, segments[1])
+	}
+
+	return ids, nil
+}
+
+// Key returns the key under which a plugin package file is stored.
+func Key(accountID, plugin, pluginVersion, fileName string) (string, error) {
+	prefix, err := Prefix(accountID, plugin, pluginVersion)
+	if err != nil {
+		return "", err
+	}
+
+	return objectKey(prefix, fileName)
+}
+
+// objectKey joins a namespace prefix with a file name sent by the client.
+// Any directory given as part of the file name is discarded.
+func objectKey(prefix, fileName string) (string, error) {
+	name := path.Base(fileName)
+	if !validSegment(name) {
+		return "", errors.Errorf("invalid file name %q", fileName)
+	}
+
+	return prefix + "/" + name, nil
+}
+
+// validKey returns whether a key requested by a client follows our namespacing layout.
+func validKey(key string) bool {
+	segments := strings.Split(key, "/")
+	if len(segments) != 4 || segments[0] == stagingDir {
+		return false
+	}
+
+	for _, s := range segments {
+		if !validSegment(s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validSegment returns whether s can be safely used as a single key segment.
+func validSegment(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, "/\\")
+}
@@ -0,0 +1,254 @@
+package files
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Local implements the storage driver for the local filesystem.
+type Local struct {
+	root string
+}
+
+// NewLocal returns a new instance of a local filesystem storage provider
+// storing files under the given root directory.
+func NewLocal(root string) StorageProvider {
+	return &Local{
+		root: root,
+	}
+}
+
+// Upload writes file parts to disk as they arrive from the client.
+func (l *Local) Upload(ctx context.Context, prefix string, reader *multipart.Reader) ([]*Object, error) {
+	return uploadParts(ctx, l, prefix, reader, func(key string, part *multipart.Part) (*Object, error) {
+		body := newDigestReader(part)
+		if err := l.write(key, body); err != nil {
+			return nil, err
+		}
+
+		return &Object{
+			Key:         key,
+			Size:        body.Size(),
+			ContentType: partContentType(part),
+			Digests:     body.Digests(),
+		}, nil
+	})
+}
+
+// write stores the content of r under key. Content goes to a temporary file first
+// which is then linked into place, so readers never see partially written packages.
+// Linking fails if the key is taken, which keeps files from being replaced.
+func (l *Local) write(key string, r io.Reader) error {
+	filePath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "failed creating directory %q", dir)
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return errors.Wrapf(err, "failed creating temporary file for %q", key)
+	}
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed writing %q to disk", key)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed flushing %q to disk", key)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed closing temporary file for %q", key)
+	}
+
+	err = os.Link(tmp.Name(), filePath)
+	os.Remove(tmp.Name())
+	if os.IsExist(err) {
+		return errors.Wrapf(ErrExists, "failed storing %q", key)
+	}
+
+	return errors.Wrapf(err, "failed moving %q into place", key)
+}
+
+// Get streams down a package file from disk.
+// The caller must close the reader once it finishes reading from it.
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	filePath, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(ErrNotFound, "failed opening %q", key)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed opening %q", key)
+	}
+
+	return file, nil
+}
+
+// Stat returns information about a file stored on disk. Digests are calculated
+// by reading the whole file and, since the filesystem does not keep track of media
+// types, the content type is guessed from the file extension.
+func (l *Local) Stat(ctx context.Context, key string) (*Object, error) {
+	file, err := l.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	d := newDigestReader(file)
+	if _, err := io.Copy(ioutil.Discard, d); err != nil {
+		return nil, errors.Wrapf(err, "failed hashing %q", key)
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	return &Object{
+		Key:         key,
+		Size:        d.Size(),
+		ContentType: contentType,
+		Digests:     d.Digests(),
+	}, nil
+}
+
+// Move renames a file stored on disk. The file is linked under its new name and then unlinked
+// from the old one, since linking, unlike renaming, fails if the new name is taken.
+func (l *Local) Move(ctx context.Context, from, to string) error {
+	fromPath, err := l.path(from)
+	if err != nil {
+		return err
+	}
+
+	toPath, err := l.path(to)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(toPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "failed creating directory %q", dir)
+	}
+
+	err = os.Link(fromPath, toPath)
+	if os.IsExist(err) {
+		return errors.Wrapf(ErrExists, "failed moving %q to %q", from, to)
+	}
+
+	if os.IsNotExist(err) {
+		return errors.Wrapf(ErrNotFound, "failed moving %q", from)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "failed moving %q to %q", from, to)
+	}
+
+	return errors.Wrapf(os.Remove(fromPath), "failed removing %q after moving it", from)
+}
+
+// Delete removes a file stored on disk.
+func (l *Local) Delete(ctx context.Context, key string) error {
+	filePath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if os.IsNotExist(err) {
+		return errors.Wrapf(ErrNotFound, "failed deleting %q", key)
+	}
+
+	return errors.Wrapf(err, "failed deleting %q", key)
+}
+
+// List walks the directory of a prefix on disk, returning the keys of the files found.
+func (l *Local) List(ctx context.Context, prefix string) ([]string, error) {
+	dir, err := l.path(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+
+	return keys, errors.Wrapf(err, "failed listing %q", prefix)
+}
+
+// DeletePrefix removes the directory of a prefix from disk, as well as its parent directories
+// left empty, so that no trace of the prefix remains.
+func (l *Local) DeletePrefix(ctx context.Context, prefix string) error {
+	dir, err := l.path(prefix)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrapf(err, "failed deleting %q", prefix)
+	}
+
+	// Removing a directory fails if it is not empty, which is where pruning stops.
+	root := filepath.Clean(l.root)
+	for parent := filepath.Dir(dir); parent != root && strings.HasPrefix(parent, root); parent = filepath.Dir(parent) {
+		if os.Remove(parent) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// path maps a storage key to a file path inside the root directory. Keys are
+// cleaned as if they were absolute, so they can never point outside of it.
+func (l *Local) path(key string) (string, error) {
+	cleanKey := path.Clean("/" + key)
+	if cleanKey == "/" {
+		return "", errors.Errorf("invalid file name %q", key)
+	}
+
+	return filepath.Join(l.root, filepath.FromSlash(cleanKey)), nil
+}
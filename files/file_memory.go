@@ -0,0 +1,162 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Memory implements an in-memory storage driver. Files are lost once the process exits,
+// so it is only meant for tests and local development.
+type Memory struct {
+	sync.RWMutex
+	files map[string]*memoryFile
+}
+
+type memoryFile struct {
+	data        []byte
+	contentType string
+}
+
+// NewMemory returns a new instance of an in-memory storage provider.
+func NewMemory() StorageProvider {
+	return &Memory{
+		files: make(map[string]*memoryFile),
+	}
+}
+
+// Upload keeps file parts in memory as they arrive from the client.
+func (m *Memory) Upload(ctx context.Context, prefix string, reader *multipart.Reader) ([]*Object, error) {
+	return uploadParts(ctx, m, prefix, reader, func(key string, part *multipart.Part) (*Object, error) {
+		body := newDigestReader(part)
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed reading %q", part.FileName())
+		}
+
+		contentType := partContentType(part)
+
+		m.Lock()
+		_, exists := m.files[key]
+		if !exists {
+			m.files[key] = &memoryFile{
+				data:        data,
+				contentType: contentType,
+			}
+		}
+		m.Unlock()
+
+		if exists {
+			return nil, errors.Wrapf(ErrExists, "failed storing %q", key)
+		}
+
+		return &Object{
+			Key:         key,
+			Size:        body.Size(),
+			ContentType: contentType,
+			Digests:     body.Digests(),
+		}, nil
+	})
+}
+
+// Get streams down a package file from memory.
+// The caller must close the reader once it finishes reading from it.
+func (m *Memory) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.RLock()
+	file, ok := m.files[key]
+	m.RUnlock()
+
+	if !ok {
+		return nil, errors.Wrapf(ErrNotFound, "failed reading %q", key)
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(file.data)), nil
+}
+
+// Stat returns information about a file kept in memory.
+func (m *Memory) Stat(ctx context.Context, key string) (*Object, error) {
+	m.RLock()
+	file, ok := m.files[key]
+	m.RUnlock()
+
+	if !ok {
+		return nil, errors.Wrapf(ErrNotFound, "failed reading %q", key)
+	}
+
+	digests, err := computeDigests(bytes.NewReader(file.data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed hashing %q", key)
+	}
+
+	return &Object{
+		Key:         key,
+		Size:        int64(len(file.data)),
+		ContentType: file.contentType,
+		Digests:     digests,
+	}, nil
+}
+
+// Move renames a file kept in memory.
+func (m *Memory) Move(ctx context.Context, from, to string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	file, ok := m.files[from]
+	if !ok {
+		return errors.Wrapf(ErrNotFound, "failed moving %q", from)
+	}
+
+	if _, exists := m.files[to]; exists {
+		return errors.Wrapf(ErrExists, "failed moving %q to %q", from, to)
+	}
+
+	m.files[to] = file
+	delete(m.files, from)
+	return nil
+}
+
+// Delete removes a file kept in memory.
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.files[key]; !ok {
+		return errors.Wrapf(ErrNotFound, "failed deleting %q", key)
+	}
+
+	delete(m.files, key)
+	return nil
+}
+
+// List returns the keys of the files kept in memory under prefix.
+func (m *Memory) List(ctx context.Context, prefix string) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	keys := make([]string, 0)
+	for key := range m.files {
+		if strings.HasPrefix(key, prefix+"/") {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// DeletePrefix removes the files kept in memory under prefix.
+func (m *Memory) DeletePrefix(ctx context.Context, prefix string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for key := range m.files {
+		if strings.HasPrefix(key, prefix+"/") {
+			delete(m.files, key)
+		}
+	}
+	return nil
+}
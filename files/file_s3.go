@@ -3,20 +3,41 @@ package files
 import (
 	"context"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
+	"net/url"
+	"os"
+	"path"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 
 	"github.com/hooklift/lift-registry/config"
 	"github.com/pkg/errors"
 )
 
+// maxCopySize is the largest object S3 can write or copy in a single request. Moving objects
+// relies on such copies, so larger packages are rejected.
+const maxCopySize = 5 << 30
+
+// ifNoneMatch makes writes conditional on the key not being taken yet.
+func ifNoneMatch(r *request.Request) {
+	r.HTTPRequest.Header.Set("If-None-Match", "*")
+}
+
+// conditionFailed holds the error codes S3 returns when a conditional write finds the key
+// taken, or loses a race for it.
+var conditionFailed = map[string]bool{
+	"PreconditionFailed":         true,
+	"ConditionalRequestConflict": true,
+}
+
 // S3 implements the storage driver for AWS S3.
 type S3 struct {
-	uploader   *s3manager.Uploader
 	downloader *s3.S3
 }
 
@@ -27,42 +48,83 @@ func NewS3() StorageProvider {
 		panic(err)
 	}
 
-	uploader := s3manager.NewUploader(sess)
-	downloader := s3.New(sess)
-
 	return &S3{
-		uploader:   uploader,
-		downloader: downloader,
+		downloader: s3.New(sess),
 	}
 }
 
-// Upload uploads file parts to S3 as they arrive from the client.
-func (s *S3) Upload(ctx context.Context, reader *multipart.Reader) error {
-	for {
-		part, err := reader.NextPart()
-		if err == io.EOF {
-			break
+// Upload uploads file parts to S3 as they arrive from the client. Parts are first spooled
+// to a temporary file while being hashed, so that each object is written once, along with
+// its digests, and parts over 5 GB are rejected before anything is sent to S3.
+func (s *S3) Upload(ctx context.Context, prefix string, reader *multipart.Reader) ([]*Object, error) {
+	return uploadParts(ctx, s, prefix, reader, func(key string, part *multipart.Part) (*Object, error) {
+		// Checking for the key first spares buffering files bound to be refused, the write
+		// itself is conditional so that racing uploads cannot replace one another.
+		_, err := s.Stat(ctx, key)
+		if err == nil {
+			return nil, errors.Wrapf(ErrExists, "failed uploading %q to S3", key)
 		}
 
-		fileName := part.FileName()
-		if fileName == "" {
-			// Ignore form fields that are not actual files
-			continue
+		if errors.Cause(err) != ErrNotFound {
+			return nil, err
 		}
 
-		input := &s3manager.UploadInput{
-			Bucket: aws.String(config.S3Bucket),
-			Key:    aws.String(fileName),
-			Body:   part,
-		}
+		return s.put(ctx, key, partContentType(part), part)
+	})
+}
 
-		_, err = s.uploader.Upload(input)
-		if err != nil {
-			return errors.Wrapf(err, "failed uploading %q to S3", fileName)
-		}
+// put stores the content of r in S3 under key, with its digests as object metadata.
+func (s *S3) put(ctx context.Context, key, contentType string, r io.Reader) (*Object, error) {
+	file, err := ioutil.TempFile("", "upload-")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed creating temporary file for %q", key)
 	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
 
-	return nil
+	// Reading one byte past the limit is enough to tell the part is too large.
+	body := newDigestReader(io.LimitReader(r, maxCopySize+1))
+	if _, err := io.Copy(file, body); err != nil {
+		return nil, errors.Wrapf(err, "failed buffering %q", key)
+	}
+
+	if body.Size() > maxCopySize {
+		return nil, errors.Wrapf(ErrInvalidUpload, "%q is larger than the 5 GB supported for packages", path.Base(key))
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrapf(err, "failed rewinding %q", key)
+	}
+
+	digests := body.Digests()
+	_, err = s.downloader.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(config.S3Bucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(body.Size()),
+		Body:          file,
+		Metadata: map[string]*string{
+			SHA256: aws.String(digests[SHA256]),
+			SHA512: aws.String(digests[SHA512]),
+		},
+	}, ifNoneMatch)
+
+	if aerr, ok := err.(awserr.Error); ok && conditionFailed[aerr.Code()] {
+		return nil, errors.Wrapf(ErrExists, "failed uploading %q to S3", key)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed uploading %q to S3", key)
+	}
+
+	return &Object{
+		Key:         key,
+		Size:        body.Size(),
+		ContentType: contentType,
+		Digests:     digests,
+	}, nil
 }
 
 // Get streams down a package file from S3.
@@ -73,9 +135,139 @@ func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 		Key:    aws.String(key),
 	})
 
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, errors.Wrapf(ErrNotFound, "failed downloading %q from S3", key)
+	}
+
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed downloading %q from S3", key)
 	}
 
 	return result.Body, nil
 }
+
+// Stat returns information about an object stored in S3. Digests are read from
+// the object metadata set during upload.
+func (s *S3) Stat(ctx context.Context, key string) (*Object, error) {
+	result, err := s.downloader.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(config.S3Bucket),
+		Key:    aws.String(key),
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+		return nil, errors.Wrapf(ErrNotFound, "failed reading %q metadata from S3", key)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading %q metadata from S3", key)
+	}
+
+	digests := make(map[string]string)
+	for k, v := range result.Metadata {
+		// S3 returns metadata keys in canonical header format, i.e. Sha256.
+		digests[strings.ToLower(k)] = aws.StringValue(v)
+	}
+
+	return &Object{
+		Key:         key,
+		Size:        aws.Int64Value(result.ContentLength),
+		ContentType: aws.StringValue(result.ContentType),
+		Digests:     digests,
+	}, nil
+}
+
+// Move copies an object to its new key and deletes the original, since S3 cannot rename
+// objects. Copies keep the object metadata, including digests. Objects over 5 GB cannot be
+// moved, but Upload never stores them. As with uploads, the new key is checked for beforehand.
+func (s *S3) Move(ctx context.Context, from, to string) error {
+	_, err := s.Stat(ctx, to)
+	if err == nil {
+		return errors.Wrapf(ErrExists, "failed moving %q to %q in S3", from, to)
+	}
+
+	if errors.Cause(err) != ErrNotFound {
+		return err
+	}
+
+	_, err = s.downloader.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(config.S3Bucket),
+		Key:        aws.String(to),
+		CopySource: aws.String(url.PathEscape(config.S3Bucket + "/" + from)),
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return errors.Wrapf(ErrNotFound, "failed moving %q in S3", from)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "failed copying %q to %q in S3", from, to)
+	}
+
+	return s.Delete(ctx, from)
+}
+
+// Delete removes an object from S3. S3 does not report whether the object existed.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.downloader.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(config.S3Bucket),
+		Key:    aws.String(key),
+	})
+	return errors.Wrapf(err, "failed deleting %q from S3", key)
+}
+
+// maxDeleteObjects is the largest number of objects S3 can delete in a single request.
+const maxDeleteObjects = 1000
+
+// List returns the keys of the objects stored in S3 under prefix.
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(config.S3Bucket),
+		Prefix: aws.String(prefix + "/"),
+	}
+
+	err := s.downloader.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			keys = append(keys, aws.StringValue(o.Key))
+		}
+		return true
+	})
+
+	return keys, errors.Wrapf(err, "failed listing %q in S3", prefix)
+}
+
+// DeletePrefix removes the objects stored in S3 under prefix, in batches.
+func (s *S3) DeletePrefix(ctx context.Context, prefix string) error {
+	keys, err := s.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxDeleteObjects {
+			n = maxDeleteObjects
+		}
+
+		objects := make([]*s3.ObjectIdentifier, 0, n)
+		for _, key := range keys[:n] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		keys = keys[n:]
+
+		result, err := s.downloader.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(config.S3Bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed deleting %q from S3", prefix)
+		}
+
+		if len(result.Errors) > 0 {
+			e := result.Errors[0]
+			return errors.Errorf("failed deleting %q from S3: %s", aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+	}
+
+	return nil
+}
@@ -5,60 +5,264 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
-	"path"
+	"net/url"
 	"strings"
 
 	"github.com/golang/glog"
+	"github.com/hooklift/lift-registry/config"
+	"github.com/hooklift/lift-registry/pkg/auth"
 	"github.com/hooklift/lift-registry/pkg/render"
-	identity "github.com/hooklift/uaa/pkg/client"
+	"github.com/pkg/errors"
 )
 
+// ErrNotFound is returned by storage providers when the requested file does not exist.
+var ErrNotFound = errors.New("file not found")
+
+// ErrExists is returned by storage providers when storing a file under a key already taken.
+// Stored files are never replaced, so that published packages cannot change.
+var ErrExists = errors.New("file already exists")
+
+// ErrInvalidUpload is returned by storage providers when the files sent by a client cannot be
+// stored, such as multipart bodies failing to be read, invalid file names or files too large.
+var ErrInvalidUpload = errors.New("invalid upload")
+
 // StorageProvider defines the contract for storage providers.
 type StorageProvider interface {
-	Upload(ctx context.Context, reader *multipart.Reader) error
+	// Upload stores every file part found in reader under the given prefix, hashing them as they are streamed.
+	// It fails with ErrExists if a file is already stored under the same key, and with ErrInvalidUpload if
+	// the files sent cannot be stored.
+	Upload(ctx context.Context, prefix string, reader *multipart.Reader) ([]*Object, error)
+	// Get returns a reader for the file stored under filepath.
 	Get(ctx context.Context, filepath string) (io.ReadCloser, error)
+	// Stat returns information about the file stored under filepath, including its digests.
+	Stat(ctx context.Context, filepath string) (*Object, error)
+	// Move renames the file stored under from. Digests are kept. Like Upload, it fails with
+	// ErrExists if a file is already stored under to.
+	Move(ctx context.Context, from, to string) error
+	// Delete removes the file stored under filepath.
+	Delete(ctx context.Context, filepath string) error
+	// List returns the keys of every file stored under prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	// DeletePrefix removes every file stored under prefix.
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// Object describes a file kept by a storage provider.
+type Object struct {
+	// Key is the name under which the file is stored.
+	Key string `json:"key"`
+	// URL is the canonical URL to download the file from.
+	URL string `json:"url"`
+	// Size is the file size in bytes.
+	Size int64 `json:"size"`
+	// ContentType is the media type of the file, as sent by the client when uploading it.
+	ContentType string `json:"content_type"`
+	// Digests maps each supported hashing algorithm to the hex encoded digest of the file content.
+	Digests map[string]string `json:"digests"`
+}
+
+// uploadParts stores every file part found in reader under prefix, through store. If a part
+// fails, the files already stored by the call are deleted before returning, so that clients can
+// retry the whole upload.
+func uploadParts(ctx context.Context, provider StorageProvider, prefix string, reader *multipart.Reader,
+	store func(key string, part *multipart.Part) (*Object, error)) ([]*Object, error) {
+	objects := make([]*Object, 0)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			deleteObjects(ctx, provider, objects)
+			return nil, errors.Wrapf(ErrInvalidUpload, "failed reading multipart body: %v", err)
+		}
+
+		fileName := part.FileName()
+		if fileName == "" {
+			// Ignore form fields that are not actual files
+			continue
+		}
+
+		key, err := objectKey(prefix, fileName)
+		if err != nil {
+			deleteObjects(ctx, provider, objects)
+			return nil, errors.Wrapf(ErrInvalidUpload, "invalid file name %q", fileName)
+		}
+
+		o, err := store(key, part)
+		if err != nil {
+			deleteObjects(ctx, provider, objects)
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+
+	return objects, nil
+}
+
+// deleteObjects removes files stored by a failed upload.
+func deleteObjects(ctx context.Context, provider StorageProvider, objects []*Object) {
+	for _, o := range objects {
+		if err := provider.Delete(ctx, o.Key); err != nil {
+			glog.Errorf("failed deleting %q after a failed upload: %+v", o.Key, err)
+		}
+	}
+}
+
+// defaultContentType is used when clients do not specify the media type of the files they upload.
+const defaultContentType = "application/octet-stream"
+
+// partContentType returns the media type of a multipart part.
+func partContentType(part *multipart.Part) string {
+	if ct := part.Header.Get("Content-Type"); ct != "" {
+		return ct
+	}
+	return defaultContentType
+}
+
+// URL returns the canonical URL to download the file stored under key.
+func URL(key string) string {
+	u := url.URL{
+		Scheme: "https",
+		Host:   config.PrimaryDomain,
+		Path:   "/files/" + key,
+	}
+	return u.String()
+}
+
+// NewProvider returns the storage provider selected by config.StorageDriver.
+func NewProvider() StorageProvider {
+	switch config.StorageDriver {
+	case "local":
+		return NewLocal(config.StorageDir)
+	default:
+		return NewS3()
+	}
 }
 
 // Response is the type of the payload sent back as response for uploading files.
 type Response struct {
-	URLs []string
+	// URLs lists the download URL of every file stored, in the same order they were sent.
+	URLs []string `json:"urls"`
+	// Files describes every file stored, in the same order they were sent.
+	Files []*Object `json:"files"`
+}
+
+// PublishedFunc reports whether a plugin version has already been published. Files of published
+// versions cannot be uploaded anymore.
+type PublishedFunc func(ctx context.Context, plugin, pluginVersion string) (bool, error)
+
+// deprecatedUploadWarning is sent back on uploads to /files, as defined in RFC 7234.
+const deprecatedUploadWarning = `299 - "Uploading to /files is deprecated, upload packages to a publish session instead"`
+
+// service serves /files requests using a given storage provider.
+type service struct {
+	provider  StorageProvider
+	published PublishedFunc
 }
 
-// upload streams up file packages to S3 and returns their URLs once it finishes.
-func upload(w http.ResponseWriter, r *http.Request) {
-	token, ok := identity.FromContext(r.Context())
+// upload streams up file packages to the storage provider and returns their URLs once it finishes.
+// Files are uploaded to /files/<plugin>/<version> and stored under the namespace of the account
+// owning the access token. Files already stored, or belonging to published versions, are refused.
+//
+// Uploading files this way is deprecated in favor of publish sessions, which verify packages
+// before making them visible. Responses carry a Warning header saying so.
+func (s *service) upload(w http.ResponseWriter, r *http.Request) {
+	account, ok := auth.FromContext(r.Context())
 	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	_, oka := token.Scopes["admin"]
-	_, okw := token.Scopes["write"]
+	if !account.HasScope("admin", "write") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
-	if !oka || !okw {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/files"), "/"), "/")
+	if len(segments) != 2 {
+		http.Error(w, "files must be uploaded to /files/<plugin>/<version>", http.StatusBadRequest)
+		return
+	}
+
+	prefix, err := Prefix(account.ID, segments[0], segments[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	reader, err := r.MultipartReader()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	w.Header().Set("Warning", deprecatedUploadWarning)
+	glog.Warningf("account %q uploaded files to %q outside of a publish session", account.ID, prefix)
+
 	ctx := r.Context()
-	if err := provider.Upload(ctx, reader); err != nil {
+	if s.published != nil {
+		published, err := s.published(ctx, segments[0], segments[1])
+		if err != nil {
+			glog.Errorf("failed checking whether %s@%s is published: %+v", segments[0], segments[1], err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if published {
+			http.Error(w, "version already published, its files cannot change", http.StatusConflict)
+			return
+		}
+	}
+
+	objects, err := s.provider.Upload(ctx, prefix, reader)
+	if errors.Cause(err) == ErrExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if errors.Cause(err) == ErrInvalidUpload {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	render.JSON(w)
+	res := &Response{
+		URLs:  make([]string, 0, len(objects)),
+		Files: objects,
+	}
+
+	for _, o := range objects {
+		o.URL = URL(o.Key)
+		res.URLs = append(res.URLs, o.URL)
+	}
+
+	if err := render.JSON(w, render.WithBody(res)); err != nil {
+		glog.Errorf("failed rendering upload response: %+v", err)
+	}
 }
 
 // getPackage streams the requested file down to the user from the storage provider.
-func getPackage(w http.ResponseWriter, r *http.Request) {
+// Files are downloaded from /files/<account>/<plugin>/<version>/<file>.
+func (s *service) getPackage(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/files/")
+	if !validKey(key) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
 	ctx := r.Context()
-	reader, err := provider.Get(ctx, path.Base(r.URL.Path))
+	reader, err := s.provider.Get(ctx, key)
+	if errors.Cause(err) == ErrNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -74,21 +278,21 @@ func getPackage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-var handlers = map[string]func(http.ResponseWriter, *http.Request){
-	"POST": upload,
-	"GET":  getPackage,
-}
-
-var provider StorageProvider
+// Handler handles /files requests, storing and retrieving files through the given storage provider.
+// Uploads for versions reported as published by published are refused. It can be nil.
+func Handler(h http.Handler, provider StorageProvider, published PublishedFunc) http.Handler {
+	s := &service{
+		provider:  provider,
+		published: published,
+	}
 
-// Handler handles /files requests.
-func Handler(h http.Handler) http.Handler {
 	registry := map[string]map[string]func(http.ResponseWriter, *http.Request){
-		"/files": handlers,
+		"/files": {
+			"POST": s.upload,
+			"GET":  s.getPackage,
+		},
 	}
 
-	provider = NewS3()
-
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		for p, handlers := range registry {
 			if strings.HasPrefix(req.URL.Path, p) {
@@ -1,6 +1,613 @@
 package files
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
 
-func TestUpload(t *testing.T)   {}
-func TestDownload(t *testing.T) {}
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/pkg/auth"
+)
+
+// formPart is a part of a multipart request body. Parts without file name are sent as regular form fields.
+type formPart struct {
+	fieldName string
+	fileName  string
+	content   string
+}
+
+func multipartBody(t *testing.T, parts []formPart) (*bytes.Buffer, string) {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+
+	for _, p := range parts {
+		if p.fileName == "" {
+			if err := writer.WriteField(p.fieldName, p.content); err != nil {
+				t.Fatalf("failed writing form field: %+v", err)
+			}
+			continue
+		}
+
+		fw, err := writer.CreateFormFile(p.fieldName, p.fileName)
+		if err != nil {
+			t.Fatalf("failed creating form file: %+v", err)
+		}
+
+		if _, err := io.WriteString(fw, p.content); err != nil {
+			t.Fatalf("failed writing form file: %+v", err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed closing multipart writer: %+v", err)
+	}
+
+	return body, writer.FormDataContentType()
+}
+
+func readFile(t *testing.T, provider StorageProvider, key string) (string, bool) {
+	reader, err := provider.Get(context.Background(), key)
+	if err != nil {
+		return "", false
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed reading %q: %+v", key, err)
+	}
+	return string(data), true
+}
+
+func TestUpload(t *testing.T) {
+	writer := &auth.Account{ID: "acc1", Scopes: map[string]bool{"write": true}}
+
+	tests := []struct {
+		desc        string
+		account     *auth.Account
+		path        string
+		parts       []formPart
+		contentType string
+		published   bool
+		status      int
+		stored      map[string]string
+		notStored   []string
+	}{
+		{
+			desc:    "multiple files",
+			account: writer,
+			path:    "/files/myplugin/1.0.0",
+			parts: []formPart{
+				{fieldName: "file", fileName: "plugin-linux-x64.tar.gz", content: "linux bits"},
+				{fieldName: "file", fileName: "plugin-darwin-x64.tar.gz", content: "darwin bits"},
+			},
+			status: http.StatusOK,
+			stored: map[string]string{
+				"acc1/myplugin/1.0.0/plugin-linux-x64.tar.gz":  "linux bits",
+				"acc1/myplugin/1.0.0/plugin-darwin-x64.tar.gz": "darwin bits",
+			},
+		},
+		{
+			desc:    "admin scope",
+			account: &auth.Account{ID: "acc1", Scopes: map[string]bool{"admin": true}},
+			path:    "/files/myplugin/1.0.0",
+			parts: []formPart{
+				{fieldName: "file", fileName: "plugin.tar.gz", content: "bits"},
+			},
+			status: http.StatusOK,
+			stored: map[string]string{"acc1/myplugin/1.0.0/plugin.tar.gz": "bits"},
+		},
+		{
+			desc:    "version is normalized",
+			account: writer,
+			path:    "/files/myplugin/1.2",
+			parts: []formPart{
+				{fieldName: "file", fileName: "plugin.tar.gz", content: "bits"},
+			},
+			status: http.StatusOK,
+			stored: map[string]string{"acc1/myplugin/1.2.0/plugin.tar.gz": "bits"},
+		},
+		{
+			desc:    "directories in file names are discarded",
+			account: writer,
+			path:    "/files/myplugin/1.0.0",
+			parts: []formPart{
+				{fieldName: "file", fileName: "../../acc2/myplugin/1.0.0/plugin.tar.gz", content: "bits"},
+			},
+			status:    http.StatusOK,
+			stored:    map[string]string{"acc1/myplugin/1.0.0/plugin.tar.gz": "bits"},
+			notStored: []string{"acc2/myplugin/1.0.0/plugin.tar.gz"},
+		},
+		{
+			desc:    "parts without file name are ignored",
+			account: writer,
+			path:    "/files/myplugin/1.0.0",
+			parts: []formPart{
+				{fieldName: "description", content: "not a file"},
+				{fieldName: "file", fileName: "plugin.tar.gz", content: "bits"},
+			},
+			status:    http.StatusOK,
+			stored:    map[string]string{"acc1/myplugin/1.0.0/plugin.tar.gz": "bits"},
+			notStored: []string{"acc1/myplugin/1.0.0/description"},
+		},
+		{
+			desc:    "missing token",
+			account: nil,
+			path:    "/files/myplugin/1.0.0",
+			parts: []formPart{
+				{fieldName: "file", fileName: "plugin.tar.gz", content: "bits"},
+			},
+			status:    http.StatusUnauthorized,
+			notStored: []string{"acc1/myplugin/1.0.0/plugin.tar.gz"},
+		},
+		{
+			desc:    "insufficient scope",
+			account: &auth.Account{ID: "acc1", Scopes: map[string]bool{"read": true}},
+			path:    "/files/myplugin/1.0.0",
+			parts: []formPart{
+				{fieldName: "file", fileName: "plugin.tar.gz", content: "bits"},
+			},
+			status:    http.StatusForbidden,
+			notStored: []string{"acc1/myplugin/1.0.0/plugin.tar.gz"},
+		},
+		{
+			desc:    "missing plugin version",
+			account: writer,
+			path:    "/files/myplugin",
+			parts: []formPart{
+				{fieldName: "file", fileName: "plugin.tar.gz", content: "bits"},
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			desc:    "invalid plugin version",
+			account: writer,
+			path:    "/files/myplugin/latest",
+			parts: []formPart{
+				{fieldName: "file", fileName: "plugin.tar.gz", content: "bits"},
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			desc:    "invalid file name",
+			account: writer,
+			path:    "/files/myplugin/1.0.0",
+			parts: []formPart{
+				{fieldName: "file", fileName: "..", content: "bits"},
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			desc:      "published version",
+			account:   writer,
+			path:      "/files/myplugin/1.0.0",
+			published: true,
+			parts: []formPart{
+				{fieldName: "file", fileName: "plugin.tar.gz", content: "bits"},
+			},
+			status:    http.StatusConflict,
+			notStored: []string{"acc1/myplugin/1.0.0/plugin.tar.gz"},
+		},
+		{
+			desc:        "not a multipart request",
+			account:     writer,
+			path:        "/files/myplugin/1.0.0",
+			contentType: "application/json",
+			status:      http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			provider := NewMemory()
+			published := func(ctx context.Context, plugin, pluginVersion string) (bool, error) {
+				return tt.published, nil
+			}
+			handler := Handler(http.NotFoundHandler(), provider, published)
+
+			body, contentType := multipartBody(t, tt.parts)
+			if tt.contentType != "" {
+				contentType = tt.contentType
+			}
+
+			req := httptest.NewRequest("POST", tt.path, body)
+			req.Header.Set("Content-Type", contentType)
+			if tt.account != nil {
+				req = req.WithContext(auth.NewContext(req.Context(), tt.account))
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+
+			if w.Code == http.StatusOK {
+				res := new(Response)
+				if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+					t.Fatalf("failed decoding response: %+v", err)
+				}
+
+				if len(res.Files) != len(tt.stored) {
+					t.Fatalf("expected %d files in response, got %d", len(tt.stored), len(res.Files))
+				}
+
+				if len(res.URLs) != len(res.Files) {
+					t.Fatalf("expected %d URLs in response, got %d", len(res.Files), len(res.URLs))
+				}
+
+				for i, f := range res.Files {
+					if f.URL != URL(f.Key) || res.URLs[i] != f.URL {
+						t.Errorf("unexpected download URL for %q: %q", f.Key, f.URL)
+					}
+
+					if f.Size != int64(len(tt.stored[f.Key])) {
+						t.Errorf("expected %q to be %d bytes long, got %d", f.Key, len(tt.stored[f.Key]), f.Size)
+					}
+
+					if f.ContentType != "application/octet-stream" {
+						t.Errorf("unexpected content type for %q: %q", f.Key, f.ContentType)
+					}
+
+					sum256 := sha256.Sum256([]byte(tt.stored[f.Key]))
+					if f.Digests[SHA256] != hex.EncodeToString(sum256[:]) {
+						t.Errorf("unexpected sha256 digest for %q: %q", f.Key, f.Digests[SHA256])
+					}
+
+					sum512 := sha512.Sum512([]byte(tt.stored[f.Key]))
+					if f.Digests[SHA512] != hex.EncodeToString(sum512[:]) {
+						t.Errorf("unexpected sha512 digest for %q: %q", f.Key, f.Digests[SHA512])
+					}
+				}
+			}
+
+			for key, content := range tt.stored {
+				got, ok := readFile(t, provider, key)
+				if !ok {
+					t.Errorf("expected %q to be stored", key)
+					continue
+				}
+
+				if got != content {
+					t.Errorf("expected %q to contain %q, got %q", key, content, got)
+				}
+			}
+
+			for _, key := range tt.notStored {
+				if _, ok := readFile(t, provider, key); ok {
+					t.Errorf("expected %q not to be stored", key)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadNeverReplaces(t *testing.T) {
+	root, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatalf("failed creating temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(root)
+
+	providers := map[string]StorageProvider{
+		"memory": NewMemory(),
+		"local":  NewLocal(root),
+	}
+
+	for name, provider := range providers {
+		t.Run(name, func(t *testing.T) {
+			handler := Handler(http.NotFoundHandler(), provider, nil)
+			for i, tt := range []struct {
+				content string
+				status  int
+			}{
+				{"plugin bits", http.StatusOK},
+				{"tampered bits", http.StatusConflict},
+			} {
+				body, contentType := multipartBody(t, []formPart{
+					{fieldName: "file", fileName: "plugin.tar.gz", content: tt.content},
+				})
+
+				req := httptest.NewRequest("POST", "/files/myplugin/1.0.0", body)
+				req.Header.Set("Content-Type", contentType)
+				req = req.WithContext(auth.NewContext(req.Context(), &auth.Account{
+					ID:     "acc1",
+					Scopes: map[string]bool{"write": true},
+				}))
+
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				if w.Code != tt.status {
+					t.Fatalf("upload %d: expected status %d, got %d: %s", i+1, tt.status, w.Code, w.Body.String())
+				}
+
+				if w.Header().Get("Warning") != deprecatedUploadWarning {
+					t.Errorf("upload %d: expected deprecation warning, got %q", i+1, w.Header().Get("Warning"))
+				}
+			}
+
+			if got, _ := readFile(t, provider, "acc1/myplugin/1.0.0/plugin.tar.gz"); got != "plugin bits" {
+				t.Errorf("expected first upload to be kept, got %q", got)
+			}
+		})
+	}
+}
+
+func TestDownload(t *testing.T) {
+	provider := NewMemory()
+	handler := Handler(http.NotFoundHandler(), provider, nil)
+
+	body, contentType := multipartBody(t, []formPart{
+		{fieldName: "file", fileName: "plugin.tar.gz", content: "plugin bits"},
+	})
+
+	req := httptest.NewRequest("POST", "/files/myplugin/1.0.0", body)
+	req.Header.Set("Content-Type", contentType)
+	req = req.WithContext(auth.NewContext(req.Context(), &auth.Account{
+		ID:     "acc1",
+		Scopes: map[string]bool{"write": true},
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed uploading test file: %d %s", w.Code, w.Body.String())
+	}
+
+	body, contentType = multipartBody(t, []formPart{
+		{fieldName: "file", fileName: "plugin.tar.gz", content: "staged bits"},
+	})
+
+	reader := multipart.NewReader(body, strings.TrimPrefix(contentType, "multipart/form-data; boundary="))
+	if _, err := provider.Upload(req.Context(), ".staging/session1/upload1", reader); err != nil {
+		t.Fatalf("failed staging test file: %+v", err)
+	}
+
+	tests := []struct {
+		desc   string
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"existing file", "GET", "/files/acc1/myplugin/1.0.0/plugin.tar.gz", http.StatusOK, "plugin bits"},
+		{"missing file", "GET", "/files/acc1/myplugin/1.0.0/missing.tar.gz", http.StatusNotFound, ""},
+		{"other account namespace", "GET", "/files/acc2/myplugin/1.0.0/plugin.tar.gz", http.StatusNotFound, ""},
+		{"file outside of namespace", "GET", "/files/plugin.tar.gz", http.StatusNotFound, ""},
+		{"staged file", "GET", "/files/.staging/session1/upload1/plugin.tar.gz", http.StatusNotFound, ""},
+		{"path traversal", "GET", "/files/acc1/myplugin/../../plugin.tar.gz", http.StatusNotFound, ""},
+		{"unsupported method", "DELETE", "/files/acc1/myplugin/1.0.0/plugin.tar.gz", http.StatusMethodNotAllowed, ""},
+		{"other paths are forwarded", "GET", "/search", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("expected body %q, got %q", tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMoveAndDelete(t *testing.T) {
+	root, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatalf("failed creating temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(root)
+
+	providers := map[string]StorageProvider{
+		"memory": NewMemory(),
+		"local":  NewLocal(root),
+	}
+
+	for name, provider := range providers {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			body, contentType := multipartBody(t, []formPart{
+				{fieldName: "file", fileName: "plugin.tar.gz", content: "plugin bits"},
+			})
+
+			reader := multipart.NewReader(body, strings.TrimPrefix(contentType, "multipart/form-data; boundary="))
+			if _, err := provider.Upload(ctx, ".staging/session1/upload1", reader); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			from, to := ".staging/session1/upload1/plugin.tar.gz", "acc1/myplugin/1.0.0/plugin.tar.gz"
+			if err := provider.Move(ctx, from, to); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			if got, ok := readFile(t, provider, to); !ok || got != "plugin bits" {
+				t.Errorf("expected %q to contain moved file, got %q", to, got)
+			}
+
+			if _, ok := readFile(t, provider, from); ok {
+				t.Errorf("expected %q to be moved away", from)
+			}
+
+			if err := provider.Move(ctx, from, to); errors.Cause(err) != ErrNotFound {
+				t.Errorf("expected ErrNotFound moving a missing file, got %v", err)
+			}
+
+			// Moving never replaces stored files.
+			body, contentType = multipartBody(t, []formPart{
+				{fieldName: "file", fileName: "plugin.tar.gz", content: "other bits"},
+			})
+
+			reader = multipart.NewReader(body, strings.TrimPrefix(contentType, "multipart/form-data; boundary="))
+			if _, err := provider.Upload(ctx, ".staging/session1/upload2", reader); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			other := ".staging/session1/upload2/plugin.tar.gz"
+			if err := provider.Move(ctx, other, to); errors.Cause(err) != ErrExists {
+				t.Errorf("expected ErrExists moving onto a stored file, got %v", err)
+			}
+
+			if got, ok := readFile(t, provider, to); !ok || got != "plugin bits" {
+				t.Errorf("expected %q to keep its content, got %q", to, got)
+			}
+
+			if got, ok := readFile(t, provider, other); !ok || got != "other bits" {
+				t.Errorf("expected %q to be left in place, got %q", other, got)
+			}
+
+			if err := provider.Delete(ctx, to); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			if _, ok := readFile(t, provider, to); ok {
+				t.Errorf("expected %q to be deleted", to)
+			}
+
+			if err := provider.Delete(ctx, to); errors.Cause(err) != ErrNotFound {
+				t.Errorf("expected ErrNotFound deleting a missing file, got %v", err)
+			}
+		})
+	}
+}
+
+func TestListAndDeletePrefix(t *testing.T) {
+	root, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatalf("failed creating temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(root)
+
+	providers := map[string]StorageProvider{
+		"memory": NewMemory(),
+		"local":  NewLocal(filepath.Join(root, "files")),
+	}
+
+	for name, provider := range providers {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, prefix := range []string{".staging/session1/upload1", ".staging/session1/upload2", ".staging/session2/upload1", "acc1/myplugin/1.0.0"} {
+				body, contentType := multipartBody(t, []formPart{
+					{fieldName: "file", fileName: "plugin.tar.gz", content: "plugin bits"},
+				})
+
+				reader := multipart.NewReader(body, strings.TrimPrefix(contentType, "multipart/form-data; boundary="))
+				if _, err := provider.Upload(ctx, prefix, reader); err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+			}
+
+			keys, err := provider.List(ctx, ".staging/session1")
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			sort.Strings(keys)
+			expected := []string{".staging/session1/upload1/plugin.tar.gz", ".staging/session1/upload2/plugin.tar.gz"}
+			if !reflect.DeepEqual(keys, expected) {
+				t.Errorf("expected keys %v, got %v", expected, keys)
+			}
+
+			ids, err := StagedSessions(ctx, provider)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			sort.Strings(ids)
+			if expected := []string{"session1", "session2"}; !reflect.DeepEqual(ids, expected) {
+				t.Errorf("expected staged sessions %v, got %v", expected, ids)
+			}
+
+			if keys, err := provider.List(ctx, ".staging/session3"); err != nil || len(keys) != 0 {
+				t.Errorf("expected no keys under a missing prefix, got %v (%v)", keys, err)
+			}
+
+			for _, prefix := range []string{".staging/session1", ".staging/session2"} {
+				if err := provider.DeletePrefix(ctx, prefix); err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+			}
+
+			if ids, err := StagedSessions(ctx, provider); err != nil || len(ids) != 0 {
+				t.Errorf("expected no staged sessions left, got %v (%v)", ids, err)
+			}
+
+			if _, ok := readFile(t, provider, "acc1/myplugin/1.0.0/plugin.tar.gz"); !ok {
+				t.Error("expected files outside of the deleted prefixes to be kept")
+			}
+		})
+	}
+
+	// No empty directories are left behind on disk.
+	entries, err := ioutil.ReadDir(filepath.Join(root, "files"))
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(entries) != 1 || entries[0].Name() != "acc1" {
+		t.Errorf("expected only acc1 to be left on disk, got %v", entries)
+	}
+}
+
+func TestUploadFailureRemovesStoredParts(t *testing.T) {
+	root, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatalf("failed creating temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(root)
+
+	providers := map[string]StorageProvider{
+		"memory": NewMemory(),
+		"local":  NewLocal(root),
+	}
+
+	for name, provider := range providers {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			upload := func(parts []formPart) error {
+				body, contentType := multipartBody(t, parts)
+				reader := multipart.NewReader(body, strings.TrimPrefix(contentType, "multipart/form-data; boundary="))
+				_, err := provider.Upload(ctx, "acc1/myplugin/1.0.0", reader)
+				return err
+			}
+
+			err := upload([]formPart{
+				{fieldName: "file", fileName: "plugin.tar.gz", content: "plugin bits"},
+				{fieldName: "file", fileName: "..", content: "invalid"},
+			})
+			if errors.Cause(err) != ErrInvalidUpload {
+				t.Fatalf("expected ErrInvalidUpload for an invalid file name, got %v", err)
+			}
+
+			if _, ok := readFile(t, provider, "acc1/myplugin/1.0.0/plugin.tar.gz"); ok {
+				t.Fatal("expected parts stored by the failed upload to be deleted")
+			}
+
+			// Retrying does not conflict with the failed attempt.
+			if err := upload([]formPart{{fieldName: "file", fileName: "plugin.tar.gz", content: "plugin bits"}}); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+		})
+	}
+}
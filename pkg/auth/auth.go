@@ -0,0 +1,58 @@
+// Package auth exposes the account making a request, as asserted by the access
+// token verified against Hooklift identity service.
+package auth
+
+import (
+	"context"
+
+	identity "github.com/hooklift/uaa/pkg/client"
+)
+
+// Account is the authenticated account making a request.
+type Account struct {
+	// ID is the account identifier, taken from the access token subject.
+	ID string
+	// Scopes is the set of scopes granted to the access token.
+	Scopes map[string]bool
+}
+
+// HasScope returns whether the account was granted any of the given scopes.
+func (a *Account) HasScope(scopes ...string) bool {
+	for _, s := range scopes {
+		if a.Scopes[s] {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the given account. An account set this
+// way takes precedence over the access token, which is mostly useful for tests.
+func NewContext(ctx context.Context, a *Account) context.Context {
+	return context.WithValue(ctx, contextKey{}, a)
+}
+
+// FromContext returns the account making the request, if any.
+func FromContext(ctx context.Context) (*Account, bool) {
+	if a, ok := ctx.Value(contextKey{}).(*Account); ok {
+		return a, true
+	}
+
+	token, ok := identity.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	a := &Account{
+		ID:     token.Subject,
+		Scopes: make(map[string]bool),
+	}
+
+	for s := range token.Scopes {
+		a.Scopes[s] = true
+	}
+
+	return a, true
+}
@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	version "github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
+)
+
+// Deprecation warns users that a plugin version should no longer be installed.
+type Deprecation struct {
+	// Message explains why the version is deprecated.
+	Message string `json:"message"`
+	// Replacement is the name of the plugin to use instead, if any.
+	Replacement string `json:"replacement"`
+}
+
+// Deprecate marks the versions of a plugin matching a version constraint as deprecated.
+// An empty constraint deprecates every published version. Only the plugin owner and its
+// maintainers can deprecate versions, unless admin is set.
+func Deprecate(ctx context.Context, name, constraint, accountID string, d *Deprecation, admin bool) error {
+	verr := new(ValidationError)
+	if d == nil || d.Message == "" {
+		verr.Add("message", "deprecation message is required")
+		return verr
+	}
+
+	if d.Replacement != "" {
+		if d.Replacement == name {
+			verr.Add("replacement", "a plugin cannot replace itself")
+			return verr
+		}
+
+		if _, err := Versions(ctx, d.Replacement); err != nil {
+			if _, ok := errors.Cause(err).(*NotFoundError); !ok {
+				return err
+			}
+			verr.Add("replacement", fmt.Sprintf("replacement plugin %q does not exist", d.Replacement))
+			return verr
+		}
+	}
+
+	return setDeprecation(ctx, name, constraint, accountID, "deprecate", admin, d)
+}
+
+// Undeprecate removes the deprecation of the versions of a plugin matching a version constraint.
+// An empty constraint undeprecates the whole plugin, and every published version.
+func Undeprecate(ctx context.Context, name, constraint, accountID string, admin bool) error {
+	return setDeprecation(ctx, name, constraint, accountID, "undeprecate", admin, nil)
+}
+
+// setDeprecation atomically sets, or removes if d is nil, the deprecation of all the published
+// versions of a plugin matching a version constraint, on behalf of one of its maintainers. An
+// empty constraint also sets the deprecation of the plugin itself, in its ownership record, so
+// that versions published afterwards inherit it.
+func setDeprecation(ctx context.Context, name, constraint, accountID, action string, admin bool, d *Deprecation) error {
+	if name == "" {
+		return errors.New("plugin name is required")
+	}
+
+	if accountID == "" {
+		return errors.New("account ID is required")
+	}
+
+	var constraints version.Constraints
+	if constraint != "" {
+		c, err := version.NewConstraint(constraint)
+		if err != nil {
+			verr := new(ValidationError)
+			verr.Add("constraint", fmt.Sprintf("%q is not a valid version constraint", constraint))
+			return verr
+		}
+		constraints = c
+	}
+
+	// Publishing holds ownershipMu, so no version can be published without seeing the
+	// deprecation of the plugin, nor be left out of the versions updated here.
+	ownershipMu.Lock()
+	defer ownershipMu.Unlock()
+
+	if err := checkMaintainer(ctx, name, accountID, action, admin); err != nil {
+		return err
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	manifests, err := Versions(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	matches := make([]*Manifest, 0, len(manifests))
+	for _, m := range manifests {
+		ver, err := version.NewVersion(m.Version)
+		if err != nil {
+			continue
+		}
+
+		if constraints == nil || constraints.Check(ver) {
+			matches = append(matches, m)
+		}
+	}
+
+	if len(matches) == 0 {
+		verr := new(ValidationError)
+		verr.Add("constraint", fmt.Sprintf("no published version of plugin %q matches %q", name, constraint))
+		return verr
+	}
+
+	now := time.Now()
+	for _, m := range matches {
+		m.Deprecation = copyDeprecation(d)
+		m.UpdatedAt = now
+	}
+
+	if err := Repo.Update(ctx, matches...); err != nil {
+		return errors.Wrapf(err, "failed updating versions of %q", name)
+	}
+
+	if constraints != nil {
+		return nil
+	}
+
+	o, err := GetOwnership(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	o.Deprecation = copyDeprecation(d)
+	return errors.Wrapf(Repo.SaveOwnership(ctx, o), "failed updating deprecation of %q", name)
+}
+
+// copyDeprecation returns a copy of d, so that manifests and ownership records do not share it.
+func copyDeprecation(d *Deprecation) *Deprecation {
+	if d == nil {
+		return nil
+	}
+
+	copied := *d
+	return &copied
+}
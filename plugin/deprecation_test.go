@@ -0,0 +1,126 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+func TestDeprecate(t *testing.T) {
+	repo := plugintest.NewMemoryRepository(
+		&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.0.0"},
+		&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.5.0"},
+		&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "2.0.0"},
+		&plugin.Manifest{AccountID: "acc2", Name: "golint", Version: "1.0.0"},
+	)
+	if err := repo.SaveOwnership(context.Background(), &plugin.Ownership{Name: "lint", OwnerID: "acc1"}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	plugin.Repo = repo
+	ctx := context.Background()
+
+	deprecated := func(versions ...string) {
+		t.Helper()
+		for _, v := range []string{"1.0.0", "1.5.0", "2.0.0"} {
+			m, err := plugin.Get(ctx, "lint", v)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			want := false
+			for _, dv := range versions {
+				want = want || dv == v
+			}
+
+			if (m.Deprecation != nil) != want {
+				t.Errorf("expected version %s deprecated to be %t, got %+v", v, want, m.Deprecation)
+			}
+		}
+	}
+
+	d := &plugin.Deprecation{Message: "use golint instead", Replacement: "golint"}
+
+	err := plugin.Deprecate(ctx, "lint", "", "acc2", d, false)
+	if _, ok := errors.Cause(err).(*plugin.PermissionDeniedError); !ok {
+		t.Fatalf("expected *PermissionDeniedError, got %T: %v", err, err)
+	}
+
+	invalid := []struct {
+		desc       string
+		constraint string
+		d          *plugin.Deprecation
+	}{
+		{"missing message", "", &plugin.Deprecation{Replacement: "golint"}},
+		{"unknown replacement", "", &plugin.Deprecation{Message: "gone", Replacement: "vet"}},
+		{"replaced by itself", "", &plugin.Deprecation{Message: "gone", Replacement: "lint"}},
+		{"invalid constraint", "latest", d},
+		{"no matching version", "> 3", d},
+	}
+
+	for _, tt := range invalid {
+		err := plugin.Deprecate(ctx, "lint", tt.constraint, "acc1", tt.d, false)
+		if _, ok := errors.Cause(err).(*plugin.ValidationError); !ok {
+			t.Errorf("%s: expected *ValidationError, got %T: %v", tt.desc, err, err)
+		}
+	}
+	deprecated()
+
+	if err := plugin.Deprecate(ctx, "lint", "< 2", "acc1", d, false); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	deprecated("1.0.0", "1.5.0")
+
+	m, err := plugin.Get(ctx, "lint", "1.5.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if *m.Deprecation != *d {
+		t.Errorf("expected deprecation %+v, got %+v", d, m.Deprecation)
+	}
+
+	if err := plugin.Deprecate(ctx, "lint", "", "acc2", d, true); err != nil {
+		t.Fatalf("expected admin to deprecate the whole plugin: %+v", err)
+	}
+	deprecated("1.0.0", "1.5.0", "2.0.0")
+
+	if err := plugin.Undeprecate(ctx, "lint", "1.0.0", "acc1", false); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	deprecated("1.5.0", "2.0.0")
+	// Versions published after the whole plugin was deprecated are deprecated too.
+	next := &plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "2.1.0", Deprecation: &plugin.Deprecation{Message: "ignored"}}
+	if err := plugin.SaveManifest(ctx, next, mustOwnership(t, "lint")); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if m, err := plugin.Get(ctx, "lint", "2.1.0"); err != nil || m.Deprecation == nil || *m.Deprecation != *d {
+		t.Errorf("expected new version to inherit deprecation %+v, got %+v (%v)", d, m, err)
+	}
+
+	if err := plugin.Undeprecate(ctx, "lint", "", "acc1", false); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	deprecated()
+
+	if m, err := plugin.Get(ctx, "lint", "2.1.0"); err != nil || m.Deprecation != nil {
+		t.Errorf("expected new version to be undeprecated, got %+v (%v)", m, err)
+	}
+
+	if o := mustOwnership(t, "lint"); o.Deprecation != nil {
+		t.Errorf("expected plugin to be undeprecated, got %+v", o.Deprecation)
+	}
+}
+
+func mustOwnership(t *testing.T, name string) *plugin.Ownership {
+	t.Helper()
+	o, err := plugin.GetOwnership(context.Background(), name)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	return o
+}
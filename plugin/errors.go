@@ -0,0 +1,134 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VersionExistsError is returned when publishing a plugin version that was already
+// published. Published versions are immutable.
+type VersionExistsError struct {
+	Name    string
+	Version string
+}
+
+func (e *VersionExistsError) Error() string {
+	return fmt.Sprintf("version %s of plugin %q already exists", e.Version, e.Name)
+}
+
+// PublishInProgressError is returned when publishing a plugin version that is being published
+// through a publish session at the same time. Publishing can be retried once that is over.
+type PublishInProgressError struct {
+	Name    string
+	Version string
+}
+
+func (e *PublishInProgressError) Error() string {
+	return fmt.Sprintf("version %s of plugin %q is already being published", e.Version, e.Name)
+}
+
+// PackageExistsError is returned when publishing a package of a plugin version whose storage
+// location already holds a different file, such as a package uploaded for the version outside
+// of a publish session. Stored files are never replaced, so the version cannot be published
+// with that package.
+type PackageExistsError struct {
+	Name    string
+	Version string
+	Package string
+}
+
+func (e *PackageExistsError) Error() string {
+	return fmt.Sprintf("a different package %q is already stored for version %s of plugin %q", e.Package, e.Version, e.Name)
+}
+
+// NameTakenError is returned by repositories when saving a version of a new plugin whose name
+// only differs in case from the name of a stored plugin.
+type NameTakenError struct {
+	Name  string
+	Taken string
+}
+
+func (e *NameTakenError) Error() string {
+	return fmt.Sprintf("plugin name %q is already taken by %q", e.Name, e.Taken)
+}
+
+// NotFoundError is returned when the requested plugin, or plugin version, does not exist.
+type NotFoundError struct {
+	Name    string
+	Version string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Version == "" {
+		return fmt.Sprintf("plugin %q not found", e.Name)
+	}
+	return fmt.Sprintf("version %s of plugin %q not found", e.Version, e.Name)
+}
+
+// SessionNotFoundError is returned when a publish session does not exist, or has expired.
+type SessionNotFoundError struct {
+	ID string
+}
+
+func (e *SessionNotFoundError) Error() string {
+	return fmt.Sprintf("publish session %q not found", e.ID)
+}
+
+// NoMatchError is returned when no published version of a plugin satisfies a version
+// constraint while also providing a package for the requested platform.
+type NoMatchError struct {
+	Name       string
+	Constraint string
+	OS         OS
+	Arch       Arch
+}
+
+func (e *NoMatchError) Error() string {
+	return fmt.Sprintf("no version of plugin %q matches %q for %s/%s", e.Name, e.Constraint, e.OS, e.Arch)
+}
+
+// PermissionDeniedError is returned when an account is not allowed to perform an action on a plugin.
+type PermissionDeniedError struct {
+	AccountID string
+	Action    string
+	Name      string
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("account %q is not allowed to %s plugin %q", e.AccountID, e.Action, e.Name)
+}
+
+// FieldError describes why the value of a field is invalid.
+type FieldError struct {
+	// Field is the path to the invalid field, i.e. packages[0].checksum
+	Field string
+	// Description explains why the value is invalid.
+	Description string
+}
+
+// ValidationError is returned when a request or manifest is invalid. It lists every
+// violation found, so that clients can report all of them at once.
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+// Add records a new field violation.
+func (e *ValidationError) Add(field, description string) {
+	e.Fields = append(e.Fields, &FieldError{Field: field, Description: description})
+}
+
+// ErrorOrNil returns the validation error if any violation was recorded, or nil otherwise.
+func (e *ValidationError) ErrorOrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	violations := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		violations = append(violations, f.Field+": "+f.Description)
+	}
+	return "invalid request: " + strings.Join(violations, "; ")
+}
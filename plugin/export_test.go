@@ -0,0 +1,29 @@
+package plugin
+
+// Unexported identifiers used by tests in the plugin_test package. Tests relying on a
+// repository live there, since plugintest, which provides the in-memory repository, imports
+// this package.
+var (
+	CheckNamePolicy    = checkNamePolicy
+	ClaimName          = claimName
+	DeprecationHeaders = deprecationHeaders
+	SaveManifest       = saveManifest
+	SortOrders         = sortOrders
+	SortVersions       = sortVersions
+	ValidateName       = validateName
+)
+
+const (
+	DefaultResultsPerPage = defaultResultsPerPage
+	MaxResultsPerPage     = maxResultsPerPage
+	DeprecatedHeader      = deprecatedHeader
+	ReplacementHeader     = replacementHeader
+)
+
+// HTTPError is the body of HTTP error responses.
+type HTTPError = httpError
+
+// StagedFile returns the index of the file staged under name, or -1 if there is none.
+func (s *Session) StagedFile(name string) int {
+	return s.stagedFile(name)
+}
@@ -2,20 +2,72 @@ package plugin
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/golang/glog"
 	version "github.com/hashicorp/go-version"
 	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/files"
 )
 
 // Repo should be initialized by a concrete repository implementation.
 var Repo Repository
 
+// Storage should be initialized with the storage provider holding plugin packages.
+var Storage files.StorageProvider
+
 // Repository is the interface to implement in order to retrieve data from a specific repository.
 type Repository interface {
-	Search(ctx context.Context, query string, pageNumber, resultsPerPage int) ([]*Manifest, error)
+	// Search finds plugin versions matching a query. Yanked versions must not be returned.
+	Search(ctx context.Context, q *SearchQuery) (*SearchResult, error)
+	// Suggest returns up to limit distinct plugin names starting with, or close to, the
+	// given text. Plugins whose versions were all yanked must not be suggested. Like most
+	// fuzzy suggesters, corrections are only looked for among names starting with the same
+	// character as the text, which is rarely mistyped.
+	Suggest(ctx context.Context, text string, limit int) (*Suggestions, error)
+	// Save stores a new plugin version. It must return a *VersionExistsError if the
+	// version was already stored, as published versions are immutable, and a
+	// *NameTakenError if a plugin whose name only differs in case is stored. Both
+	// checks must be atomic with storing the version.
 	Save(ctx context.Context, p *Manifest) error
-	Delete(ctx context.Context, id, accountID string) error
+	// Update replaces the metadata of existing plugin versions, such as their yank state.
+	// Either all the versions are updated or none is, and it must return a *NotFoundError
+	// if any of them does not exist. Download counts are left as stored, since they only
+	// change through AddDownloads.
+	Update(ctx context.Context, manifests ...*Manifest) error
+	// AddDownloads adds to the download counts of plugin versions, keyed by document ID.
+	// Versions no longer stored are skipped.
+	AddDownloads(ctx context.Context, counts map[string]int64) error
+	// Delete removes a plugin version. It must return a *NotFoundError if the version
+	// does not exist.
+	Delete(ctx context.Context, id string) error
+	// Versions returns all the stored versions of a plugin, in no particular order.
+	Versions(ctx context.Context, name string) ([]*Manifest, error)
+	// Get returns a specific plugin version. It must return a *NotFoundError if the
+	// version does not exist.
+	Get(ctx context.Context, name, version string) (*Manifest, error)
+	// Names returns the names of all stored plugins.
+	Names(ctx context.Context) ([]string, error)
+	// Ownership returns the ownership record of a plugin. It must return a *NotFoundError
+	// if the plugin has no ownership record.
+	Ownership(ctx context.Context, name string) (*Ownership, error)
+	// SaveOwnership creates or replaces the ownership record of a plugin.
+	SaveOwnership(ctx context.Context, o *Ownership) error
+	// Session returns a publish session. It must return a *SessionNotFoundError if the
+	// session does not exist.
+	Session(ctx context.Context, id string) (*Session, error)
+	// Sessions returns all publish sessions, including expired ones, in no particular order.
+	Sessions(ctx context.Context) ([]*Session, error)
+	// SaveSession creates or replaces a publish session.
+	SaveSession(ctx context.Context, s *Session) error
+	// DeleteSession removes a publish session. It must return a *SessionNotFoundError if
+	// the session does not exist.
+	DeleteSession(ctx context.Context, id string) error
 }
 
 // Arch is the CPU architecture for which a plugin package was compiled.
@@ -62,8 +114,9 @@ type Package struct {
 }
 
 // Manifest is the document we use to index and return plugin manifest info.
+// There is one manifest per published plugin version.
 type Manifest struct {
-	// Internal document ID
+	// Internal document ID, in the form of <name>@<version>
 	ID string `json:"_id"`
 	// Account publishing the plugin.
 	AccountID string `json:"_account_id"`
@@ -85,29 +138,209 @@ type Manifest struct {
 	Packages []*Package `json:"packages"`
 	// PublishedAt is the time when this plugin was published.
 	PublishedAt time.Time `json:"published_at"`
+	// UpdatedAt is the last time this plugin version was published or its metadata changed,
+	// i.e. it was yanked or deprecated.
+	UpdatedAt time.Time `json:"updated_at"`
+	// Downloads is the number of times packages of this plugin version were downloaded.
+	Downloads int64 `json:"downloads"`
+	// Yanked versions are hidden from search results and version resolution, but can still
+	// be downloaded by their exact version number, so that existing lockfiles keep working.
+	Yanked bool `json:"yanked"`
+	// YankReason explains why the version was yanked.
+	YankReason string `json:"yank_reason"`
+	// Deprecation is set when publishers no longer recommend installing this version.
+	Deprecation *Deprecation `json:"deprecation,omitempty"`
 }
 
-// Search runs the specified query on the index file and returns a list of plugins.
-func Search(ctx context.Context, query string, pageNumber, resultsPerPage int) ([]*Manifest, error) {
-	if resultsPerPage == 0 {
-		resultsPerPage = 10
+// Versions returns all published versions of a plugin, sorted from oldest to newest.
+func Versions(ctx context.Context, name string) ([]*Manifest, error) {
+	if name == "" {
+		return nil, errors.New("plugin name is required")
+	}
+
+	manifests, err := Repo.Versions(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(manifests) == 0 {
+		return nil, &NotFoundError{Name: name}
 	}
 
-	if resultsPerPage > 50 {
-		resultsPerPage = 50
+	sortVersions(manifests)
+	return manifests, nil
+}
+
+// Published returns whether a version of a plugin has been published, even if yanked since.
+func Published(ctx context.Context, name, pluginVersion string) (bool, error) {
+	ver, err := version.NewVersion(pluginVersion)
+	if err != nil {
+		return false, nil
 	}
 
-	return Repo.Search(ctx, query, pageNumber, resultsPerPage)
+	_, err = Repo.Get(ctx, name, ver.String())
+	if _, ok := errors.Cause(err).(*NotFoundError); ok {
+		return false, nil
+	}
+	return err == nil, err
 }
 
-// Publish adds the plugin document into the index.
+// Get returns a plugin manifest by its exact name. If version is empty, the latest stable
+// version is returned, or the latest prerelease if the plugin has no stable versions yet.
+// Yanked versions are only returned when asked for by their exact version number.
+func Get(ctx context.Context, name, pluginVersion string) (*Manifest, error) {
+	if name == "" {
+		return nil, errors.New("plugin name is required")
+	}
+
+	if pluginVersion != "" {
+		ver, err := version.NewVersion(pluginVersion)
+		if err != nil {
+			verr := new(ValidationError)
+			verr.Add("version", fmt.Sprintf("%q is not a valid version number", pluginVersion))
+			return nil, verr
+		}
+		return Repo.Get(ctx, name, ver.String())
+	}
+
+	manifests, err := Versions(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *Manifest
+	for i := len(manifests) - 1; i >= 0; i-- {
+		if manifests[i].Yanked {
+			continue
+		}
+
+		ver, err := version.NewVersion(manifests[i].Version)
+		if err == nil && ver.Prerelease() == "" {
+			return manifests[i], nil
+		}
+
+		if latest == nil {
+			latest = manifests[i]
+		}
+	}
+
+	if latest == nil {
+		return nil, &NotFoundError{Name: name}
+	}
+
+	return latest, nil
+}
+
+// Resolve finds the highest published version of a plugin satisfying the given version
+// constraint, i.e. "~> 1.2" or ">= 2.0, < 3", that also provides a package for the given
+// platform. It returns the matching manifest along with the package to install.
+// An empty constraint matches any stable version. Yanked versions are never resolved.
+func Resolve(ctx context.Context, name, constraint string, os OS, arch Arch) (*Manifest, *Package, error) {
+	var constraints version.Constraints
+	if constraint != "" {
+		c, err := version.NewConstraint(constraint)
+		if err != nil {
+			verr := new(ValidationError)
+			verr.Add("constraint", fmt.Sprintf("%q is not a valid version constraint", constraint))
+			return nil, nil, verr
+		}
+		constraints = c
+	}
+
+	manifests, err := Versions(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := len(manifests) - 1; i >= 0; i-- {
+		m := manifests[i]
+		if m.Yanked {
+			continue
+		}
+
+		ver, err := version.NewVersion(m.Version)
+		if err != nil {
+			continue
+		}
+
+		if constraints == nil && ver.Prerelease() != "" {
+			continue
+		}
+
+		if constraints != nil && !constraints.Check(ver) {
+			continue
+		}
+
+		for _, p := range m.Packages {
+			if p.OS == os && p.Arch == arch {
+				return m, p, nil
+			}
+		}
+	}
+
+	return nil, nil, &NoMatchError{Name: name, Constraint: constraint, OS: os, Arch: arch}
+}
+
+// sortVersions sorts manifests by version number, from oldest to newest.
+// Manifests with invalid version numbers are sorted first.
+func sortVersions(manifests []*Manifest) {
+	versions := make(map[*Manifest]*version.Version, len(manifests))
+	for _, m := range manifests {
+		ver, err := version.NewVersion(m.Version)
+		if err != nil {
+			glog.Errorf("invalid version %q stored for plugin %q: %+v", m.Version, m.Name, err)
+			continue
+		}
+		versions[m] = ver
+	}
+
+	sort.SliceStable(manifests, func(i, j int) bool {
+		vi, vj := versions[manifests[i]], versions[manifests[j]]
+		if vi == nil || vj == nil {
+			return vi == nil && vj != nil
+		}
+		return vi.LessThan(vj)
+	})
+}
+
+// Publish adds the plugin document into the index. Packages must have been uploaded to the
+// namespace of the publishing account beforehand.
+//
+// Deprecated: publish through a session instead, see OpenSession and FinalizeSession.
 func Publish(ctx context.Context, p *Manifest) error {
+	if err := prepareManifest(ctx, p); err != nil {
+		return err
+	}
+
+	if err := verifyPackages(p, statPublished(ctx, p)); err != nil {
+		return err
+	}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	if finalizing[manifestID(p.Name, p.Version)] {
+		return &PublishInProgressError{Name: p.Name, Version: p.Version}
+	}
+
+	// Only the account owning the plugin name, or its maintainers, can publish new versions.
+	return claimName(ctx, p.Name, p.AccountID, func(o *Ownership) error {
+		return saveManifest(ctx, p, o)
+	})
+}
+
+// prepareManifest validates a manifest about to be published and normalizes its version.
+func prepareManifest(ctx context.Context, p *Manifest) error {
 	if p == nil {
 		return errors.New("a valid manifest is required")
 	}
 
-	if len(p.Packages) == 0 {
-		return errors.New("list of packages missing")
+	if err := Validate(p); err != nil {
+		return err
+	}
+
+	if err := checkNamePolicy(ctx, p); err != nil {
+		return err
 	}
 
 	ver, err := version.NewVersion(p.Version)
@@ -116,21 +349,153 @@ func Publish(ctx context.Context, p *Manifest) error {
 	}
 
 	p.Version = ver.String()
-	p.ID = p.Name
+
+	// Packages are always downloaded from the namespace of the publishing account,
+	// regardless of what the client claims.
+	prefix, err := files.Prefix(p.AccountID, p.Name, p.Version)
+	if err != nil {
+		return errors.Wrap(err, "invalid manifest")
+	}
+	p.FilesURI = files.URL(prefix)
+
+	return nil
+}
+
+// saveManifest stores a new plugin version, which makes it visible to clients. The version
+// inherits the deprecation of the plugin, if its ownership record o has one.
+func saveManifest(ctx context.Context, p *Manifest, o *Ownership) error {
+	p.ID = manifestID(p.Name, p.Version)
 	p.PublishedAt = time.Now()
+	p.UpdatedAt = p.PublishedAt
+	p.Downloads = 0
+
+	p.Deprecation = nil
+	if o != nil {
+		p.Deprecation = copyDeprecation(o.Deprecation)
+	}
+
+	err := Repo.Save(ctx, p)
+	if e, ok := errors.Cause(err).(*NameTakenError); ok {
+		// Lost a race against a plugin published with the same name in another case.
+		verr := new(ValidationError)
+		verr.Add("name", e.Error())
+		return verr
+	}
+	return err
+}
+
+var (
+	downloadsMu sync.Mutex
+	// downloads holds the downloads counted since they were last flushed, keyed by document ID.
+	downloads = make(map[string]int64)
+)
+
+// RecordDownload counts a download of a plugin version. Downloads are only counted in memory,
+// until FlushDownloads adds them to the repository.
+func RecordDownload(m *Manifest) {
+	downloadsMu.Lock()
+	defer downloadsMu.Unlock()
 
-	return Repo.Save(ctx, p)
+	downloads[manifestID(m.Name, m.Version)]++
 }
 
-// Unpublish removes a plugin from the index.
-func Unpublish(ctx context.Context, id, accountID string) error {
+// FlushDownloads adds the downloads counted so far to the repository. If the repository fails,
+// downloads are kept to be flushed again later on.
+func FlushDownloads(ctx context.Context) error {
+	downloadsMu.Lock()
+	counts := downloads
+	downloads = make(map[string]int64)
+	downloadsMu.Unlock()
+
+	if len(counts) == 0 {
+		return nil
+	}
+
+	if err := Repo.AddDownloads(ctx, counts); err != nil {
+		downloadsMu.Lock()
+		for id, n := range counts {
+			downloads[id] += n
+		}
+		downloadsMu.Unlock()
+		return errors.Wrap(err, "failed adding downloads")
+	}
+
+	return nil
+}
+
+// manifestID returns the document ID for a given plugin version.
+func manifestID(name, version string) string {
+	return name + "@" + version
+}
+
+// statPublished returns the package files of a plugin version, as stored in the namespace of
+// the publishing account.
+func statPublished(ctx context.Context, p *Manifest) func(pkg *Package) (*files.Object, error) {
+	return func(pkg *Package) (*files.Object, error) {
+		key, err := files.Key(p.AccountID, p.Name, p.Version, pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		return Storage.Stat(ctx, key)
+	}
+}
+
+// verifyPackages makes sure every package listed in the manifest was uploaded, as returned by
+// stat, and that its content matches the checksum declared for it. Packages failing to verify
+// are reported as a *ValidationError.
+func verifyPackages(p *Manifest, stat func(pkg *Package) (*files.Object, error)) error {
+	verr := new(ValidationError)
+	for i, pkg := range p.Packages {
+		field := fmt.Sprintf("packages[%d]", i)
+		obj, err := stat(pkg)
+		if errors.Cause(err) == files.ErrNotFound {
+			verr.Add(field+".name", fmt.Sprintf("package %q has not been uploaded", pkg.Name))
+			continue
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "failed verifying package %q", pkg.Name)
+		}
+
+		digest, ok := obj.Digests[string(pkg.Algorithm)]
+		if !ok {
+			verr.Add(field+".algorithm", fmt.Sprintf("unsupported checksum algorithm %q for package %q", pkg.Algorithm, pkg.Name))
+			continue
+		}
+
+		if !strings.EqualFold(digest, pkg.Checksum) {
+			verr.Add(field+".checksum", fmt.Sprintf("checksum mismatch for package %q: manifest declares %s %q but uploaded file has %q",
+				pkg.Name, pkg.Algorithm, pkg.Checksum, digest))
+		}
+	}
+
+	return verr.ErrorOrNil()
+}
+
+// Unpublish yanks a plugin version, given its document ID. Versions are never removed, so that
+// clients that pinned them keep working and their name and version cannot be published again
+// with different packages. Only the plugin owner and its maintainers are allowed to unpublish
+// versions, unless admin is set, which overrides ownership checks.
+func Unpublish(ctx context.Context, id, accountID string, admin bool) error {
 	if id == "" {
 		return errors.New("document ID is required")
 	}
 
-	if accountID == "" {
-		return errors.New("account ID is required")
+	i := strings.LastIndex(id, "@")
+	if i <= 0 {
+		verr := new(ValidationError)
+		verr.Add("id", fmt.Sprintf("%q is not a valid plugin ID, it must be in the form of <name>@<version>", id))
+		return verr
+	}
+
+	err := updateManifest(ctx, id[:i], id[i+1:], accountID, "unpublish", admin, func(m *Manifest) {
+		m.Yanked = true
+		m.YankReason = "unpublished"
+	})
+	if err != nil {
+		return err
 	}
 
-	return Repo.Delete(ctx, id, accountID)
+	glog.Infof("account %q unpublished %q", accountID, id)
+	return nil
 }
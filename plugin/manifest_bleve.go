@@ -1,16 +1,18 @@
-// +build bleve
-
 package plugin
 
 import (
-	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
-	"fmt"
-	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 )
@@ -18,93 +20,419 @@ import (
 // RepoBleve represents an implementation of the Repo interface for Bleve search engine.
 type RepoBleve struct {
 	index bleve.Index
+	// mu serializes writes, so that checking whether a document exists and
+	// indexing it happens atomically.
+	mu sync.Mutex
+}
+
+// bleveDocument is the document indexed for a plugin version. Besides the manifest, it holds
+// fields only used for searching. The manifest is embedded by value, so that Bleve indexes
+// its fields at the top level of the document.
+type bleveDocument struct {
+	Manifest
+	// Platforms lists the os/arch pair of every package, so that both can be required to
+	// match the same package.
+	Platforms []string `json:"platforms"`
 }
 
-// NewRepository creates an instance of the Bleve repository.
-func NewRepository(index bleve.Index) Repository {
+// newBleveDocument returns the document to index for a plugin version.
+func newBleveDocument(m *Manifest) *bleveDocument {
+	doc := &bleveDocument{Manifest: *m, Platforms: make([]string, 0, len(m.Packages))}
+	for _, p := range m.Packages {
+		doc.Platforms = append(doc.Platforms, platform(p.OS, p.Arch))
+	}
+	return doc
+}
+
+// platform returns the value indexed in the platforms field for a package.
+func platform(pkgOS OS, pkgArch Arch) string {
+	return string(pkgOS) + "/" + string(pkgArch)
+}
+
+// NewBleveRepository creates an instance of the Bleve repository.
+func NewBleveRepository(index bleve.Index) Repository {
 	return &RepoBleve{
 		index: index,
 	}
 }
 
+// facetFields maps search facets to the document fields they summarize.
+var facetFields = map[string]string{
+	osFacet:      "packages.os",
+	archFacet:    "packages.arch",
+	licenseFacet: "license",
+	authorFacet:  "author.name",
+}
+
+// sortFields maps sort orders to the fields Bleve sorts by. Document IDs always break ties,
+// so that results have a stable order to paginate over.
+var sortFields = map[SortOrder][]string{
+	SortRelevance: {"-_score", "_id"},
+	SortNewest:    {"-published_at", "_id"},
+	SortName:      {"name", "_id"},
+	SortDownloads: {"-downloads", "-_score", "_id"},
+	SortUpdated:   {"-updated_at", "_id"},
+}
+
+// maxFacetTerms is the maximum number of distinct values returned per facet.
+const maxFacetTerms = 20
+
 // Search finds plugin manifests in Bleve.
-func (r *RepoBleve) Search(ctx context.Context, query string, pageNumber, resultsPerPage int) ([]*Manifest, error) {
-	matchQuery := bleve.NewQueryStringQuery(query)
-	matchQuery.SetBoost(1)
-	search := bleve.NewSearchRequest(matchQuery)
-	search.Size = resultsPerPage
-	search.From = pageNumber
-	search.SortBy([]string{"-_score", "_id"})
+func (r *RepoBleve) Search(ctx context.Context, q *SearchQuery) (*SearchResult, error) {
+	search := bleve.NewSearchRequest(searchQuery(q))
+	search.Size = q.ResultsPerPage
+	search.SortBy(sortFields[q.Sort])
 	search.Fields = []string{"*"}
 
+	if q.PageToken != "" {
+		after, err := decodePageToken(q.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		search.SearchAfter = after
+	} else {
+		search.From = (q.PageNumber - 1) * q.ResultsPerPage
+	}
+
+	for name, field := range facetFields {
+		search.AddFacet(name, bleve.NewFacetRequest(field, maxFacetTerms))
+	}
+
 	if err := search.Validate(); err != nil {
 		return nil, errors.Wrap(err, "invalid search query")
 	}
 
 	results, err := r.index.Search(search)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed searching %q", query)
+		return nil, errors.Wrapf(err, "failed searching %q", q.Text)
 	}
 
-	manifests := make([]*Manifest, 0)
+	res := new(SearchResult)
+	res.Total = int(results.Total)
+	res.Took = results.Took
+	res.Manifests = make([]*Manifest, 0, len(results.Hits))
 	for _, h := range results.Hits {
-		fields := h.Fields
-
-		manifest := &Manifest{
-			ID:          fields["_id"].(string),
-			AccountID:   fields["_account_id"].(string),
-			Name:        fields["name"].(string),
-			FilesURI:    fields["files_uri"].(string),
-			Version:     fields["version"].(string),
-			Description: fields["description"].(string),
-			Author: Author{
-				Name:  fields["author.name"].(string),
-				Email: fields["author.email"].(string),
-			},
-			License:  fields["license"].(string),
-			Homepage: fields["homepage"].(string),
-		}
-
-		publishedTime, err := time.Parse(time.RFC3339, fields["published_at"].(string))
+		m, err := manifestFromFields(h.ID, h.Fields)
 		if err != nil {
-			glog.Errorf("failed parsing published_at field coming from Bleve: %+v", err)
-		} else {
-			manifest.PublishedAt = publishedTime
+			glog.Errorf("skipping search result: %+v", err)
+			continue
 		}
+		res.Manifests = append(res.Manifests, m)
+	}
 
-		packages := make([]*Package, 0)
-		for i, name := range fields["packages.name"].([]interface{}) {
-			p := &Package{Name: name.(string)}
+	// A full page means there may be more results. When paginating with tokens the offset
+	// of the page is unknown, so the total cannot tell whether this is the last page.
+	more := len(results.Hits) == q.ResultsPerPage
+	if q.PageToken == "" {
+		more = search.From+len(results.Hits) < res.Total
+	}
 
-			if v, ok := fields["packages.arch"]; ok {
-				p.Arch = Arch(v.([]interface{})[i].(string))
-			}
+	if more && len(results.Hits) > 0 {
+		token, err := encodePageToken(pageSortValues(results.Hits[len(results.Hits)-1], sortFields[q.Sort]))
+		if err != nil {
+			return nil, err
+		}
+		res.NextPageToken = token
+	}
 
-			if v, ok := fields["packages.os"]; ok {
-				p.OS = OS(v.([]interface{})[i].(string))
-			}
+	for name := range facetFields {
+		f, ok := results.Facets[name]
+		if !ok {
+			continue
+		}
+
+		facet := &Facet{Name: name, Terms: make([]FacetTerm, 0, len(f.Terms))}
+		for _, t := range f.Terms {
+			facet.Terms = append(facet.Terms, FacetTerm{Term: t.Term, Count: t.Count})
+		}
+		res.Facets = append(res.Facets, facet)
+	}
+
+	sort.Slice(res.Facets, func(i, j int) bool {
+		return res.Facets[i].Name < res.Facets[j].Name
+	})
+
+	return res, nil
+}
 
-			if v, ok := fields["packages.checksum"]; ok {
-				p.Checksum = v.([]interface{})[i].(string)
+// Suggest finds plugin names starting with, or within a couple of typos of, the given text.
+func (r *RepoBleve) Suggest(ctx context.Context, text string, limit int) (*Suggestions, error) {
+	// Names are indexed in lower case, and neither prefix nor fuzzy queries are analyzed.
+	text = strings.ToLower(text)
+
+	prefix := bleve.NewPrefixQuery(text)
+	prefix.SetField("name")
+
+	completions, err := r.suggest(prefix, limit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed completing %q", text)
+	}
+	sort.Strings(completions)
+
+	fuzzy := bleve.NewFuzzyQuery(text)
+	fuzzy.SetField("name")
+	fuzzy.SetFuzziness(2)
+	fuzzy.SetPrefix(1)
+
+	// As many names as facets return are requested, so that the closest ones can be picked.
+	corrections, err := r.suggest(fuzzy, maxFacetTerms)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed correcting %q", text)
+	}
+
+	// Names already completed, or typed exactly, are not corrections.
+	distances := make(map[string]int)
+	res := &Suggestions{Completions: completions, Corrections: make([]string, 0, len(corrections))}
+	for _, name := range corrections {
+		lower := strings.ToLower(name)
+		if lower != text && !strings.HasPrefix(lower, text) {
+			distances[name] = editDistance(text, lower)
+			res.Corrections = append(res.Corrections, name)
+		}
+	}
+
+	sort.Slice(res.Corrections, func(i, j int) bool {
+		a, b := res.Corrections[i], res.Corrections[j]
+		if distances[a] != distances[b] {
+			return distances[a] < distances[b]
+		}
+		return a < b
+	})
+
+	if len(res.Corrections) > limit {
+		res.Corrections = res.Corrections[:limit]
+	}
+
+	return res, nil
+}
+
+// suggest returns up to limit distinct plugin names matching a name query, those with the
+// most matching versions first. Names are collected with a terms facet, rather than from
+// search hits, since every plugin version is a separate document.
+func (r *RepoBleve) suggest(nameQuery query.Query, limit int) ([]string, error) {
+	yanked := bleve.NewBoolFieldQuery(true)
+	yanked.SetField("yanked")
+
+	q := bleve.NewBooleanQuery()
+	q.AddMust(nameQuery)
+	q.AddMustNot(yanked)
+
+	search := bleve.NewSearchRequestOptions(q, 0, 0, false)
+	search.AddFacet("names", bleve.NewFacetRequest("name_exact", limit))
+
+	results, err := r.index.Search(search)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, limit)
+	if f, ok := results.Facets["names"]; ok {
+		for _, t := range f.Terms {
+			names = append(names, t.Term)
+		}
+	}
+
+	return names, nil
+}
+
+// pageSortValues returns the sort values a page token resumes after. Bleve reports scores
+// as a placeholder among the sort values of a hit, but needs the score itself to resume a
+// search sorted by relevance.
+func pageSortValues(hit *search.DocumentMatch, fields []string) []string {
+	values := append([]string(nil), hit.Sort...)
+	for i, field := range fields {
+		if i < len(values) && strings.TrimPrefix(field, "-") == "_score" {
+			values[i] = strconv.FormatFloat(hit.Score, 'g', -1, 64)
+		}
+	}
+	return values
+}
+
+// encodePageToken encodes the sort values of the last hit of a page into an opaque token.
+func encodePageToken(sortValues []string) (string, error) {
+	data, err := json.Marshal(sortValues)
+	if err != nil {
+		return "", errors.Wrap(err, "failed encoding page token")
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodePageToken decodes the sort values a page token was created from.
+func decodePageToken(token string) ([]string, error) {
+	var sortValues []string
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &sortValues)
+	}
+
+	if err != nil || len(sortValues) == 0 {
+		verr := new(ValidationError)
+		verr.Add("page_token", "invalid page token")
+		return nil, verr
+	}
+
+	return sortValues, nil
+}
+
+// searchQuery builds the Bleve query for a search. User input is only ever matched
+// against field values, never parsed as Bleve's query string syntax.
+func searchQuery(q *SearchQuery) query.Query {
+	conjuncts := make([]query.Query, 0)
+
+	if q.Text == "" {
+		conjuncts = append(conjuncts, bleve.NewMatchAllQuery())
+	} else {
+		// Exact name matches rank first.
+		name := bleve.NewMatchQuery(q.Text)
+		name.SetField("name")
+		name.SetBoost(5)
+
+		nameText := bleve.NewMatchQuery(q.Text)
+		nameText.SetField("name_text")
+		nameText.SetBoost(2)
+
+		description := bleve.NewMatchQuery(q.Text)
+		description.SetField("description")
+
+		author := bleve.NewMatchQuery(q.Text)
+		author.SetField("author.name_text")
+
+		conjuncts = append(conjuncts, bleve.NewDisjunctionQuery(name, nameText, description, author))
+	}
+
+	// Package fields are flattened into lists, so both have to be matched as a pair to
+	// come from the same package.
+	switch {
+	case q.OS != "" && q.Arch != "":
+		conjuncts = append(conjuncts, fieldQuery("platforms", platform(q.OS, q.Arch)))
+	case q.OS != "":
+		conjuncts = append(conjuncts, fieldQuery("packages.os", string(q.OS)))
+	case q.Arch != "":
+		conjuncts = append(conjuncts, fieldQuery("packages.arch", string(q.Arch)))
+	}
+
+	if q.License != "" {
+		conjuncts = append(conjuncts, fieldQuery("license", q.License))
+	}
+
+	if q.Author != "" {
+		conjuncts = append(conjuncts, bleve.NewDisjunctionQuery(
+			fieldQuery("author.name", q.Author),
+			fieldQuery("author.email", q.Author),
+		))
+	}
+
+	if !q.PublishedAfter.IsZero() {
+		inclusive := false
+		publishedAt := bleve.NewDateRangeInclusiveQuery(q.PublishedAfter, time.Time{}, &inclusive, nil)
+		publishedAt.SetField("published_at")
+		conjuncts = append(conjuncts, publishedAt)
+	}
+
+	yanked := bleve.NewBoolFieldQuery(true)
+	yanked.SetField("yanked")
+
+	searchQuery := bleve.NewBooleanQuery()
+	searchQuery.AddMust(conjuncts...)
+	searchQuery.AddMustNot(yanked)
+
+	return searchQuery
+}
+
+// fieldQuery matches documents whose field contains the given phrase.
+func fieldQuery(field, value string) query.Query {
+	q := bleve.NewMatchPhraseQuery(value)
+	q.SetField(field)
+	return q
+}
+
+// batchSize is the number of documents fetched at once when iterating over search results.
+const batchSize = 100
+
+// Versions finds all the versions of a plugin stored in Bleve.
+func (r *RepoBleve) Versions(ctx context.Context, name string) ([]*Manifest, error) {
+	query := bleve.NewMatchPhraseQuery(name)
+	query.SetField("name")
+
+	manifests := make([]*Manifest, 0)
+	for from := 0; ; from += batchSize {
+		search := bleve.NewSearchRequestOptions(query, batchSize, from, false)
+		search.SortBy([]string{"_id"})
+		search.Fields = []string{"*"}
+
+		results, err := r.index.Search(search)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed searching versions of %q", name)
+		}
+
+		for _, h := range results.Hits {
+			m, err := manifestFromFields(h.ID, h.Fields)
+			if err != nil {
+				glog.Errorf("skipping version of %q: %+v", name, err)
+				continue
 			}
 
-			if v, ok := fields["packages.algorithm"]; ok {
-				p.Algorithm = Algorithm(v.([]interface{})[i].(string))
+			// Names are indexed ignoring case, so the query may match names differing in case too.
+			if m.Name == name {
+				manifests = append(manifests, m)
 			}
+		}
 
-			packages = append(packages, p)
+		if len(results.Hits) < batchSize {
+			break
 		}
+	}
 
-		manifest.Packages = packages
-		manifests = append(manifests, manifest)
+	return manifests, nil
+}
+
+// Get finds a specific plugin version in Bleve.
+func (r *RepoBleve) Get(ctx context.Context, name, version string) (*Manifest, error) {
+	id := manifestID(name, version)
+	search := bleve.NewSearchRequest(bleve.NewDocIDQuery([]string{id}))
+	search.Fields = []string{"*"}
+
+	results, err := r.index.Search(search)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed getting %q", id)
 	}
 
-	encoder := json.NewEncoder(os.Stdout)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(results); err != nil {
-		return nil, errors.Wrap(err, "failed encoding search result")
+	if len(results.Hits) == 0 {
+		return nil, &NotFoundError{Name: name, Version: version}
 	}
-	return manifests, nil
+
+	return manifestFromFields(results.Hits[0].ID, results.Hits[0].Fields)
+}
+
+// Names lists the names of all plugins stored in Bleve.
+func (r *RepoBleve) Names(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for from := 0; ; from += batchSize {
+		search := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), batchSize, from, false)
+		search.SortBy([]string{"_id"})
+		search.Fields = []string{"name"}
+
+		results, err := r.index.Search(search)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed listing plugin names")
+		}
+
+		for _, h := range results.Hits {
+			name, ok := h.Fields["name"].(string)
+			if ok && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+
+		if len(results.Hits) < batchSize {
+			break
+		}
+	}
+
+	return names, nil
 }
 
 // Save indexes plugin metadata in Bleve's index.
@@ -117,33 +445,335 @@ func (r *RepoBleve) Save(ctx context.Context, p *Manifest) error {
 		return errors.New("ID is required")
 	}
 
-	return r.index.Index(p.ID, p)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, err := r.index.Document(p.ID)
+	if err != nil {
+		return errors.Wrapf(err, "failed looking up document ID %q", p.ID)
+	}
+
+	if doc != nil {
+		return &VersionExistsError{Name: p.Name, Version: p.Version}
+	}
+
+	taken, err := r.nameTaken(p.Name)
+	if err != nil {
+		return err
+	}
+
+	if taken != "" {
+		return &NameTakenError{Name: p.Name, Taken: taken}
+	}
+
+	return r.index.Index(p.ID, newBleveDocument(p))
+}
+
+// nameTaken returns the name of a stored plugin that only differs in case from the given
+// name, if any. Since Save never stores such names, looking at a single match is enough.
+func (r *RepoBleve) nameTaken(name string) (string, error) {
+	query := bleve.NewMatchPhraseQuery(name)
+	query.SetField("name")
+
+	search := bleve.NewSearchRequestOptions(query, 1, 0, false)
+	search.Fields = []string{"name"}
+
+	results, err := r.index.Search(search)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed looking up plugin name %q", name)
+	}
+
+	for _, h := range results.Hits {
+		if stored, ok := h.Fields["name"].(string); ok && stored != name {
+			return stored, nil
+		}
+	}
+	return "", nil
+}
+
+// Update reindexes existing plugin versions in Bleve's index, in a single batch.
+func (r *RepoBleve) Update(ctx context.Context, manifests ...*Manifest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]string, 0, len(manifests))
+	for _, p := range manifests {
+		if p == nil {
+			return errors.New("manifest is required")
+		}
+
+		if p.ID == "" {
+			return errors.New("ID is required")
+		}
+		ids = append(ids, p.ID)
+	}
+
+	stored, err := r.manifests(ids)
+	if err != nil {
+		return err
+	}
+
+	batch := r.index.NewBatch()
+	for _, p := range manifests {
+		current, ok := stored[p.ID]
+		if !ok {
+			return &NotFoundError{Name: p.Name, Version: p.Version}
+		}
+
+		// Documents are replaced as a whole, so the stored download count is kept.
+		doc := newBleveDocument(p)
+		doc.Downloads = current.Downloads
+		if err := batch.Index(p.ID, doc); err != nil {
+			return errors.Wrapf(err, "failed reindexing %q", p.ID)
+		}
+	}
+
+	return r.index.Batch(batch)
+}
+
+// AddDownloads adds to the download counts of plugin versions in Bleve's index, in a single batch.
+func (r *RepoBleve) AddDownloads(ctx context.Context, counts map[string]int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+
+	stored, err := r.manifests(ids)
+	if err != nil {
+		return err
+	}
+
+	batch := r.index.NewBatch()
+	for id, m := range stored {
+		m.Downloads += counts[id]
+		if err := batch.Index(id, newBleveDocument(m)); err != nil {
+			return errors.Wrapf(err, "failed reindexing %q", id)
+		}
+	}
+
+	return r.index.Batch(batch)
+}
+
+// manifests returns the stored plugin versions with the given document IDs, keyed by ID.
+// Missing IDs are left out.
+func (r *RepoBleve) manifests(ids []string) (map[string]*Manifest, error) {
+	res := make(map[string]*Manifest, len(ids))
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	search := bleve.NewSearchRequestOptions(bleve.NewDocIDQuery(ids), len(ids), 0, false)
+	search.Fields = []string{"*"}
+
+	results, err := r.index.Search(search)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting plugin versions")
+	}
+
+	for _, h := range results.Hits {
+		m, err := manifestFromFields(h.ID, h.Fields)
+		if err != nil {
+			return nil, err
+		}
+		res[h.ID] = m
+	}
+
+	return res, nil
 }
 
 // Delete removes plugin from Bleve index.
-func (r *RepoBleve) Delete(ctx context.Context, id, accountID string) error {
+func (r *RepoBleve) Delete(ctx context.Context, id string) error {
 	if id == "" {
 		return errors.New("document ID is required")
 	}
 
-	if accountID == "" {
-		return errors.New("account ID is required")
-	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
 	doc, err := r.index.Document(id)
 	if err != nil {
-		return errors.Wrapf(err, "document ID %q does not exist", id)
+		return errors.Wrapf(err, "failed looking up document ID %q", id)
 	}
 
 	if doc == nil {
-		return fmt.Errorf("plugin %q not found", id)
+		return &NotFoundError{Name: id}
+	}
+
+	return r.index.Delete(id)
+}
+
+// ownershipKey returns the key under which the ownership record of a plugin is stored
+// in Bleve's internal storage.
+func ownershipKey(name string) []byte {
+	return []byte("ownership:" + name)
+}
+
+// Ownership gets the ownership record of a plugin from Bleve's internal storage.
+func (r *RepoBleve) Ownership(ctx context.Context, name string) (*Ownership, error) {
+	data, err := r.index.GetInternal(ownershipKey(name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed getting ownership of %q", name)
+	}
+
+	if data == nil {
+		return nil, &NotFoundError{Name: name}
 	}
 
-	for _, f := range doc.Fields {
-		if f.Name() == "_account_id" && bytes.Equal(f.Value(), []byte(accountID)) {
-			return r.index.Delete(id)
+	o := new(Ownership)
+	if err := json.Unmarshal(data, o); err != nil {
+		return nil, errors.Wrapf(err, "failed decoding ownership of %q", name)
+	}
+	return o, nil
+}
+
+// SaveOwnership stores the ownership record of a plugin in Bleve's internal storage.
+func (r *RepoBleve) SaveOwnership(ctx context.Context, o *Ownership) error {
+	if o == nil || o.Name == "" {
+		return errors.New("ownership record with plugin name is required")
+	}
+
+	data, err := json.Marshal(o)
+	if err != nil {
+		return errors.Wrapf(err, "failed encoding ownership of %q", o.Name)
+	}
+
+	return r.index.SetInternal(ownershipKey(o.Name), data)
+}
+
+// sessionsKey is the key under which the IDs of all publish sessions are stored in Bleve's
+// internal storage, since it cannot be iterated.
+var sessionsKey = []byte("sessions")
+
+// sessionKey returns the key under which a publish session is stored in Bleve's internal storage.
+func sessionKey(id string) []byte {
+	return []byte("session:" + id)
+}
+
+// Session gets a publish session from Bleve's internal storage.
+func (r *RepoBleve) Session(ctx context.Context, id string) (*Session, error) {
+	data, err := r.index.GetInternal(sessionKey(id))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed getting publish session %q", id)
+	}
+
+	if data == nil {
+		return nil, &SessionNotFoundError{ID: id}
+	}
+
+	s := new(Session)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrapf(err, "failed decoding publish session %q", id)
+	}
+	return s, nil
+}
+
+// Sessions returns all publish sessions stored in Bleve's internal storage.
+func (r *RepoBleve) Sessions(ctx context.Context) ([]*Session, error) {
+	ids, err := r.sessionIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(ids))
+	for _, id := range ids {
+		s, err := r.Session(ctx, id)
+		if _, ok := errors.Cause(err).(*SessionNotFoundError); ok {
+			// Deleted since the list was read.
+			continue
 		}
+
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// sessionIDs returns the IDs of all publish sessions, sorted.
+func (r *RepoBleve) sessionIDs() ([]string, error) {
+	data, err := r.index.GetInternal(sessionsKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed listing publish sessions")
+	}
+
+	ids := make([]string, 0)
+	if data == nil {
+		return ids, nil
+	}
+
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, errors.Wrap(err, "failed decoding publish sessions")
+	}
+	return ids, nil
+}
+
+// SaveSession stores a publish session in Bleve's internal storage. The session and the list
+// of sessions are written in the same batch.
+func (r *RepoBleve) SaveSession(ctx context.Context, s *Session) error {
+	if s == nil || s.ID == "" {
+		return errors.New("publish session with ID is required")
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrapf(err, "failed encoding publish session %q", s.ID)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids, err := r.sessionIDs()
+	if err != nil {
+		return err
 	}
 
-	return nil
+	i := sort.SearchStrings(ids, s.ID)
+	if i == len(ids) || ids[i] != s.ID {
+		ids = append(ids, "")
+		copy(ids[i+1:], ids[i:])
+		ids[i] = s.ID
+	}
+
+	return r.writeSessions(ids, func(b *bleve.Batch) {
+		b.SetInternal(sessionKey(s.ID), data)
+	})
+}
+
+// DeleteSession removes a publish session from Bleve's internal storage.
+func (r *RepoBleve) DeleteSession(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids, err := r.sessionIDs()
+	if err != nil {
+		return err
+	}
+
+	i := sort.SearchStrings(ids, id)
+	if i == len(ids) || ids[i] != id {
+		return &SessionNotFoundError{ID: id}
+	}
+	ids = append(ids[:i], ids[i+1:]...)
+
+	return r.writeSessions(ids, func(b *bleve.Batch) {
+		b.DeleteInternal(sessionKey(id))
+	})
+}
+
+// writeSessions stores the list of publish sessions along with the changes made by change.
+func (r *RepoBleve) writeSessions(ids []string, change func(b *bleve.Batch)) error {
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return errors.Wrap(err, "failed encoding publish sessions")
+	}
+
+	batch := r.index.NewBatch()
+	change(batch)
+	batch.SetInternal(sessionsKey, data)
+
+	return errors.Wrap(r.index.Batch(batch), "failed storing publish sessions")
 }
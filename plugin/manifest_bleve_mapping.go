@@ -0,0 +1,342 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/analysis/lang/en"
+	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/analysis/tokenizer/single"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// mappingVersion is the version of the index mapping returned by NewIndexMapping. It has
+// to be increased every time the mapping changes, so that existing indexes get migrated.
+// Indexes created before mappings were versioned use the default dynamic mapping and
+// are considered to be version 0. Version 1 introduced the explicit mapping, version 2
+// added the updated_at and downloads fields used for sorting search results, version 3
+// the platforms field used for filtering by operating system and architecture at once, and
+// version 4 the name_exact field used for suggesting plugin names.
+const mappingVersion = 4
+
+// mappingVersionKey is the key under which the mapping version of an index is stored in
+// Bleve's internal storage.
+var mappingVersionKey = []byte("mapping_version")
+
+// caseInsensitiveKeyword is an analyzer indexing whole values, ignoring case.
+const caseInsensitiveKeyword = "keyword_lowercase"
+
+// NewIndexMapping returns the Bleve mapping for plugin manifests.
+func NewIndexMapping() (mapping.IndexMapping, error) {
+	im := bleve.NewIndexMapping()
+	im.DefaultAnalyzer = standard.Name
+
+	err := im.AddCustomAnalyzer(caseInsensitiveKeyword, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     single.Name,
+		"token_filters": []string{lowercase.Name},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed adding case insensitive keyword analyzer")
+	}
+
+	packageMapping := bleve.NewDocumentStaticMapping()
+	packageMapping.AddFieldMappingsAt("name", storedField())
+	packageMapping.AddFieldMappingsAt("os", keywordField(keyword.Name))
+	packageMapping.AddFieldMappingsAt("arch", keywordField(keyword.Name))
+	packageMapping.AddFieldMappingsAt("checksum", keywordField(caseInsensitiveKeyword))
+	packageMapping.AddFieldMappingsAt("algorithm", keywordField(keyword.Name))
+
+	authorMapping := bleve.NewDocumentStaticMapping()
+	authorMapping.AddFieldMappingsAt("name", keywordField(keyword.Name), textField("name_text", standard.Name))
+	authorMapping.AddFieldMappingsAt("email", keywordField(caseInsensitiveKeyword))
+
+	deprecationMapping := bleve.NewDocumentStaticMapping()
+	deprecationMapping.AddFieldMappingsAt("message", storedField())
+	deprecationMapping.AddFieldMappingsAt("replacement", keywordField(keyword.Name))
+
+	publishedAt := bleve.NewDateTimeFieldMapping()
+	publishedAt.IncludeInAll = false
+
+	updatedAt := bleve.NewDateTimeFieldMapping()
+	updatedAt.IncludeInAll = false
+
+	downloads := bleve.NewNumericFieldMapping()
+	downloads.IncludeInAll = false
+
+	yanked := bleve.NewBooleanFieldMapping()
+	yanked.IncludeInAll = false
+
+	// Platforms are only searched, packages already hold their values.
+	platforms := keywordField(keyword.Name)
+	platforms.Store = false
+
+	// Exact names are only used for faceting, which returns indexed terms as they are.
+	nameExact := keywordField(keyword.Name)
+	nameExact.Name = "name_exact"
+	nameExact.Store = false
+
+	manifestMapping := bleve.NewDocumentStaticMapping()
+	manifestMapping.AddFieldMappingsAt("_id", storedField())
+	manifestMapping.AddFieldMappingsAt("_account_id", storedField())
+	manifestMapping.AddFieldMappingsAt("name", keywordField(caseInsensitiveKeyword), textField("name_text", standard.Name), nameExact)
+	manifestMapping.AddFieldMappingsAt("version", keywordField(keyword.Name))
+	manifestMapping.AddFieldMappingsAt("description", textField("", en.AnalyzerName))
+	manifestMapping.AddFieldMappingsAt("license", keywordField(keyword.Name))
+	manifestMapping.AddFieldMappingsAt("homepage", storedField())
+	manifestMapping.AddFieldMappingsAt("files_uri", storedField())
+	manifestMapping.AddFieldMappingsAt("published_at", publishedAt)
+	manifestMapping.AddFieldMappingsAt("updated_at", updatedAt)
+	manifestMapping.AddFieldMappingsAt("downloads", downloads)
+	manifestMapping.AddFieldMappingsAt("yanked", yanked)
+	manifestMapping.AddFieldMappingsAt("yank_reason", storedField())
+	manifestMapping.AddFieldMappingsAt("platforms", platforms)
+	manifestMapping.AddSubDocumentMapping("author", authorMapping)
+	manifestMapping.AddSubDocumentMapping("packages", packageMapping)
+	manifestMapping.AddSubDocumentMapping("deprecation", deprecationMapping)
+
+	im.DefaultMapping = manifestMapping
+
+	return im, nil
+}
+
+// keywordField indexes and stores whole field values, without breaking them into words.
+func keywordField(analyzer string) *mapping.FieldMapping {
+	fm := bleve.NewTextFieldMapping()
+	fm.Analyzer = analyzer
+	fm.IncludeInAll = false
+	fm.IncludeTermVectors = false
+	return fm
+}
+
+// textField indexes and stores full text. If name is set, the field is indexed under that
+// name instead of its property name, which allows indexing a property more than once.
+func textField(name, analyzer string) *mapping.FieldMapping {
+	fm := bleve.NewTextFieldMapping()
+	fm.Name = name
+	fm.Analyzer = analyzer
+	if name != "" {
+		// Only the original property needs to be stored.
+		fm.Store = false
+	}
+	return fm
+}
+
+// storedField stores a field value so that it is returned in search results, without
+// indexing it.
+func storedField() *mapping.FieldMapping {
+	fm := bleve.NewTextFieldMapping()
+	fm.Index = false
+	fm.IncludeInAll = false
+	fm.IncludeTermVectors = false
+	return fm
+}
+
+// OpenIndex opens the Bleve index at the given path, creating it if it does not exist.
+// Indexes built with an older mapping version are migrated to the current one.
+func OpenIndex(path string) (bleve.Index, error) {
+	index, err := bleve.Open(path)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		glog.Info("Bleve index does not exist, creating it....")
+		return createIndex(path)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed opening Bleve index at %q", path)
+	}
+
+	version, err := indexMappingVersion(index)
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+
+	if version > mappingVersion {
+		index.Close()
+		return nil, errors.Errorf("index at %q uses mapping version %d, which is newer than the supported version %d", path, version, mappingVersion)
+	}
+
+	if version < mappingVersion {
+		return migrateIndex(index, path, version)
+	}
+
+	return index, nil
+}
+
+// createIndex creates a new Bleve index using the current mapping version.
+func createIndex(path string) (bleve.Index, error) {
+	im, err := NewIndexMapping()
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := bleve.New(path, im)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed creating Bleve index at %q", path)
+	}
+
+	if err := index.SetInternal(mappingVersionKey, []byte(strconv.Itoa(mappingVersion))); err != nil {
+		index.Close()
+		return nil, errors.Wrap(err, "failed storing index mapping version")
+	}
+
+	return index, nil
+}
+
+// indexMappingVersion returns the version of the mapping an index was created with.
+func indexMappingVersion(index bleve.Index) (int, error) {
+	value, err := index.GetInternal(mappingVersionKey)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed reading index mapping version")
+	}
+
+	if value == nil {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid index mapping version %q", value)
+	}
+
+	return version, nil
+}
+
+// migrateIndex reindexes every plugin manifest, along with ownership records, into a new
+// index built with the current mapping, which then replaces the old index. The old index
+// is kept next to the new one, in case the migration needs to be rolled back, and is left
+// in place if the migration fails.
+func migrateIndex(old bleve.Index, path string, version int) (bleve.Index, error) {
+	glog.Infof("Migrating Bleve index at %q from mapping version %d to %d...", path, version, mappingVersion)
+
+	newPath := fmt.Sprintf("%s.v%d", path, mappingVersion)
+	backupPath := fmt.Sprintf("%s.v%d.bak", path, version)
+
+	// Leftovers from a previously failed migration.
+	if err := os.RemoveAll(newPath); err != nil {
+		old.Close()
+		return nil, errors.Wrapf(err, "failed removing %q", newPath)
+	}
+
+	index, err := createIndex(newPath)
+	if err != nil {
+		old.Close()
+		return nil, err
+	}
+
+	count, err := copyIndex(old, index)
+	old.Close()
+	index.Close()
+	if err != nil {
+		if err := os.RemoveAll(newPath); err != nil {
+			glog.Errorf("failed removing partially migrated Bleve index at %q: %+v", newPath, err)
+		}
+		return nil, errors.Wrapf(err, "failed migrating Bleve index at %q", path)
+	}
+
+	if err := os.Rename(path, backupPath); err != nil {
+		return nil, errors.Wrapf(err, "failed backing up Bleve index to %q", backupPath)
+	}
+
+	if err := os.Rename(newPath, path); err != nil {
+		return nil, errors.Wrapf(err, "failed moving migrated Bleve index to %q", path)
+	}
+
+	glog.Infof("Migrated %d plugin versions, previous index was kept at %q", count, backupPath)
+
+	return bleve.Open(path)
+}
+
+// copyIndex copies all plugin manifests and ownership records from one index to another,
+// returning the number of manifests copied. Manifests are indexed under their current
+// document ID, since indexes created before mappings were versioned used bare plugin
+// names. Corrupt documents fail the copy, so that no plugin version is silently lost.
+func copyIndex(from, to bleve.Index) (int, error) {
+	ctx := context.Background()
+	src := &RepoBleve{index: from}
+
+	count := 0
+	corrupt := make([]string, 0)
+	for offset := 0; ; offset += batchSize {
+		search := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), batchSize, offset, false)
+		search.SortBy([]string{"_id"})
+		search.Fields = []string{"*"}
+
+		results, err := from.Search(search)
+		if err != nil {
+			return count, errors.Wrap(err, "failed reading plugin manifests")
+		}
+
+		batch := to.NewBatch()
+		for _, h := range results.Hits {
+			m, err := manifestFromFields(h.ID, h.Fields)
+			if err != nil {
+				glog.Errorf("failed migrating document: %+v", err)
+				corrupt = append(corrupt, h.ID)
+				continue
+			}
+
+			m.ID = manifestID(m.Name, m.Version)
+			if err := batch.Index(m.ID, newBleveDocument(m)); err != nil {
+				return count, errors.Wrapf(err, "failed indexing %q", m.ID)
+			}
+			count++
+		}
+
+		if err := to.Batch(batch); err != nil {
+			return count, errors.Wrap(err, "failed indexing plugin manifests")
+		}
+
+		if len(results.Hits) < batchSize {
+			break
+		}
+	}
+
+	if len(corrupt) > 0 {
+		return count, errors.Errorf("%d corrupt documents cannot be migrated: %s", len(corrupt), strings.Join(corrupt, ", "))
+	}
+
+	names, err := src.Names(ctx)
+	if err != nil {
+		return count, err
+	}
+
+	for _, name := range names {
+		value, err := from.GetInternal(ownershipKey(name))
+		if err != nil {
+			return count, errors.Wrapf(err, "failed reading ownership of %q", name)
+		}
+
+		if value == nil {
+			continue
+		}
+
+		if err := to.SetInternal(ownershipKey(name), value); err != nil {
+			return count, errors.Wrapf(err, "failed copying ownership of %q", name)
+		}
+	}
+
+	// Open publish sessions survive reindexing, so that uploads in progress are not lost.
+	sessions, err := src.Sessions(ctx)
+	if err != nil {
+		return count, err
+	}
+
+	dst := &RepoBleve{index: to}
+	for _, s := range sessions {
+		if err := dst.SaveSession(ctx, s); err != nil {
+			return count, errors.Wrapf(err, "failed copying publish session %q", s.ID)
+		}
+	}
+
+	return count, nil
+}
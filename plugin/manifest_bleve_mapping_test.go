@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+// createV0Index creates an index the way it was before mappings were versioned: with the
+// default dynamic mapping, and documents keyed by bare plugin names.
+func createV0Index(t *testing.T, path string, docs map[string]map[string]interface{}, owners ...*Ownership) {
+	t.Helper()
+	index, err := bleve.New(path, bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer index.Close()
+
+	for id, doc := range docs {
+		if err := index.Index(id, doc); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	}
+
+	for _, o := range owners {
+		data, err := json.Marshal(o)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if err := index.SetInternal(ownershipKey(o.Name), data); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	}
+}
+
+func TestMigrateIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "plugins.bleve")
+	createV0Index(t, path, map[string]map[string]interface{}{
+		"lint": {
+			"_account_id":  "acc1",
+			"name":         "lint",
+			"version":      "1.0.0",
+			"description":  "Finds style mistakes",
+			"published_at": "2017-06-01T10:00:00Z",
+			"packages": []interface{}{
+				map[string]interface{}{"name": "lint-linux-x64.tar.gz", "os": "linux", "arch": "x64", "checksum": "aaa", "algorithm": "sha256"},
+			},
+		},
+		"vet": {
+			"_account_id":  "acc2",
+			"name":         "vet",
+			"version":      "2.0.0",
+			"published_at": "2017-07-01T10:00:00Z",
+			"packages": []interface{}{
+				map[string]interface{}{"name": "vet-linux-x64.tar.gz", "os": "linux", "arch": "x64", "checksum": "bbb", "algorithm": "sha256"},
+				map[string]interface{}{"name": "vet-macOS-arm64.tar.gz", "os": "macOS", "arch": "arm64", "checksum": "ccc", "algorithm": "sha512"},
+			},
+		},
+	}, &Ownership{Name: "lint", OwnerID: "acc1", Maintainers: []string{"acc3"}})
+
+	index, err := OpenIndex(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer index.Close()
+
+	if version, err := indexMappingVersion(index); err != nil || version != mappingVersion {
+		t.Errorf("expected mapping version %d, got %d (%v)", mappingVersion, version, err)
+	}
+
+	if _, err := os.Stat(path + ".v0.bak"); err != nil {
+		t.Errorf("expected previous index to be kept: %v", err)
+	}
+
+	repo := NewBleveRepository(index)
+	ctx := context.Background()
+
+	lint, err := repo.Get(ctx, "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("expected single package version to be migrated: %+v", err)
+	}
+
+	if lint.ID != "lint@1.0.0" || lint.AccountID != "acc1" || lint.Description != "Finds style mistakes" {
+		t.Errorf("unexpected migrated manifest %+v", lint)
+	}
+
+	if len(lint.Packages) != 1 || *lint.Packages[0] != (Package{Name: "lint-linux-x64.tar.gz", OS: "linux", Arch: "x64", Checksum: "aaa", Algorithm: "sha256"}) {
+		t.Errorf("unexpected migrated packages %v", lint.Packages)
+	}
+
+	versions, err := repo.Versions(ctx, "vet")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("expected one version of vet, got %v (%v)", versions, err)
+	}
+
+	expected := []*Package{
+		{Name: "vet-linux-x64.tar.gz", OS: "linux", Arch: "x64", Checksum: "bbb", Algorithm: "sha256"},
+		{Name: "vet-macOS-arm64.tar.gz", OS: "macOS", Arch: "arm64", Checksum: "ccc", Algorithm: "sha512"},
+	}
+
+	if versions[0].ID != "vet@2.0.0" || !reflect.DeepEqual(versions[0].Packages, expected) {
+		t.Errorf("unexpected migrated multi-package manifest %+v", versions[0])
+	}
+
+	// Migrated documents are indexed with the current mapping.
+	res, err := repo.Search(ctx, &SearchQuery{OS: "macOS", Arch: "arm64", Sort: SortName, PageNumber: 1, ResultsPerPage: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(res.Manifests) != 1 || res.Manifests[0].ID != "vet@2.0.0" {
+		t.Errorf("expected vet@2.0.0 to be found by platform, got %v", res.Manifests)
+	}
+
+	o, err := repo.Ownership(ctx, "lint")
+	if err != nil {
+		t.Fatalf("expected ownership to be migrated: %+v", err)
+	}
+
+	if o.OwnerID != "acc1" || !reflect.DeepEqual(o.Maintainers, []string{"acc3"}) {
+		t.Errorf("unexpected migrated ownership %+v", o)
+	}
+}
+
+func TestMigrateIndexCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "plugins.bleve")
+	createV0Index(t, path, map[string]map[string]interface{}{
+		"lint":   {"name": "lint", "version": "1.0.0"},
+		"broken": {"name": "broken"},
+	})
+
+	_, err = OpenIndex(path)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected migration to fail naming the corrupt document, got %v", err)
+	}
+
+	// The previous index is left untouched.
+	index, err := bleve.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer index.Close()
+
+	if version, err := indexMappingVersion(index); err != nil || version != 0 {
+		t.Errorf("expected previous index to keep mapping version 0, got %d (%v)", version, err)
+	}
+
+	if count, err := index.DocCount(); err != nil || count != 2 {
+		t.Errorf("expected previous index to keep its 2 documents, got %d (%v)", count, err)
+	}
+}
@@ -0,0 +1,156 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/files"
+	"github.com/hooklift/lift-registry/pkg/render"
+)
+
+// Platform is an operating system and CPU architecture pair for which a package was built.
+type Platform struct {
+	OS   OS   `json:"os"`
+	Arch Arch `json:"arch"`
+}
+
+// httpError is the body sent back when a plugin HTTP request fails.
+type httpError struct {
+	Error string `json:"error"`
+	// Platforms lists the platforms a plugin version was built for, when no package matches the requested one.
+	Platforms []Platform `json:"platforms,omitempty"`
+}
+
+// download streams down the package of a plugin version built for the platform requested by the client:
+// /plugins/<name>/<version>/download?os=<os>&arch=<arch>. The version can also be "latest".
+func download(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/plugins"), "/"), "/")
+	if len(segments) != 3 || segments[2] != "download" {
+		renderError(w, http.StatusNotFound, &httpError{Error: "Not Found"})
+		return
+	}
+
+	name, pluginVersion := segments[0], segments[1]
+	if pluginVersion == "latest" {
+		pluginVersion = ""
+	}
+
+	query := r.URL.Query()
+	platform := Platform{
+		OS:   OS(query.Get("os")),
+		Arch: Arch(query.Get("arch")),
+	}
+
+	if platform.OS == "" || platform.Arch == "" {
+		renderError(w, http.StatusBadRequest, &httpError{Error: "os and arch query parameters are required"})
+		return
+	}
+
+	ctx := r.Context()
+	m, err := Get(ctx, name, pluginVersion)
+	if err != nil {
+		switch errors.Cause(err).(type) {
+		case *NotFoundError:
+			renderError(w, http.StatusNotFound, &httpError{Error: err.Error()})
+		case *ValidationError:
+			renderError(w, http.StatusBadRequest, &httpError{Error: err.Error()})
+		default:
+			glog.Errorf("failed getting plugin %q: %+v", name, err)
+			renderError(w, http.StatusInternalServerError, &httpError{Error: "Internal Server Error"})
+		}
+		return
+	}
+
+	var pkg *Package
+	platforms := make([]Platform, 0, len(m.Packages))
+	for _, p := range m.Packages {
+		if p.OS == platform.OS && p.Arch == platform.Arch {
+			pkg = p
+			break
+		}
+		platforms = append(platforms, Platform{OS: p.OS, Arch: p.Arch})
+	}
+
+	if pkg == nil {
+		renderError(w, http.StatusNotFound, &httpError{
+			Error:     fmt.Sprintf("version %s of plugin %q has no package for %s/%s", m.Version, m.Name, platform.OS, platform.Arch),
+			Platforms: platforms,
+		})
+		return
+	}
+
+	key, err := files.Key(m.AccountID, m.Name, m.Version, pkg.Name)
+	if err != nil {
+		glog.Errorf("invalid package %q for plugin %q: %+v", pkg.Name, m.ID, err)
+		renderError(w, http.StatusInternalServerError, &httpError{Error: "Internal Server Error"})
+		return
+	}
+
+	reader, err := Storage.Get(ctx, key)
+	if errors.Cause(err) == files.ErrNotFound {
+		glog.Errorf("package %q of plugin %q is missing from storage: %+v", pkg.Name, m.ID, err)
+		renderError(w, http.StatusNotFound, &httpError{
+			Error: fmt.Sprintf("package %q of version %s of plugin %q is not available", pkg.Name, m.Version, m.Name),
+		})
+		return
+	}
+
+	if err != nil {
+		glog.Errorf("failed getting package %q from storage provider: %+v", key, err)
+		renderError(w, http.StatusInternalServerError, &httpError{Error: "Internal Server Error"})
+		return
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			glog.Errorf("failed closing file reader: %+v", err)
+		}
+	}()
+
+	RecordDownload(m)
+
+	headers := w.Header()
+	headers.Set("Content-Type", "application/octet-stream")
+	headers.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": pkg.Name}))
+
+	if _, err := io.Copy(w, reader); err != nil {
+		glog.Errorf("error streaming object from storage provider down to the user: %+v", err)
+	}
+}
+
+// renderError sends back an error as JSON.
+func renderError(w http.ResponseWriter, status int, body *httpError) {
+	if err := render.JSON(w, render.WithStatus(status), render.WithBody(body)); err != nil {
+		glog.Errorf("failed rendering error response: %+v", err)
+	}
+}
+
+// Handler handles /plugins HTTP requests that are not suited for gRPC, such as streaming
+// packages down to clients, or up to publish sessions.
+func Handler(h http.Handler) http.Handler {
+	registry := map[string]map[string]func(http.ResponseWriter, *http.Request){
+		"/plugins/": {
+			"GET":  download,
+			"POST": uploadSessionFiles,
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for p, handlers := range registry {
+			if strings.HasPrefix(req.URL.Path, p) {
+				if handlerFn, ok := handlers[req.Method]; ok {
+					handlerFn(w, req)
+					return
+				}
+				http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+				return
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,140 @@
+package plugin_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/hooklift/lift-registry/files"
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+// uploadPackages stores the given files, keyed by name, in the namespace of a plugin version and
+// returns the stored objects, keyed by file name.
+func uploadPackages(t *testing.T, storage files.StorageProvider, prefix string, packages map[string]string) map[string]*files.Object {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for name, content := range packages {
+		fw, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("failed creating form file: %+v", err)
+		}
+
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed writing form file: %+v", err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed closing multipart writer: %+v", err)
+	}
+
+	reader := multipart.NewReader(body, writer.Boundary())
+	objects, err := storage.Upload(context.Background(), prefix, reader)
+	if err != nil {
+		t.Fatalf("failed uploading packages: %+v", err)
+	}
+
+	stored := make(map[string]*files.Object)
+	for _, o := range objects {
+		stored[path.Base(o.Key)] = o
+	}
+	return stored
+}
+
+func TestDownload(t *testing.T) {
+	plugin.Storage = files.NewMemory()
+	uploadPackages(t, plugin.Storage, "acc1/lint/1.0.0", map[string]string{
+		"lint-linux-arm64.tar.gz": "linux arm64 bits",
+		"lint-macOS-x64.tar.gz":   "macOS x64 bits",
+	})
+	uploadPackages(t, plugin.Storage, "acc1/lint/2.0.0", map[string]string{
+		"lint-linux-x64.tar.gz": "linux x64 bits",
+	})
+
+	plugin.Repo = plugintest.NewMemoryRepository(
+		&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.0.0", Packages: []*plugin.Package{
+			{Name: "lint-linux-arm64.tar.gz", OS: "linux", Arch: "arm64"},
+			{Name: "lint-macOS-x64.tar.gz", OS: "macOS", Arch: "x64"},
+		}},
+		&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "2.0.0", Packages: []*plugin.Package{
+			{Name: "lint-linux-x64.tar.gz", OS: "linux", Arch: "x64"},
+		}},
+		&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "2.1.0-beta1", Packages: []*plugin.Package{
+			{Name: "lint-linux-x64.tar.gz", OS: "linux", Arch: "x64"},
+		}},
+	)
+
+	handler := plugin.Handler(http.NotFoundHandler())
+
+	tests := []struct {
+		desc      string
+		path      string
+		status    int
+		body      string
+		platforms int
+	}{
+		{"exact version", "/plugins/lint/1.0.0/download?os=linux&arch=arm64", http.StatusOK, "linux arm64 bits", 0},
+		{"latest version", "/plugins/lint/latest/download?os=linux&arch=x64", http.StatusOK, "linux x64 bits", 0},
+		{"no package for platform", "/plugins/lint/1.0.0/download?os=windows&arch=x86", http.StatusNotFound, "", 2},
+		{"missing platform", "/plugins/lint/1.0.0/download", http.StatusBadRequest, "", 0},
+		{"missing package file", "/plugins/lint/2.1.0-beta1/download?os=linux&arch=x64", http.StatusNotFound, "", 0},
+		{"missing version", "/plugins/lint/3.0.0/download?os=linux&arch=x64", http.StatusNotFound, "", 0},
+		{"invalid version", "/plugins/lint/next/download?os=linux&arch=x64", http.StatusBadRequest, "", 0},
+		{"missing plugin", "/plugins/vet/latest/download?os=linux&arch=x64", http.StatusNotFound, "", 0},
+		{"unknown route", "/plugins/lint/1.0.0", http.StatusNotFound, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+
+			if tt.status == http.StatusOK {
+				if w.Body.String() != tt.body {
+					t.Errorf("expected body %q, got %q", tt.body, w.Body.String())
+				}
+				return
+			}
+
+			res := new(plugin.HTTPError)
+			if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+				t.Fatalf("failed decoding error response: %+v", err)
+			}
+
+			if len(res.Platforms) != tt.platforms {
+				t.Errorf("expected %d available platforms, got %d", tt.platforms, len(res.Platforms))
+			}
+		})
+	}
+
+	// Downloads are only added to the repository once flushed.
+	if m, _ := plugin.Repo.Get(context.Background(), "lint", "1.0.0"); m == nil || m.Downloads != 0 {
+		t.Errorf("expected downloads to be counted in memory until flushed, got %+v", m)
+	}
+
+	if err := plugin.FlushDownloads(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for version, downloads := range map[string]int64{"1.0.0": 1, "2.0.0": 1} {
+		m, err := plugin.Repo.Get(context.Background(), "lint", version)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if m.Downloads != downloads {
+			t.Errorf("expected %d downloads of version %s, got %d", downloads, version, m.Downloads)
+		}
+	}
+}
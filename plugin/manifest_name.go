@@ -0,0 +1,186 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/config"
+)
+
+// Plugin names are also used as part of document IDs, storage keys and URLs, so they
+// are limited to ASCII letters, digits and separators, starting and ending with a letter
+// or digit.
+const (
+	minNameLength = 2
+	maxNameLength = 64
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+// minTyposquatLength is the minimum length a name skeleton needs to have for edit-distance
+// checks to apply. Shorter names are too close to each other to be checked this way.
+const minTyposquatLength = 5
+
+// confusables maps characters, or character sequences, to the character they are easily
+// mistaken for.
+var confusables = strings.NewReplacer(
+	"0", "o",
+	"1", "l",
+	"i", "l",
+	"3", "e",
+	"5", "s",
+	"rn", "m",
+	"vv", "w",
+	"-", "",
+	"_", "",
+	".", "",
+)
+
+// validateName checks a plugin name complies with the naming charset and length limits.
+func validateName(verr *ValidationError, name string) {
+	if name == "" {
+		verr.Add("name", "plugin name is required")
+		return
+	}
+
+	if len(name) < minNameLength || len(name) > maxNameLength {
+		verr.Add("name", fmt.Sprintf("plugin name must be between %d and %d characters long", minNameLength, maxNameLength))
+	}
+
+	if !namePattern.MatchString(name) {
+		verr.Add("name", "plugin name can only contain letters, digits, dots, hyphens and underscores, and must start and end with a letter or digit")
+	}
+}
+
+// checkNamePolicy makes sure a new plugin name is not reserved and cannot be confused with
+// the name of an existing plugin. Publishing new versions of an existing plugin is always
+// allowed by this policy, even if its name was reserved since. Names differing only in case
+// are also refused by repositories, which settles concurrent publishes of such names.
+func checkNamePolicy(ctx context.Context, p *Manifest) error {
+	// New versions of existing plugins are looked up first, so that listing every plugin is
+	// only needed for new names.
+	versions, err := Repo.Versions(ctx, p.Name)
+	if err != nil {
+		return errors.Wrapf(err, "failed looking up versions of %q", p.Name)
+	}
+
+	if len(versions) > 0 {
+		return nil
+	}
+
+	verr := new(ValidationError)
+	skeleton := nameSkeleton(p.Name)
+
+	for _, reserved := range config.ReservedNames {
+		if strings.EqualFold(p.Name, reserved) || skeleton == nameSkeleton(reserved) {
+			verr.Add("name", fmt.Sprintf("plugin name %q is reserved", p.Name))
+			return verr
+		}
+	}
+
+	names, err := Repo.Names(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed listing plugin names")
+	}
+
+	for _, name := range names {
+		if strings.EqualFold(name, p.Name) {
+			verr.Add("name", fmt.Sprintf("plugin name %q is already taken by %q", p.Name, name))
+			return verr
+		}
+
+		if !confusable(skeleton, nameSkeleton(name)) {
+			continue
+		}
+
+		// Publishers are free to have plugins with similar names.
+		owned, err := ownsName(ctx, p.AccountID, name)
+		if err != nil {
+			return err
+		}
+
+		if !owned {
+			verr.Add("name", fmt.Sprintf("plugin name %q is too similar to existing plugin %q", p.Name, name))
+			return verr
+		}
+	}
+
+	return nil
+}
+
+// ownsName returns whether an account owns or maintains the given plugin.
+func ownsName(ctx context.Context, accountID, name string) (bool, error) {
+	o, err := GetOwnership(ctx, name)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed getting ownership of %q", name)
+	}
+
+	return o.CanPublish(accountID), nil
+}
+
+// nameSkeleton normalizes a name so that names that look alike have the same skeleton.
+func nameSkeleton(name string) string {
+	return confusables.Replace(strings.ToLower(name))
+}
+
+// confusable returns whether two name skeletons are equal or, for long enough names, one
+// edit away from each other.
+func confusable(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	if len(a) < minTyposquatLength || len(b) < minTyposquatLength {
+		return false
+	}
+
+	return editDistance(a, b) <= 1
+}
+
+// editDistance returns the number of insertions, deletions, substitutions or transpositions
+// of adjacent characters needed to turn a into b. Transpositions are accounted for since
+// swapping letters is one of the most common typos.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,97 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/config"
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+func TestCheckNamePolicy(t *testing.T) {
+	// requests was published before its name got reserved.
+	config.ReservedNames = []string{"lift", "registry", "requests"}
+	plugin.Repo = plugintest.NewMemoryRepository(
+		&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.0.0"},
+		&plugin.Manifest{AccountID: "acc1", Name: "requests", Version: "1.0.0"},
+		&plugin.Manifest{AccountID: "acc2", Name: "Formatter", Version: "1.0.0"},
+	)
+
+	tests := []struct {
+		desc      string
+		accountID string
+		name      string
+		valid     bool
+	}{
+		{"new plugin", "acc3", "vet", true},
+		{"new version of existing plugin", "acc1", "lint", true},
+		{"new version of plugin whose name was reserved since", "acc1", "requests", true},
+		{"reserved name", "acc3", "lift", false},
+		{"reserved name in other case", "acc3", "Registry", false},
+		{"reserved name lookalike", "acc3", "l1ft", false},
+		{"existing name in other case", "acc3", "LINT", false},
+		{"existing name with separators", "acc3", "l-i-n-t", false},
+		{"existing name with confusable characters", "acc3", "1int", false},
+		{"typosquatting", "acc3", "reqeusts", false},
+		{"typosquatting own plugin", "acc1", "request", true},
+		{"typosquatting in other case", "acc3", "formater", false},
+		{"short names are only checked for lookalikes", "acc3", "lent", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := plugin.CheckNamePolicy(context.Background(), &plugin.Manifest{AccountID: tt.accountID, Name: tt.name})
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+				return
+			}
+
+			if _, ok := errors.Cause(err).(*plugin.ValidationError); !ok {
+				t.Fatalf("expected *ValidationError, got %T: %v", err, err)
+			}
+		})
+	}
+}
+
+// Concurrent publishes may all pass checkNamePolicy, leaving the repository to refuse names
+// differing only in case.
+func TestSaveManifestNameTaken(t *testing.T) {
+	plugin.Repo = plugintest.NewMemoryRepository(&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.0.0"})
+
+	err := plugin.SaveManifest(context.Background(), &plugin.Manifest{AccountID: "acc2", Name: "Lint", Version: "1.0.0"}, nil)
+	if _, ok := errors.Cause(err).(*plugin.ValidationError); !ok {
+		t.Fatalf("expected *ValidationError, got %T: %v", err, err)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"lint", true},
+		{"go-lint_2.0", true},
+		{"Lint", true},
+		{"l", false},
+		{"-lint", false},
+		{"lint.", false},
+		{"lint@1.0.0", false},
+		{"lint/vet", false},
+		{"línt", false},
+		{"a123456789012345678901234567890123456789012345678901234567890123456789", false},
+	}
+
+	for _, tt := range tests {
+		verr := new(plugin.ValidationError)
+		plugin.ValidateName(verr, tt.name)
+
+		if valid := verr.ErrorOrNil() == nil; valid != tt.valid {
+			t.Errorf("expected validity of %q to be %t, got %t: %v", tt.name, tt.valid, valid, verr.ErrorOrNil())
+		}
+	}
+}
@@ -1,14 +1,22 @@
 package plugin
 
 import (
+	"net/url"
+	"path"
+
 	"github.com/c4milo/handlers/grpcutil"
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/pkg/errors"
 	context "golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	api "github.com/hooklift/apis/go/lift"
-	identity "github.com/hooklift/uaa/pkg/client"
+	"github.com/hooklift/lift-registry/pkg/auth"
 )
 
 // Service implements Lift Registry service.
@@ -16,73 +24,286 @@ type Service struct{}
 
 // Search finds Lift plugins in the registry.
 func (s *Service) Search(ctx context.Context, r *api.SearchRequest) (*api.SearchResponse, error) {
-	res := new(api.SearchResponse)
+	q := &SearchQuery{
+		Text:           r.Query,
+		OS:             OS(r.Os),
+		Arch:           Arch(r.Arch),
+		License:        r.License,
+		Author:         r.Author,
+		PageNumber:     int(r.PageNumber),
+		ResultsPerPage: int(r.ResultPerPage),
+		PageToken:      r.PageToken,
+		Sort:           SortOrder(r.Sort),
+	}
+
+	if r.PublishedAfter != nil {
+		publishedAfter, err := ptypes.Timestamp(r.PublishedAfter)
+		if err != nil {
+			verr := new(ValidationError)
+			verr.Add("published_after", "invalid timestamp")
+			return nil, grpcError(verr)
+		}
+		q.PublishedAfter = publishedAfter
+	}
 
-	matches, err := Search(ctx, r.Query, int(r.PageNumber), int(r.ResultPerPage))
+	result, err := Search(ctx, q)
 	if err != nil {
-		return res, err
+		return nil, grpcError(err)
 	}
 
-	// Annoying conversion from domain object to api object.
-	for _, m := range matches {
-		manifest := new(api.PluginManifest)
-		manifest.Author = &api.Author{
-			Name:  m.Author.Name,
-			Email: m.Author.Email,
+	res := new(api.SearchResponse)
+	res.Total = int64(result.Total)
+	res.Took = ptypes.DurationProto(result.Took)
+	res.NextPageToken = result.NextPageToken
+
+	for _, m := range result.Manifests {
+		manifest, err := toAPIManifest(m)
+		if err != nil {
+			glog.Errorf("invalid timestamp received by search index for package %q: %+v", m.Name, err)
+			continue
 		}
-		manifest.Description = m.Description
-		manifest.Homepage = m.Homepage
-		manifest.Name = m.Name
-		manifest.Version = m.Version
-		manifest.FilesUri = m.FilesURI
-		manifest.License = m.License
+		res.Plugins = append(res.Plugins, manifest)
+	}
+
+	for _, f := range result.Facets {
+		facet := new(api.Facet)
+		facet.Name = f.Name
+		for _, t := range f.Terms {
+			facet.Terms = append(facet.Terms, &api.FacetTerm{
+				Term:  t.Term,
+				Count: int64(t.Count),
+			})
+		}
+		res.Facets = append(res.Facets, facet)
+	}
+
+	return res, nil
+}
+
+// Suggest returns plugin name completions and corrections for the text typed so far in a search box.
+func (s *Service) Suggest(ctx context.Context, r *api.SuggestRequest) (*api.SuggestResponse, error) {
+	suggestions, err := Suggest(ctx, r.Text, int(r.Limit))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	res := new(api.SuggestResponse)
+	res.Completions = suggestions.Completions
+	res.Corrections = suggestions.Corrections
+
+	return res, nil
+}
+
+// Get returns a plugin manifest by its exact name and, optionally, version.
+func (s *Service) Get(ctx context.Context, r *api.GetRequest) (*api.GetResponse, error) {
+	m, err := Get(ctx, r.Name, r.Version)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	manifest, err := toAPIManifest(m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid timestamp received by search index for package %q", m.Name)
+	}
+
+	setDeprecationHeaders(ctx, m)
+
+	res := new(api.GetResponse)
+	res.Plugin = manifest
 
+	return res, nil
+}
+
+// Versions returns the release history of a plugin.
+func (s *Service) Versions(ctx context.Context, r *api.VersionsRequest) (*api.VersionsResponse, error) {
+	manifests, err := Versions(ctx, r.Name)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	res := new(api.VersionsResponse)
+	for _, m := range manifests {
 		publishedAt, err := ptypes.TimestampProto(m.PublishedAt)
 		if err != nil {
-			glog.Errorf("invalid timestamp received by search index for package %q: %+v", err, m.Name)
+			glog.Errorf("invalid timestamp received by search index for package %q: %+v", m.Name, err)
 			continue
 		}
-		manifest.PublishedAt = publishedAt
-
-		manifest.Packages = make([]*api.Package, 0)
-		for _, p := range m.Packages {
-			pkg := new(api.Package)
-			pkg.Name = p.Name
-			pkg.Algorithm = string(p.Algorithm)
-			pkg.Checksum = p.Checksum
-			pkg.Arch = string(p.Arch)
-			pkg.Os = string(p.OS)
-
-			manifest.Packages = append(manifest.Packages, pkg)
-		}
-		res.Plugins = append(res.Plugins, manifest)
+
+		res.Versions = append(res.Versions, &api.PluginVersion{
+			Version:     m.Version,
+			PublishedAt: publishedAt,
+			Packages:    toAPIPackages(m.Packages),
+			Yanked:      m.Yanked,
+			YankReason:  m.YankReason,
+			Deprecation: toAPIDeprecation(m.Deprecation),
+		})
 	}
 
 	return res, nil
 }
 
-// Publish indexes plugin metadata.
-func (s *Service) Publish(ctx context.Context, r *api.PublishRequest) (*api.PublishResponse, error) {
-	token, ok := identity.FromContext(ctx)
+// Resolve finds the highest version of a plugin matching a version constraint and
+// returns the package to install on the caller's platform.
+func (s *Service) Resolve(ctx context.Context, r *api.ResolveRequest) (*api.ResolveResponse, error) {
+	m, pkg, err := Resolve(ctx, r.Name, r.Constraint, OS(r.Os), Arch(r.Arch))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	manifest, err := toAPIManifest(m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid timestamp received by search index for package %q", m.Name)
+	}
+
+	setDeprecationHeaders(ctx, m)
+
+	res := new(api.ResolveResponse)
+	res.Plugin = manifest
+	res.Package = toAPIPackages([]*Package{pkg})[0]
+
+	return res, nil
+}
+
+// toAPIManifest does the annoying conversion from domain object to api object.
+func toAPIManifest(m *Manifest) (*api.PluginManifest, error) {
+	manifest := new(api.PluginManifest)
+	manifest.Author = &api.Author{
+		Name:  m.Author.Name,
+		Email: m.Author.Email,
+	}
+	manifest.Description = m.Description
+	manifest.Homepage = m.Homepage
+	manifest.Name = m.Name
+	manifest.Version = m.Version
+	manifest.FilesUri = m.FilesURI
+	manifest.License = m.License
+
+	publishedAt, err := ptypes.TimestampProto(m.PublishedAt)
+	if err != nil {
+		return nil, err
+	}
+	manifest.PublishedAt = publishedAt
+	manifest.Packages = toAPIPackages(m.Packages)
+	manifest.Yanked = m.Yanked
+	manifest.YankReason = m.YankReason
+	manifest.Deprecation = toAPIDeprecation(m.Deprecation)
+	manifest.Downloads = m.Downloads
+
+	updatedAt, err := ptypes.TimestampProto(m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	manifest.UpdatedAt = updatedAt
+
+	return manifest, nil
+}
+
+// toAPIDeprecation converts a domain deprecation to an api one.
+func toAPIDeprecation(d *Deprecation) *api.Deprecation {
+	if d == nil {
+		return nil
+	}
+
+	res := new(api.Deprecation)
+	res.Message = d.Message
+	res.Replacement = d.Replacement
+	return res
+}
+
+// Headers sent back when the requested plugin version is deprecated, so that clients
+// can warn users on install. Since header values must be printable ASCII, the
+// deprecation message is percent-encoded, as done by url.PathEscape.
+const (
+	deprecatedHeader  = "lift-deprecated"
+	replacementHeader = "lift-replacement"
+)
+
+// setDeprecationHeaders sends deprecation headers back to the client if the given plugin
+// version is deprecated.
+func setDeprecationHeaders(ctx context.Context, m *Manifest) {
+	if m.Deprecation == nil {
+		return
+	}
+
+	if err := grpc.SetHeader(ctx, deprecationHeaders(m.Deprecation)); err != nil {
+		glog.Errorf("failed setting deprecation headers for %q: %+v", m.ID, err)
+	}
+}
+
+// deprecationHeaders returns the headers describing a deprecation.
+func deprecationHeaders(d *Deprecation) metadata.MD {
+	md := metadata.Pairs(deprecatedHeader, url.PathEscape(d.Message))
+	if d.Replacement != "" {
+		md.Append(replacementHeader, d.Replacement)
+	}
+	return md
+}
+
+// toAPIPackages converts domain packages to api packages.
+func toAPIPackages(packages []*Package) []*api.Package {
+	res := make([]*api.Package, 0, len(packages))
+	for _, p := range packages {
+		pkg := new(api.Package)
+		pkg.Name = p.Name
+		pkg.Algorithm = string(p.Algorithm)
+		pkg.Checksum = p.Checksum
+		pkg.Arch = string(p.Arch)
+		pkg.Os = string(p.OS)
+
+		res = append(res, pkg)
+	}
+	return res
+}
+
+// authorize returns the account making the request, making sure it was granted any of the
+// given scopes.
+func authorize(ctx context.Context, scopes ...string) (*auth.Account, error) {
+	account, ok := auth.FromContext(ctx)
 	if !ok {
 		glog.V(3).Info("token not found in context")
-		return nil, errors.New("unauthorized")
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
 	}
 
-	_, oka := token.Scopes["admin"]
-	_, okw := token.Scopes["write"]
-
-	if !oka && !okw {
+	if !account.HasScope(scopes...) {
 		glog.V(3).Info("token scope not sufficient for this endpoint")
-		return nil, errors.New("unauthorized")
+		return nil, status.Error(codes.PermissionDenied, "insufficient scope")
+	}
+
+	return account, nil
+}
+
+// Publish indexes plugin metadata.
+//
+// Deprecated: clients should use OpenPublishSession and FinalizePublishSession instead.
+func (s *Service) Publish(ctx context.Context, r *api.PublishRequest) (*api.PublishResponse, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
+		return nil, err
 	}
 
+	manifest := fromAPIManifest(r.GetPlugin())
+	manifest.AccountID = account.ID
+	glog.Warningf("account %q published %q outside of a publish session", account.ID, manifest.Name)
+
+	res := new(api.PublishResponse)
+	if err := Publish(ctx, manifest); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return res, nil
+}
+
+// fromAPIManifest converts a manifest sent by clients to a domain one.
+func fromAPIManifest(p *api.PluginManifest) *Manifest {
 	manifest := new(Manifest)
-	p := r.GetPlugin()
+	if p == nil {
+		return manifest
+	}
 
 	manifest.Name = p.Name
-	manifest.AccountID = token.Subject
-	manifest.Author = Author(*p.Author)
+	if p.Author != nil {
+		manifest.Author = Author(*p.Author)
+	}
 	manifest.Description = p.Description
 	manifest.Homepage = p.Homepage
 	manifest.License = p.License
@@ -101,35 +322,256 @@ func (s *Service) Publish(ctx context.Context, r *api.PublishRequest) (*api.Publ
 		manifest.Packages = append(manifest.Packages, pkg)
 	}
 
-	res := new(api.PublishResponse)
-	if err := Publish(ctx, manifest); err != nil {
+	return manifest
+}
+
+// OpenPublishSession opens a session to upload the packages of a new plugin version to. The
+// version is published once the session is finalized.
+func (s *Service) OpenPublishSession(ctx context.Context, r *api.OpenPublishSessionRequest) (*api.PublishSession, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := OpenSession(ctx, account.ID, r.Name, r.Version)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return toAPISession(session)
+}
+
+// FinalizePublishSession verifies the packages uploaded to a publish session against the
+// given manifest and publishes the plugin version.
+func (s *Service) FinalizePublishSession(ctx context.Context, r *api.FinalizePublishSessionRequest) (*api.FinalizePublishSessionResponse, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
 		return nil, err
 	}
 
+	manifest := fromAPIManifest(r.GetPlugin())
+	if err := FinalizeSession(ctx, r.Id, account.ID, manifest); err != nil {
+		return nil, grpcError(err)
+	}
+
+	p, err := toAPIManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(api.FinalizePublishSessionResponse)
+	res.Plugin = p
 	return res, nil
 }
 
-// Unpublish ...
-func (s *Service) Unpublish(ctx context.Context, r *api.UnpublishRequest) (*api.UnpublishResponse, error) {
-	token, ok := identity.FromContext(ctx)
-	if !ok {
-		return nil, errors.New("unauthorized")
+// toAPISession converts a domain publish session to an api one.
+func toAPISession(s *Session) (*api.PublishSession, error) {
+	res := new(api.PublishSession)
+	res.Id = s.ID
+	res.Name = s.Name
+	res.Version = s.Version
+	res.UploadUrl = sessionUploadURL(s.ID)
+
+	expiresAt, err := ptypes.TimestampProto(s.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	res.ExpiresAt = expiresAt
+
+	res.Files = make([]string, 0, len(s.Files))
+	for _, f := range s.Files {
+		res.Files = append(res.Files, path.Base(f.Key))
 	}
 
-	_, oka := token.Scopes["admin"]
-	_, okw := token.Scopes["write"]
+	return res, nil
+}
 
-	if !oka && !okw {
-		return nil, errors.New("unauthorized")
+// Unpublish yanks a plugin version, which is never removed from the registry. Admin tokens can
+// unpublish any plugin.
+func (s *Service) Unpublish(ctx context.Context, r *api.UnpublishRequest) (*api.UnpublishResponse, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
+		return nil, err
 	}
 
 	res := new(api.UnpublishResponse)
-	if err := Unpublish(ctx, r.Id, token.Subject); err != nil {
-		return nil, err
+	if err := Unpublish(ctx, r.Id, account.ID, account.HasScope("admin")); err != nil {
+		return nil, grpcError(err)
 	}
 	return res, nil
 }
 
+// Yank hides a plugin version from search results and version resolution. Yanked versions
+// can still be downloaded by their exact version number.
+func (s *Service) Yank(ctx context.Context, r *api.YankRequest) (*api.YankResponse, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Yank(ctx, r.Name, r.Version, account.ID, r.Reason, account.HasScope("admin")); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return new(api.YankResponse), nil
+}
+
+// Unyank makes a yanked plugin version visible again.
+func (s *Service) Unyank(ctx context.Context, r *api.UnyankRequest) (*api.UnyankResponse, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Unyank(ctx, r.Name, r.Version, account.ID, account.HasScope("admin")); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return new(api.UnyankResponse), nil
+}
+
+// Deprecate marks the versions of a plugin matching a version constraint as deprecated.
+func (s *Service) Deprecate(ctx context.Context, r *api.DeprecateRequest) (*api.DeprecateResponse, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Deprecation{Message: r.Message, Replacement: r.Replacement}
+	if err := Deprecate(ctx, r.Name, r.Constraint, account.ID, d, account.HasScope("admin")); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return new(api.DeprecateResponse), nil
+}
+
+// Undeprecate removes the deprecation of the versions of a plugin matching a version constraint.
+func (s *Service) Undeprecate(ctx context.Context, r *api.UndeprecateRequest) (*api.UndeprecateResponse, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Undeprecate(ctx, r.Name, r.Constraint, account.ID, account.HasScope("admin")); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return new(api.UndeprecateResponse), nil
+}
+
+// GetOwnership returns who owns and maintains a plugin.
+func (s *Service) GetOwnership(ctx context.Context, r *api.GetOwnershipRequest) (*api.Ownership, error) {
+	o, err := GetOwnership(ctx, r.Name)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return toAPIOwnership(o), nil
+}
+
+// TransferOwnership offers the ownership of a plugin to another account, which has to accept it.
+func (s *Service) TransferOwnership(ctx context.Context, r *api.TransferOwnershipRequest) (*api.TransferOwnershipResponse, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := TransferOwnership(ctx, r.Name, account.ID, r.AccountId); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return new(api.TransferOwnershipResponse), nil
+}
+
+// AcceptOwnership completes a pending ownership transfer to the calling account.
+func (s *Service) AcceptOwnership(ctx context.Context, r *api.AcceptOwnershipRequest) (*api.AcceptOwnershipResponse, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := AcceptOwnership(ctx, r.Name, account.ID); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return new(api.AcceptOwnershipResponse), nil
+}
+
+// AddMaintainer allows another account to publish new versions of a plugin.
+func (s *Service) AddMaintainer(ctx context.Context, r *api.AddMaintainerRequest) (*api.AddMaintainerResponse, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := AddMaintainer(ctx, r.Name, account.ID, r.AccountId); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return new(api.AddMaintainerResponse), nil
+}
+
+// RemoveMaintainer revokes the permission of an account to publish new versions of a plugin.
+func (s *Service) RemoveMaintainer(ctx context.Context, r *api.RemoveMaintainerRequest) (*api.RemoveMaintainerResponse, error) {
+	account, err := authorize(ctx, "admin", "write")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RemoveMaintainer(ctx, r.Name, account.ID, r.AccountId); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return new(api.RemoveMaintainerResponse), nil
+}
+
+// toAPIOwnership converts a domain ownership record to an api one.
+func toAPIOwnership(o *Ownership) *api.Ownership {
+	res := new(api.Ownership)
+	res.Name = o.Name
+	res.OwnerId = o.OwnerID
+	res.Maintainers = o.Maintainers
+	res.PendingOwnerId = o.PendingOwnerID
+	return res
+}
+
+// grpcError maps domain errors to gRPC status errors, so that clients get meaningful status codes.
+func grpcError(err error) error {
+	switch e := errors.Cause(err).(type) {
+	case *VersionExistsError:
+		return status.Error(codes.AlreadyExists, e.Error())
+	case *PublishInProgressError:
+		return status.Error(codes.Aborted, e.Error())
+	case *PackageExistsError:
+		return status.Error(codes.FailedPrecondition, e.Error())
+	case *NotFoundError:
+		return status.Error(codes.NotFound, e.Error())
+	case *NoMatchError:
+		return status.Error(codes.NotFound, e.Error())
+	case *SessionNotFoundError:
+		return status.Error(codes.NotFound, e.Error())
+	case *PermissionDeniedError:
+		return status.Error(codes.PermissionDenied, e.Error())
+	case *ValidationError:
+		st := status.New(codes.InvalidArgument, e.Error())
+		details := new(errdetails.BadRequest)
+		for _, f := range e.Fields {
+			details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       f.Field,
+				Description: f.Description,
+			})
+		}
+
+		st, err := st.WithDetails(details)
+		if err != nil {
+			glog.Errorf("failed adding field violations to status: %+v", err)
+			return status.Error(codes.InvalidArgument, e.Error())
+		}
+		return st.Err()
+	}
+	return err
+}
+
 // Register registers service with a given GRPC server.
 func Register(binding grpcutil.ServiceBinding) error {
 	// Creates a new service instance.
@@ -0,0 +1,180 @@
+package plugin_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	api "github.com/hooklift/apis/go/lift"
+	"github.com/hooklift/lift-registry/files"
+	"github.com/hooklift/lift-registry/pkg/auth"
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+func TestUnpublish(t *testing.T) {
+	tests := []struct {
+		desc    string
+		account *auth.Account
+		id      string
+		code    codes.Code
+	}{
+		{"owner", &auth.Account{ID: "acc1", Scopes: map[string]bool{"write": true}}, "lint@1.0.0", codes.OK},
+		{"maintainer", &auth.Account{ID: "acc2", Scopes: map[string]bool{"write": true}}, "lint@1.0.0", codes.OK},
+		{"non-owner", &auth.Account{ID: "acc3", Scopes: map[string]bool{"write": true}}, "lint@1.0.0", codes.PermissionDenied},
+		{"admin overrides ownership", &auth.Account{ID: "acc3", Scopes: map[string]bool{"admin": true}}, "lint@1.0.0", codes.OK},
+		{"insufficient scope", &auth.Account{ID: "acc1", Scopes: map[string]bool{"read": true}}, "lint@1.0.0", codes.PermissionDenied},
+		{"unauthenticated", nil, "lint@1.0.0", codes.Unauthenticated},
+		{"missing version", &auth.Account{ID: "acc1", Scopes: map[string]bool{"write": true}}, "lint@2.0.0", codes.NotFound},
+		{"invalid ID", &auth.Account{ID: "acc1", Scopes: map[string]bool{"write": true}}, "lint", codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			repo := plugintest.NewMemoryRepository(&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.0.0"})
+			if err := repo.SaveOwnership(context.Background(), &plugin.Ownership{Name: "lint", OwnerID: "acc1", Maintainers: []string{"acc2"}}); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			plugin.Repo = repo
+
+			ctx := context.Background()
+			if tt.account != nil {
+				ctx = auth.NewContext(ctx, tt.account)
+			}
+
+			_, err := new(plugin.Service).Unpublish(ctx, &api.UnpublishRequest{Id: tt.id})
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("expected code %s, got %s: %v", tt.code, code, err)
+			}
+
+			// Unpublished versions are yanked, never removed.
+			m, err := repo.Get(context.Background(), "lint", "1.0.0")
+			if err != nil {
+				t.Fatalf("expected version to be kept, got %+v", err)
+			}
+
+			if yanked := tt.code == codes.OK; m.Yanked != yanked {
+				t.Errorf("expected yanked to be %t, got %+v", yanked, m)
+			}
+		})
+	}
+}
+
+func TestUnpublishedVersionsCannotBeRepublished(t *testing.T) {
+	ctx := context.Background()
+	plugin.Storage = files.NewMemory()
+	plugin.Repo = plugintest.NewMemoryRepository()
+
+	prefix, err := files.Prefix("acc1", "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	stored := uploadPackages(t, plugin.Storage, prefix, map[string]string{"lint-linux-x64.tar.gz": "linux x64 bits"})
+	newManifest := func() *plugin.Manifest {
+		m := validManifest()
+		m.AccountID = "acc1"
+		m.Packages = m.Packages[:1]
+		m.Packages[0].Checksum = stored[m.Packages[0].Name].Digests[string(m.Packages[0].Algorithm)]
+		return m
+	}
+
+	if err := plugin.Publish(ctx, newManifest()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := plugin.Unpublish(ctx, "lint@1.0.0", "acc1", false); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if m, err := plugin.Get(ctx, "lint", "1.0.0"); err != nil || !m.Yanked {
+		t.Errorf("expected unpublished version to remain downloadable by exact version, got %+v (%v)", m, err)
+	}
+
+	err = plugin.Publish(ctx, newManifest())
+	if _, ok := errors.Cause(err).(*plugin.VersionExistsError); !ok {
+		t.Errorf("expected *VersionExistsError, got %T: %v", err, err)
+	}
+}
+
+func TestServicePublish(t *testing.T) {
+	plugin.Storage = files.NewMemory()
+	plugin.Repo = plugintest.NewMemoryRepository()
+
+	prefix, err := files.Prefix("acc1", "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	stored := uploadPackages(t, plugin.Storage, prefix, map[string]string{"lint-linux-x64.tar.gz": "linux x64 bits"})
+	req := &api.PublishRequest{Plugin: &api.PluginManifest{
+		Name:     "lint",
+		Version:  "1.0.0",
+		Homepage: "https://github.com/lift-plugins/lint",
+		Author:   &api.Author{Name: "Jane Doe", Email: "jane@example.com"},
+		Packages: []*api.Package{{
+			Name:      "lint-linux-x64.tar.gz",
+			Os:        string("linux"),
+			Arch:      string("x64"),
+			Algorithm: string("sha256"),
+			Checksum:  stored["lint-linux-x64.tar.gz"].Digests[files.SHA256],
+		}},
+	}}
+
+	ctx := auth.NewContext(context.Background(), &auth.Account{ID: "acc1", Scopes: map[string]bool{"write": true}})
+	for _, code := range []codes.Code{codes.OK, codes.AlreadyExists} {
+		_, err := new(plugin.Service).Publish(ctx, req)
+		if c := status.Code(err); c != code {
+			t.Fatalf("expected code %s, got %s: %v", code, c, err)
+		}
+	}
+}
+
+func TestServiceVersions(t *testing.T) {
+	plugin.Repo = plugintest.NewMemoryRepository(
+		&plugin.Manifest{Name: "lint", Version: "1.10.0"},
+		&plugin.Manifest{Name: "lint", Version: "1.9.0", Yanked: true},
+	)
+
+	res, err := new(plugin.Service).Versions(context.Background(), &api.VersionsRequest{Name: "lint"})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(res.Versions) != 2 || res.Versions[0].Version != "1.9.0" || !res.Versions[0].Yanked || res.Versions[1].Version != "1.10.0" {
+		t.Errorf("expected yanked 1.9.0 followed by 1.10.0, got %+v", res.Versions)
+	}
+
+	_, err = new(plugin.Service).Versions(context.Background(), &api.VersionsRequest{Name: "missing"})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Errorf("expected code %s, got %s: %v", codes.NotFound, code, err)
+	}
+}
+
+func TestDeprecationHeaders(t *testing.T) {
+	d := &plugin.Deprecation{Message: "Ersetzt durch „vet“\nsiehe https://example.com", Replacement: "vet"}
+	md := plugin.DeprecationHeaders(d)
+
+	values := md[plugin.DeprecatedHeader]
+	if len(values) != 1 {
+		t.Fatalf("expected one %s header, got %v", plugin.DeprecatedHeader, values)
+	}
+
+	for _, c := range values[0] {
+		if c < 0x20 || c > 0x7e {
+			t.Fatalf("expected printable ASCII header value, got %q", values[0])
+		}
+	}
+
+	if message, err := url.PathUnescape(values[0]); err != nil || message != d.Message {
+		t.Errorf("expected header to decode to %q, got %q (%v)", d.Message, message, err)
+	}
+
+	if got := md[plugin.ReplacementHeader]; len(got) != 1 || got[0] != "vet" {
+		t.Errorf("expected replacement header vet, got %v", got)
+	}
+}
@@ -0,0 +1,1044 @@
+// +build sqlite
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/files"
+
+	// Registers the sqlite3 database/sql driver. Binaries have to be built with the
+	// sqlite tag, and the sqlite_fts5 tag enabling SQLite's full text search extension.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// RepoSQLite represents an implementation of the Repository interface for SQLite.
+type RepoSQLite struct {
+	db *sql.DB
+}
+
+// sqliteMigrations upgrade the database schema, one entry per schema version. The version
+// of a database is kept in SQLite's user_version pragma.
+var sqliteMigrations = []string{
+	`
+	CREATE TABLE plugins (
+		name TEXT PRIMARY KEY,
+		owner_id TEXT NOT NULL DEFAULT '',
+		pending_owner_id TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE maintainers (
+		plugin_name TEXT NOT NULL REFERENCES plugins(name) ON DELETE CASCADE,
+		account_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		PRIMARY KEY (plugin_name, account_id)
+	);
+
+	CREATE TABLE versions (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		id TEXT NOT NULL UNIQUE,
+		plugin_name TEXT NOT NULL REFERENCES plugins(name),
+		version TEXT NOT NULL,
+		account_id TEXT NOT NULL,
+		files_uri TEXT NOT NULL,
+		description TEXT NOT NULL,
+		author_name TEXT NOT NULL,
+		author_email TEXT NOT NULL,
+		license TEXT NOT NULL,
+		homepage TEXT NOT NULL,
+		published_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		downloads INTEGER NOT NULL DEFAULT 0,
+		yanked INTEGER NOT NULL DEFAULT 0,
+		yank_reason TEXT NOT NULL DEFAULT '',
+		deprecation_message TEXT NOT NULL DEFAULT '',
+		deprecation_replacement TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE INDEX versions_plugin_name ON versions(plugin_name);
+
+	CREATE TABLE packages (
+		version_id TEXT NOT NULL REFERENCES versions(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		os TEXT NOT NULL,
+		arch TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		algorithm TEXT NOT NULL,
+		PRIMARY KEY (version_id, position)
+	);
+
+	CREATE INDEX packages_platform ON packages(os, arch);
+
+	CREATE VIRTUAL TABLE versions_fts USING fts5(
+		plugin_name, description, author_name,
+		content='versions', content_rowid='seq', tokenize='porter unicode61'
+	);
+
+	CREATE TRIGGER versions_fts_insert AFTER INSERT ON versions BEGIN
+		INSERT INTO versions_fts(rowid, plugin_name, description, author_name)
+		VALUES (new.seq, new.plugin_name, new.description, new.author_name);
+	END;
+
+	CREATE TRIGGER versions_fts_delete AFTER DELETE ON versions BEGIN
+		INSERT INTO versions_fts(versions_fts, rowid, plugin_name, description, author_name)
+		VALUES ('delete', old.seq, old.plugin_name, old.description, old.author_name);
+	END;
+
+	CREATE TRIGGER versions_fts_update AFTER UPDATE OF plugin_name, description, author_name ON versions BEGIN
+		INSERT INTO versions_fts(versions_fts, rowid, plugin_name, description, author_name)
+		VALUES ('delete', old.seq, old.plugin_name, old.description, old.author_name);
+		INSERT INTO versions_fts(rowid, plugin_name, description, author_name)
+		VALUES (new.seq, new.plugin_name, new.description, new.author_name);
+	END;
+	`,
+	`
+	CREATE TABLE sessions (
+		id TEXT PRIMARY KEY,
+		account_id TEXT NOT NULL,
+		plugin_name TEXT NOT NULL,
+		version TEXT NOT NULL,
+		files TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);
+	`,
+	// Plugin names are ASCII, so lower() is enough to compare them regardless of case.
+	`
+	CREATE INDEX plugins_name_key ON plugins(lower(name));
+	`,
+	`
+	ALTER TABLE plugins ADD COLUMN deprecation_message TEXT NOT NULL DEFAULT '';
+	ALTER TABLE plugins ADD COLUMN deprecation_replacement TEXT NOT NULL DEFAULT '';
+	`,
+}
+
+// NewSQLiteRepository opens, or creates, the SQLite database at the given path and
+// upgrades its schema to the latest version.
+func NewSQLiteRepository(path string) (Repository, error) {
+	if path != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, errors.Wrapf(err, "failed creating directory for %q", path)
+		}
+	}
+
+	// Foreign keys are not enforced by SQLite unless explicitly enabled.
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed opening SQLite database at %q", path)
+	}
+
+	// SQLite only supports one writer at a time, and in-memory databases only live as long
+	// as their connection.
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &RepoSQLite{db: db}, nil
+}
+
+// migrateSQLite applies pending schema migrations.
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return errors.Wrap(err, "failed reading database schema version")
+	}
+
+	if version > len(sqliteMigrations) {
+		return errors.Errorf("database schema version %d is newer than the supported version %d", version, len(sqliteMigrations))
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "failed starting migration")
+		}
+
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "failed migrating database schema to version %d", version+1)
+		}
+
+		// Pragmas do not support query parameters.
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "failed updating database schema version")
+		}
+
+		if err := tx.Commit(); err != nil {
+			return errors.Wrapf(err, "failed migrating database schema to version %d", version+1)
+		}
+	}
+
+	return nil
+}
+
+// withTx runs fn within a transaction, which is committed if fn succeeds and rolled back otherwise.
+func (r *RepoSQLite) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed starting transaction")
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return errors.Wrap(tx.Commit(), "failed committing transaction")
+}
+
+// versionColumnNames are the columns manifests are read from, in the order scanManifest expects.
+var versionColumnNames = []string{
+	"id", "plugin_name", "version", "account_id", "files_uri", "description",
+	"author_name", "author_email", "license", "homepage", "published_at", "updated_at", "downloads",
+	"yanked", "yank_reason", "deprecation_message", "deprecation_replacement",
+}
+
+// versionColumns lists versionColumnNames for use in queries.
+var versionColumns = strings.Join(versionColumnNames, ", ")
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanManifest reads a manifest, without its packages, from a row of versionColumns
+// followed by the given extra columns.
+func scanManifest(row scanner, extra ...interface{}) (*Manifest, error) {
+	m := new(Manifest)
+	var publishedAt, updatedAt int64
+	var deprecationMessage, deprecationReplacement string
+
+	dest := []interface{}{
+		&m.ID, &m.Name, &m.Version, &m.AccountID, &m.FilesURI, &m.Description,
+		&m.Author.Name, &m.Author.Email, &m.License, &m.Homepage, &publishedAt, &updatedAt, &m.Downloads,
+		&m.Yanked, &m.YankReason, &deprecationMessage, &deprecationReplacement,
+	}
+
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	m.PublishedAt = fromUnixNano(publishedAt)
+	m.UpdatedAt = fromUnixNano(updatedAt)
+
+	if deprecationMessage != "" {
+		m.Deprecation = &Deprecation{Message: deprecationMessage, Replacement: deprecationReplacement}
+	}
+
+	return m, nil
+}
+
+// unixNano converts a time into the number of nanoseconds since the Unix epoch it is stored
+// as. The zero time, which is out of the range of int64 nanoseconds, is stored as 0.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano converts a stored time back, see unixNano.
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+// loadPackages fills in the packages of the given manifests.
+func (r *RepoSQLite) loadPackages(ctx context.Context, manifests []*Manifest) error {
+	if len(manifests) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*Manifest, len(manifests))
+	placeholders := make([]string, 0, len(manifests))
+	args := make([]interface{}, 0, len(manifests))
+	for _, m := range manifests {
+		m.Packages = make([]*Package, 0)
+		byID[m.ID] = m
+		placeholders = append(placeholders, "?")
+		args = append(args, m.ID)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT version_id, name, os, arch, checksum, algorithm
+		FROM packages WHERE version_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY version_id, position`, args...)
+	if err != nil {
+		return errors.Wrap(err, "failed querying packages")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		p := new(Package)
+		if err := rows.Scan(&id, &p.Name, &p.OS, &p.Arch, &p.Checksum, &p.Algorithm); err != nil {
+			return errors.Wrap(err, "failed reading package")
+		}
+
+		if m, ok := byID[id]; ok {
+			m.Packages = append(m.Packages, p)
+		}
+	}
+
+	return errors.Wrap(rows.Err(), "failed reading packages")
+}
+
+// queryManifests runs a query returning versionColumns and loads the packages of every manifest found.
+func (r *RepoSQLite) queryManifests(ctx context.Context, query string, args ...interface{}) ([]*Manifest, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed querying plugin versions")
+	}
+
+	manifests := make([]*Manifest, 0)
+	for rows.Next() {
+		m, err := scanManifest(rows)
+		if err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "failed reading plugin version")
+		}
+		manifests = append(manifests, m)
+	}
+
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading plugin versions")
+	}
+
+	if err := r.loadPackages(ctx, manifests); err != nil {
+		return nil, err
+	}
+
+	return manifests, nil
+}
+
+// sortColumn is a column search results are sorted by.
+type sortColumn struct {
+	name string
+	desc bool
+}
+
+// sqliteSortColumns maps sort orders to the columns SQLite sorts by. Document IDs always
+// break ties, so that results have a stable order to paginate over.
+var sqliteSortColumns = map[SortOrder][]sortColumn{
+	SortRelevance: {{"score", false}, {"id", false}},
+	SortNewest:    {{"published_at", true}, {"id", false}},
+	SortName:      {{"plugin_name", false}, {"id", false}},
+	SortDownloads: {{"downloads", true}, {"score", false}, {"id", false}},
+	SortUpdated:   {{"updated_at", true}, {"id", false}},
+}
+
+// sqliteFacetQueries computes facets over the matches of a search.
+var sqliteFacetQueries = map[string]string{
+	osFacet:      `SELECT p.os, COUNT(DISTINCT m.id) FROM matches m JOIN packages p ON p.version_id = m.id GROUP BY p.os`,
+	archFacet:    `SELECT p.arch, COUNT(DISTINCT m.id) FROM matches m JOIN packages p ON p.version_id = m.id GROUP BY p.arch`,
+	licenseFacet: `SELECT license, COUNT(*) FROM matches WHERE license <> '' GROUP BY license`,
+	authorFacet:  `SELECT author_name, COUNT(*) FROM matches WHERE author_name <> '' GROUP BY author_name`,
+}
+
+// Search finds plugin manifests using SQLite full text search.
+func (r *RepoSQLite) Search(ctx context.Context, q *SearchQuery) (*SearchResult, error) {
+	start := time.Now()
+	matches, args := sqliteMatches(q)
+	with := "WITH matches AS (" + matches + ") "
+
+	res := new(SearchResult)
+	if err := r.db.QueryRowContext(ctx, with+"SELECT COUNT(*) FROM matches", args...).Scan(&res.Total); err != nil {
+		return nil, errors.Wrapf(err, "failed searching %q", q.Text)
+	}
+
+	columns := sqliteSortColumns[q.Sort]
+	order := make([]string, 0, len(columns))
+	for _, c := range columns {
+		if c.desc {
+			order = append(order, c.name+" DESC")
+		} else {
+			order = append(order, c.name)
+		}
+	}
+
+	page := with + "SELECT " + versionColumns + ", score FROM matches"
+	pageArgs := append([]interface{}{}, args...)
+	offset := 0
+
+	if q.PageToken != "" {
+		after, err := decodeCursor(q.PageToken, len(columns))
+		if err != nil {
+			return nil, err
+		}
+
+		condition, conditionArgs := keysetCondition(columns, after)
+		page += " WHERE " + condition
+		pageArgs = append(pageArgs, conditionArgs...)
+	} else {
+		offset = (q.PageNumber - 1) * q.ResultsPerPage
+	}
+
+	page += " ORDER BY " + strings.Join(order, ", ") + " LIMIT ? OFFSET ?"
+	pageArgs = append(pageArgs, q.ResultsPerPage, offset)
+
+	rows, err := r.db.QueryContext(ctx, page, pageArgs...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed searching %q", q.Text)
+	}
+
+	var lastScore float64
+	res.Manifests = make([]*Manifest, 0, q.ResultsPerPage)
+	for rows.Next() {
+		m, err := scanManifest(rows, &lastScore)
+		if err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "failed reading search result")
+		}
+		res.Manifests = append(res.Manifests, m)
+	}
+
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed searching %q", q.Text)
+	}
+
+	if err := r.loadPackages(ctx, res.Manifests); err != nil {
+		return nil, err
+	}
+
+	// A full page means there may be more results. When paginating with tokens the offset
+	// of the page is unknown, so the total cannot tell whether this is the last page.
+	more := len(res.Manifests) == q.ResultsPerPage
+	if q.PageToken == "" {
+		more = offset+len(res.Manifests) < res.Total
+	}
+
+	if more && len(res.Manifests) > 0 {
+		last := res.Manifests[len(res.Manifests)-1]
+		values := make([]interface{}, 0, len(columns))
+		for _, c := range columns {
+			values = append(values, sortValue(last, lastScore, c.name))
+		}
+
+		token, err := encodeCursor(values)
+		if err != nil {
+			return nil, err
+		}
+		res.NextPageToken = token
+	}
+
+	for name, query := range sqliteFacetQueries {
+		facet, err := r.facet(ctx, name, with+query+" ORDER BY 2 DESC, 1 LIMIT ?", append(args, maxFacetTerms)...)
+		if err != nil {
+			return nil, err
+		}
+		res.Facets = append(res.Facets, facet)
+	}
+
+	sort.Slice(res.Facets, func(i, j int) bool {
+		return res.Facets[i].Name < res.Facets[j].Name
+	})
+
+	res.Took = time.Since(start)
+	return res, nil
+}
+
+// facet runs a query returning terms along with their number of matches.
+func (r *RepoSQLite) facet(ctx context.Context, name, query string, args ...interface{}) (*Facet, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed computing %s facet", name)
+	}
+	defer rows.Close()
+
+	facet := &Facet{Name: name, Terms: make([]FacetTerm, 0)}
+	for rows.Next() {
+		var t FacetTerm
+		if err := rows.Scan(&t.Term, &t.Count); err != nil {
+			return nil, errors.Wrapf(err, "failed reading %s facet", name)
+		}
+		facet.Terms = append(facet.Terms, t)
+	}
+
+	return facet, errors.Wrapf(rows.Err(), "failed reading %s facet", name)
+}
+
+// sqliteMatches builds the query selecting all plugin versions matching a search, along
+// with their relevance score. Lower scores are better matches.
+func sqliteMatches(q *SearchQuery) (string, []interface{}) {
+	from := "versions v"
+	score := "0.0"
+	conditions := []string{"v.yanked = 0"}
+	args := make([]interface{}, 0)
+
+	if text := ftsQuery(q.Text); text != "" {
+		// Name matches weigh more than description or author matches.
+		from = "versions_fts JOIN versions v ON v.seq = versions_fts.rowid"
+		score = "bm25(versions_fts, 5.0, 1.0, 1.0)"
+		conditions = append(conditions, "versions_fts MATCH ?")
+		args = append(args, text)
+	}
+
+	// The operating system and architecture have to match the same package.
+	if q.OS != "" || q.Arch != "" {
+		platform := make([]string, 0, 2)
+		if q.OS != "" {
+			platform = append(platform, "p.os = ?")
+			args = append(args, string(q.OS))
+		}
+
+		if q.Arch != "" {
+			platform = append(platform, "p.arch = ?")
+			args = append(args, string(q.Arch))
+		}
+
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM packages p WHERE p.version_id = v.id AND "+
+			strings.Join(platform, " AND ")+")")
+	}
+
+	if q.License != "" {
+		conditions = append(conditions, "v.license = ?")
+		args = append(args, q.License)
+	}
+
+	if q.Author != "" {
+		conditions = append(conditions, "(v.author_name = ? OR v.author_email = ? COLLATE NOCASE)")
+		args = append(args, q.Author, q.Author)
+	}
+
+	if !q.PublishedAfter.IsZero() {
+		conditions = append(conditions, "v.published_at > ?")
+		args = append(args, unixNano(q.PublishedAfter))
+	}
+
+	columns := make([]string, 0, len(versionColumnNames))
+	for _, c := range versionColumnNames {
+		columns = append(columns, "v."+c)
+	}
+
+	return fmt.Sprintf("SELECT %s, %s AS score FROM %s WHERE %s", strings.Join(columns, ", "), score, from, strings.Join(conditions, " AND ")), args
+}
+
+// ftsQuery turns user input into an FTS5 query matching all the words typed. Words are
+// quoted, so that input is never interpreted as FTS5 query syntax.
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, w := range words {
+		words[i] = `"` + strings.Replace(w, `"`, `""`, -1) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// keysetCondition builds a condition selecting the rows sorted after the given values.
+func keysetCondition(columns []sortColumn, values []interface{}) (string, []interface{}) {
+	disjuncts := make([]string, 0, len(columns))
+	args := make([]interface{}, 0)
+
+	for i, c := range columns {
+		conjuncts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			conjuncts = append(conjuncts, columns[j].name+" = ?")
+			args = append(args, values[j])
+		}
+
+		op := " > ?"
+		if c.desc {
+			op = " < ?"
+		}
+		conjuncts = append(conjuncts, c.name+op)
+		args = append(args, values[i])
+
+		disjuncts = append(disjuncts, "("+strings.Join(conjuncts, " AND ")+")")
+	}
+
+	return "(" + strings.Join(disjuncts, " OR ") + ")", args
+}
+
+// sortValue returns the value of a sort column for a search result.
+func sortValue(m *Manifest, score float64, column string) interface{} {
+	switch column {
+	case "score":
+		return score
+	case "published_at":
+		return unixNano(m.PublishedAt)
+	case "updated_at":
+		return unixNano(m.UpdatedAt)
+	case "plugin_name":
+		return m.Name
+	case "downloads":
+		return m.Downloads
+	default:
+		return m.ID
+	}
+}
+
+// encodeCursor encodes the sort values of the last result of a page into an opaque token.
+func encodeCursor(values []interface{}) (string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", errors.Wrap(err, "failed encoding page token")
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor decodes the sort values a page token was created from.
+func decodeCursor(token string, size int) ([]interface{}, error) {
+	var values []interface{}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		// Timestamps in nanoseconds do not fit in a float64.
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	}
+
+	if err != nil || len(values) != size {
+		verr := new(ValidationError)
+		verr.Add("page_token", "invalid page token")
+		return nil, verr
+	}
+
+	for i, v := range values {
+		n, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+
+		if integer, err := n.Int64(); err == nil {
+			values[i] = integer
+		} else if float, err := n.Float64(); err == nil {
+			values[i] = float
+		}
+	}
+
+	return values, nil
+}
+
+// Suggest finds plugin names starting with, or within a couple of typos of, the given text.
+func (r *RepoSQLite) Suggest(ctx context.Context, text string, limit int) (*Suggestions, error) {
+	text = strings.ToLower(text)
+	res := &Suggestions{Completions: make([]string, 0), Corrections: make([]string, 0)}
+	if text == "" {
+		return res, nil
+	}
+
+	// Both completions and corrections are looked up by prefix, on the index of lower case
+	// plugin names. No UTF-8 string contains 0xff, so it bounds the names with a prefix.
+	completions, err := r.names(ctx, suggestQuery+" ORDER BY lower(p.name), p.name LIMIT ?", text, text+"\xff", limit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed completing %q", text)
+	}
+	res.Completions = completions
+
+	names, err := r.names(ctx, suggestQuery, text[:1], text[:1]+"\xff")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed correcting %q", text)
+	}
+
+	distances := make(map[string]int)
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if lower == text || strings.HasPrefix(lower, text) {
+			continue
+		}
+
+		if d := editDistance(text, lower); d <= 2 {
+			distances[name] = d
+			res.Corrections = append(res.Corrections, name)
+		}
+	}
+
+	sort.Slice(res.Corrections, func(i, j int) bool {
+		a, b := res.Corrections[i], res.Corrections[j]
+		if distances[a] != distances[b] {
+			return distances[a] < distances[b]
+		}
+		return a < b
+	})
+
+	if len(res.Corrections) > limit {
+		res.Corrections = res.Corrections[:limit]
+	}
+
+	return res, nil
+}
+
+// suggestQuery finds the names of plugins with versions that were not yanked, whose lower
+// case name is within a range. Plugin names are ASCII, so SQLite's lower() is enough.
+const suggestQuery = `SELECT p.name FROM plugins p
+	WHERE lower(p.name) >= ? AND lower(p.name) < ?
+	AND EXISTS (SELECT 1 FROM versions v WHERE v.plugin_name = p.name AND v.yanked = 0)`
+
+// names runs a query returning plugin names.
+func (r *RepoSQLite) names(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// Versions finds all the versions of a plugin stored in SQLite.
+func (r *RepoSQLite) Versions(ctx context.Context, name string) ([]*Manifest, error) {
+	manifests, err := r.queryManifests(ctx, "SELECT "+versionColumns+" FROM versions WHERE plugin_name = ? ORDER BY seq", name)
+	return manifests, errors.Wrapf(err, "failed getting versions of %q", name)
+}
+
+// Get finds a specific plugin version in SQLite.
+func (r *RepoSQLite) Get(ctx context.Context, name, version string) (*Manifest, error) {
+	id := manifestID(name, version)
+	manifests, err := r.queryManifests(ctx, "SELECT "+versionColumns+" FROM versions WHERE id = ?", id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed getting %q", id)
+	}
+
+	if len(manifests) == 0 {
+		return nil, &NotFoundError{Name: name, Version: version}
+	}
+
+	return manifests[0], nil
+}
+
+// Names lists the names of all plugins stored in SQLite.
+func (r *RepoSQLite) Names(ctx context.Context) ([]string, error) {
+	names, err := r.names(ctx, "SELECT DISTINCT plugin_name FROM versions ORDER BY plugin_name")
+	return names, errors.Wrap(err, "failed listing plugin names")
+}
+
+// Save stores a new plugin version in SQLite.
+func (r *RepoSQLite) Save(ctx context.Context, p *Manifest) error {
+	if p == nil {
+		return errors.New("manifest is required")
+	}
+
+	if p.ID == "" {
+		return errors.New("ID is required")
+	}
+
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		var exists int
+		err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM versions WHERE id = ?", p.ID).Scan(&exists)
+		if err != nil {
+			return errors.Wrapf(err, "failed looking up %q", p.ID)
+		}
+
+		if exists > 0 {
+			return &VersionExistsError{Name: p.Name, Version: p.Version}
+		}
+
+		var taken string
+		err = tx.QueryRowContext(ctx, "SELECT name FROM plugins WHERE lower(name) = lower(?) AND name != ? LIMIT 1",
+			p.Name, p.Name).Scan(&taken)
+		switch {
+		case err == nil:
+			return &NameTakenError{Name: p.Name, Taken: taken}
+		case err != sql.ErrNoRows:
+			return errors.Wrapf(err, "failed looking up plugin name %q", p.Name)
+		}
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO plugins (name) VALUES (?) ON CONFLICT (name) DO NOTHING", p.Name); err != nil {
+			return errors.Wrapf(err, "failed storing plugin %q", p.Name)
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO versions (`+versionColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, versionValues(p)...)
+		if err != nil {
+			return errors.Wrapf(err, "failed storing %q", p.ID)
+		}
+
+		return insertPackages(ctx, tx, p)
+	})
+}
+
+// Update replaces the metadata of existing plugin versions in SQLite, in a single transaction.
+func (r *RepoSQLite) Update(ctx context.Context, manifests ...*Manifest) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		for _, p := range manifests {
+			if err := updateVersion(ctx, tx, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// updateVersion replaces the metadata of an existing plugin version.
+func updateVersion(ctx context.Context, tx *sql.Tx, p *Manifest) error {
+	if p == nil {
+		return errors.New("manifest is required")
+	}
+
+	if p.ID == "" {
+		return errors.New("ID is required")
+	}
+
+	// The ID and plugin name identify the version and cannot change, and downloads are only
+	// counted by AddDownloads.
+	values := versionValues(p)
+	args := make([]interface{}, 0, len(values))
+	args = append(args, values[2:12]...)
+	args = append(args, values[13:]...)
+	args = append(args, p.ID)
+
+	res, err := tx.ExecContext(ctx, `UPDATE versions SET version = ?, account_id = ?, files_uri = ?,
+		description = ?, author_name = ?, author_email = ?, license = ?, homepage = ?,
+		published_at = ?, updated_at = ?, yanked = ?, yank_reason = ?,
+		deprecation_message = ?, deprecation_replacement = ?
+		WHERE id = ?`, args...)
+	if err != nil {
+		return errors.Wrapf(err, "failed updating %q", p.ID)
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return &NotFoundError{Name: p.Name, Version: p.Version}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM packages WHERE version_id = ?", p.ID); err != nil {
+		return errors.Wrapf(err, "failed updating packages of %q", p.ID)
+	}
+
+	return insertPackages(ctx, tx, p)
+}
+
+// AddDownloads adds to the download counts of plugin versions in SQLite, in a single transaction.
+func (r *RepoSQLite) AddDownloads(ctx context.Context, counts map[string]int64) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		for id, n := range counts {
+			if _, err := tx.ExecContext(ctx, "UPDATE versions SET downloads = downloads + ? WHERE id = ?", n, id); err != nil {
+				return errors.Wrapf(err, "failed adding downloads of %q", id)
+			}
+		}
+		return nil
+	})
+}
+
+// versionValues returns the values of versionColumns for a manifest.
+func versionValues(p *Manifest) []interface{} {
+	var deprecationMessage, deprecationReplacement string
+	if p.Deprecation != nil {
+		deprecationMessage = p.Deprecation.Message
+		deprecationReplacement = p.Deprecation.Replacement
+	}
+
+	return []interface{}{
+		p.ID, p.Name, p.Version, p.AccountID, p.FilesURI, p.Description,
+		p.Author.Name, p.Author.Email, p.License, p.Homepage, unixNano(p.PublishedAt), unixNano(p.UpdatedAt), p.Downloads,
+		p.Yanked, p.YankReason, deprecationMessage, deprecationReplacement,
+	}
+}
+
+// insertPackages stores the packages of a plugin version.
+func insertPackages(ctx context.Context, tx *sql.Tx, p *Manifest) error {
+	for i, pkg := range p.Packages {
+		_, err := tx.ExecContext(ctx, `INSERT INTO packages (version_id, position, name, os, arch, checksum, algorithm)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, p.ID, i, pkg.Name, string(pkg.OS), string(pkg.Arch), pkg.Checksum, string(pkg.Algorithm))
+		if err != nil {
+			return errors.Wrapf(err, "failed storing package %q of %q", pkg.Name, p.ID)
+		}
+	}
+	return nil
+}
+
+// Delete removes a plugin version from SQLite.
+func (r *RepoSQLite) Delete(ctx context.Context, id string) error {
+	if id == "" {
+		return errors.New("document ID is required")
+	}
+
+	res, err := r.db.ExecContext(ctx, "DELETE FROM versions WHERE id = ?", id)
+	if err != nil {
+		return errors.Wrapf(err, "failed deleting %q", id)
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return &NotFoundError{Name: id}
+	}
+
+	return nil
+}
+
+// Ownership gets the ownership record of a plugin from SQLite.
+func (r *RepoSQLite) Ownership(ctx context.Context, name string) (*Ownership, error) {
+	o := &Ownership{Name: name}
+	var deprecationMessage, deprecationReplacement string
+	err := r.db.QueryRowContext(ctx, `SELECT owner_id, pending_owner_id, deprecation_message, deprecation_replacement
+		FROM plugins WHERE name = ?`, name).
+		Scan(&o.OwnerID, &o.PendingOwnerID, &deprecationMessage, &deprecationReplacement)
+	if err == sql.ErrNoRows || err == nil && o.OwnerID == "" {
+		return nil, &NotFoundError{Name: name}
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed getting ownership of %q", name)
+	}
+
+	if deprecationMessage != "" {
+		o.Deprecation = &Deprecation{Message: deprecationMessage, Replacement: deprecationReplacement}
+	}
+
+	o.Maintainers, err = r.names(ctx, "SELECT account_id FROM maintainers WHERE plugin_name = ? ORDER BY position", name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed getting maintainers of %q", name)
+	}
+
+	return o, nil
+}
+
+// SaveOwnership stores the ownership record of a plugin in SQLite.
+func (r *RepoSQLite) SaveOwnership(ctx context.Context, o *Ownership) error {
+	if o == nil || o.Name == "" {
+		return errors.New("ownership record with plugin name is required")
+	}
+
+	var deprecationMessage, deprecationReplacement string
+	if o.Deprecation != nil {
+		deprecationMessage = o.Deprecation.Message
+		deprecationReplacement = o.Deprecation.Replacement
+	}
+
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO plugins (name, owner_id, pending_owner_id, deprecation_message, deprecation_replacement)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET owner_id = excluded.owner_id, pending_owner_id = excluded.pending_owner_id,
+			deprecation_message = excluded.deprecation_message, deprecation_replacement = excluded.deprecation_replacement`,
+			o.Name, o.OwnerID, o.PendingOwnerID, deprecationMessage, deprecationReplacement)
+		if err != nil {
+			return errors.Wrapf(err, "failed storing ownership of %q", o.Name)
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM maintainers WHERE plugin_name = ?", o.Name); err != nil {
+			return errors.Wrapf(err, "failed storing maintainers of %q", o.Name)
+		}
+
+		for i, accountID := range o.Maintainers {
+			_, err := tx.ExecContext(ctx, "INSERT INTO maintainers (plugin_name, account_id, position) VALUES (?, ?, ?)",
+				o.Name, accountID, i)
+			if err != nil {
+				return errors.Wrapf(err, "failed storing maintainer %q of %q", accountID, o.Name)
+			}
+		}
+
+		return nil
+	})
+}
+
+// sessionColumns are the columns of the sessions table, in the order scanSession reads them.
+const sessionColumns = "id, account_id, plugin_name, version, files, created_at, expires_at"
+
+// scanSession reads a publish session from a row.
+func scanSession(row scanner) (*Session, error) {
+	var (
+		s                    Session
+		objects              string
+		createdAt, expiresAt int64
+	)
+
+	if err := row.Scan(&s.ID, &s.AccountID, &s.Name, &s.Version, &objects, &createdAt, &expiresAt); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(objects), &s.Files); err != nil {
+		return nil, errors.Wrapf(err, "failed decoding files of publish session %q", s.ID)
+	}
+
+	s.CreatedAt = fromUnixNano(createdAt)
+	s.ExpiresAt = fromUnixNano(expiresAt)
+	return &s, nil
+}
+
+// Session returns a publish session stored in SQLite.
+func (r *RepoSQLite) Session(ctx context.Context, id string) (*Session, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE id = ?", id)
+	s, err := scanSession(row)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return nil, &SessionNotFoundError{ID: id}
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed getting publish session %q", id)
+	}
+
+	return s, nil
+}
+
+// Sessions returns all publish sessions stored in SQLite.
+func (r *RepoSQLite) Sessions(ctx context.Context) ([]*Session, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+sessionColumns+" FROM sessions ORDER BY id")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed listing publish sessions")
+	}
+	defer rows.Close()
+
+	sessions := make([]*Session, 0)
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed reading publish session")
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, errors.Wrap(rows.Err(), "failed listing publish sessions")
+}
+
+// SaveSession stores a publish session in SQLite, replacing any previous version of it.
+func (r *RepoSQLite) SaveSession(ctx context.Context, s *Session) error {
+	if s == nil || s.ID == "" {
+		return errors.New("publish session with ID is required")
+	}
+
+	objects := s.Files
+	if objects == nil {
+		objects = []*files.Object{}
+	}
+
+	encoded, err := json.Marshal(objects)
+	if err != nil {
+		return errors.Wrapf(err, "failed encoding files of publish session %q", s.ID)
+	}
+
+	_, err = r.db.ExecContext(ctx, "INSERT OR REPLACE INTO sessions ("+sessionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		s.ID, s.AccountID, s.Name, s.Version, string(encoded), unixNano(s.CreatedAt), unixNano(s.ExpiresAt))
+	return errors.Wrapf(err, "failed storing publish session %q", s.ID)
+}
+
+// DeleteSession removes a publish session from SQLite.
+func (r *RepoSQLite) DeleteSession(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = ?", id)
+	if err != nil {
+		return errors.Wrapf(err, "failed deleting publish session %q", id)
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return &SessionNotFoundError{ID: id}
+	}
+
+	return nil
+}
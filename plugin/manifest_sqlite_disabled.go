@@ -0,0 +1,11 @@
+// +build !sqlite
+
+package plugin
+
+import "github.com/pkg/errors"
+
+// NewSQLiteRepository fails, since this binary was built without SQLite support. SQLite
+// requires cgo, so it is left out unless built with the sqlite tag.
+func NewSQLiteRepository(path string) (Repository, error) {
+	return nil, errors.New(`SQLite support was not built into this binary, rebuild it with -tags "sqlite sqlite_fts5" or use the bleve repository driver`)
+}
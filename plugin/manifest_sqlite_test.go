@@ -0,0 +1,259 @@
+// +build sqlite
+
+package plugin
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newSQLiteRepo opens an in-memory SQLite repository. Tests are skipped if the driver was
+// built without the sqlite_fts5 tag.
+func newSQLiteRepo(t *testing.T) Repository {
+	repo, err := NewSQLiteRepository(":memory:")
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		t.Skip("SQLite was built without FTS5, use -tags sqlite_fts5")
+	}
+
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	return repo
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepo(t)
+
+	published := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	lint := &Manifest{
+		ID:          manifestID("lint", "1.0.0"),
+		Name:        "lint",
+		Version:     "1.0.0",
+		AccountID:   "alice",
+		Description: "Finds style mistakes in source code",
+		Author:      Author{Name: "Alice", Email: "alice@example.com"},
+		License:     "MIT",
+		PublishedAt: published,
+		UpdatedAt:   published,
+		Packages: []*Package{
+			{Name: "lint-linux-x64.tar.gz", OS: linux, Arch: x64, Checksum: "abc", Algorithm: "sha512"},
+			{Name: "lint-macOS-arm64.tar.gz", OS: macOS, Arch: arm64, Checksum: "def", Algorithm: "sha512"},
+		},
+	}
+
+	if err := repo.Save(ctx, lint); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	err := repo.Save(ctx, lint)
+	if _, ok := errors.Cause(err).(*VersionExistsError); !ok {
+		t.Errorf("expected *VersionExistsError saving twice, got %T: %v", err, err)
+	}
+
+	m, err := repo.Get(ctx, "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if !reflect.DeepEqual(m, lint) {
+		t.Errorf("expected %+v, got %+v", lint, m)
+	}
+
+	lint.Yanked = true
+	lint.Deprecation = &Deprecation{Message: "use vet"}
+	if err := repo.Update(ctx, lint); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if m, _ := repo.Get(ctx, "lint", "1.0.0"); !m.Yanked || m.Deprecation == nil {
+		t.Errorf("expected update to be stored, got %+v", m)
+	}
+
+	if _, err := repo.Get(ctx, "lint", "2.0.0"); !isNotFound(err) {
+		t.Errorf("expected *NotFoundError, got %T: %v", err, err)
+	}
+
+	if err := repo.Delete(ctx, lint.ID); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := repo.Delete(ctx, lint.ID); !isNotFound(err) {
+		t.Errorf("expected *NotFoundError deleting twice, got %T: %v", err, err)
+	}
+
+	if _, err := repo.Ownership(ctx, "vet"); !isNotFound(err) {
+		t.Errorf("expected *NotFoundError, got %T: %v", err, err)
+	}
+
+	o := &Ownership{Name: "vet", OwnerID: "bob", Maintainers: []string{"carol", "alice"}, PendingOwnerID: "dave"}
+	if err := repo.SaveOwnership(ctx, o); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if got, err := repo.Ownership(ctx, "vet"); err != nil || !reflect.DeepEqual(got, o) {
+		t.Errorf("expected %+v, got %+v (%v)", o, got, err)
+	}
+}
+
+func TestSQLiteSearch(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepo(t)
+
+	published := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	plugins := []struct {
+		name, description, license string
+		os                         OS
+		downloads                  int64
+		yanked                     bool
+	}{
+		{"lint", "Finds style mistakes", "MIT", linux, 5, false},
+		{"lint-extra", "More linting rules", "MIT", macOS, 50, false},
+		{"format", "Formats source code", "Apache-2.0", linux, 10, false},
+		{"vet", "Finds suspicious constructs", "MIT", linux, 1, true},
+	}
+
+	for i, p := range plugins {
+		m := &Manifest{
+			ID:          manifestID(p.name, "1.0.0"),
+			Name:        p.name,
+			Version:     "1.0.0",
+			Description: p.description,
+			License:     p.license,
+			PublishedAt: published.Add(time.Duration(i) * time.Hour),
+			Downloads:   p.downloads,
+			Yanked:      p.yanked,
+			Packages:    []*Package{{Name: p.name + ".tar.gz", OS: p.os, Arch: x64}},
+		}
+		m.UpdatedAt = m.PublishedAt
+		if err := repo.Save(ctx, m); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	}
+
+	tests := []struct {
+		desc  string
+		query SearchQuery
+		names []string
+		total int
+	}{
+		{"everything by name", SearchQuery{Sort: SortName}, []string{"format", "lint", "lint-extra"}, 3},
+		{"full text", SearchQuery{Text: "finds", Sort: SortName}, []string{"lint"}, 1},
+		{"os filter", SearchQuery{OS: linux, Sort: SortNewest}, []string{"format", "lint"}, 2},
+		{"license filter", SearchQuery{License: "MIT", Sort: SortDownloads}, []string{"lint-extra", "lint"}, 2},
+		{"published after", SearchQuery{PublishedAfter: published, Sort: SortName}, []string{"format", "lint-extra"}, 2},
+		{"second page", SearchQuery{Sort: SortName, PageNumber: 2, ResultsPerPage: 2}, []string{"lint-extra"}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			q := tt.query
+			if q.PageNumber == 0 {
+				q.PageNumber = 1
+			}
+			if q.ResultsPerPage == 0 {
+				q.ResultsPerPage = defaultResultsPerPage
+			}
+
+			res, err := repo.Search(ctx, &q)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			if got := manifestNames(res.Manifests); !reflect.DeepEqual(got, tt.names) {
+				t.Errorf("expected %v, got %v", tt.names, got)
+			}
+
+			if res.Total != tt.total {
+				t.Errorf("expected %d total results, got %d", tt.total, res.Total)
+			}
+		})
+	}
+
+	t.Run("page tokens", func(t *testing.T) {
+		q := &SearchQuery{Sort: SortDownloads, PageNumber: 1, ResultsPerPage: 2}
+		names := make([]string, 0)
+		for i := 0; i < 3; i++ {
+			res, err := repo.Search(ctx, q)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			names = append(names, manifestNames(res.Manifests)...)
+
+			if res.NextPageToken == "" {
+				break
+			}
+			q = &SearchQuery{Sort: SortDownloads, ResultsPerPage: 2, PageToken: res.NextPageToken}
+		}
+
+		expected := []string{"lint-extra", "format", "lint"}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("expected %v, got %v", expected, names)
+		}
+	})
+
+	t.Run("facets", func(t *testing.T) {
+		res, err := repo.Search(ctx, &SearchQuery{Sort: SortRelevance, PageNumber: 1, ResultsPerPage: 10})
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		for _, f := range res.Facets {
+			if f.Name != osFacet {
+				continue
+			}
+
+			expected := []FacetTerm{{Term: "linux", Count: 2}, {Term: "macOS", Count: 1}}
+			if !reflect.DeepEqual(f.Terms, expected) {
+				t.Errorf("expected %v, got %v", expected, f.Terms)
+			}
+			return
+		}
+		t.Errorf("expected %s facet", osFacet)
+	})
+
+	t.Run("invalid page token", func(t *testing.T) {
+		_, err := repo.Search(ctx, &SearchQuery{Sort: SortName, ResultsPerPage: 2, PageToken: "garbage"})
+		if _, ok := errors.Cause(err).(*ValidationError); !ok {
+			t.Errorf("expected *ValidationError, got %T: %v", err, err)
+		}
+	})
+
+	t.Run("suggestions", func(t *testing.T) {
+		s, err := repo.Suggest(ctx, "lin", defaultSuggestions)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if expected := []string{"lint", "lint-extra"}; !reflect.DeepEqual(s.Completions, expected) {
+			t.Errorf("expected completions %v, got %v", expected, s.Completions)
+		}
+
+		s, err = repo.Suggest(ctx, "fromat", defaultSuggestions)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if expected := []string{"format"}; !reflect.DeepEqual(s.Corrections, expected) {
+			t.Errorf("expected corrections %v, got %v", expected, s.Corrections)
+		}
+	})
+}
+
+func manifestNames(manifests []*Manifest) []string {
+	names := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+func isNotFound(err error) bool {
+	_, ok := errors.Cause(err).(*NotFoundError)
+	return ok
+}
@@ -0,0 +1,337 @@
+package plugin_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/files"
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+func TestGet(t *testing.T) {
+	plugin.Repo = plugintest.NewMemoryRepository(
+		&plugin.Manifest{Name: "lint", Version: "1.2.0"},
+		&plugin.Manifest{Name: "lint", Version: "1.10.0"},
+		&plugin.Manifest{Name: "lint", Version: "2.0.0-beta1"},
+		&plugin.Manifest{Name: "fmt", Version: "0.1.0-alpha"},
+	)
+
+	tests := []struct {
+		desc    string
+		name    string
+		version string
+		found   string
+	}{
+		{"latest stable version", "lint", "", "1.10.0"},
+		{"exact version", "lint", "1.2.0", "1.2.0"},
+		{"version is normalized", "lint", "1.2", "1.2.0"},
+		{"prerelease version", "lint", "2.0.0-beta1", "2.0.0-beta1"},
+		{"only prereleases published", "fmt", "", "0.1.0-alpha"},
+		{"missing version", "lint", "1.3.0", ""},
+		{"missing plugin", "vet", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			m, err := plugin.Get(context.Background(), tt.name, tt.version)
+			if tt.found == "" {
+				if _, ok := errors.Cause(err).(*plugin.NotFoundError); !ok {
+					t.Fatalf("expected *NotFoundError, got %T: %v", err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			if m.Version != tt.found {
+				t.Errorf("expected version %s, got %s", tt.found, m.Version)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	linuxPkg := &plugin.Package{Name: "linux.tar.gz", OS: "linux", Arch: "x64"}
+	darwinPkg := &plugin.Package{Name: "darwin.tar.gz", OS: "macOS", Arch: "x64"}
+
+	plugin.Repo = plugintest.NewMemoryRepository(
+		&plugin.Manifest{Name: "lint", Version: "1.1.0", Packages: []*plugin.Package{linuxPkg, darwinPkg}},
+		&plugin.Manifest{Name: "lint", Version: "1.2.0", Packages: []*plugin.Package{linuxPkg, darwinPkg}},
+		&plugin.Manifest{Name: "lint", Version: "1.10.0", Packages: []*plugin.Package{linuxPkg}},
+		&plugin.Manifest{Name: "lint", Version: "2.0.0", Packages: []*plugin.Package{linuxPkg, darwinPkg}},
+		&plugin.Manifest{Name: "lint", Version: "3.0.0-beta1", Packages: []*plugin.Package{linuxPkg, darwinPkg}},
+		&plugin.Manifest{Name: "other", Version: "9.0.0", Packages: []*plugin.Package{linuxPkg, darwinPkg}},
+	)
+
+	tests := []struct {
+		desc       string
+		name       string
+		constraint string
+		os         plugin.OS
+		arch       plugin.Arch
+		version    string
+		err        error
+	}{
+		{"no constraint picks latest stable", "lint", "", "linux", "x64", "2.0.0", nil},
+		{"pessimistic constraint", "lint", "~> 1.2", "linux", "x64", "1.10.0", nil},
+		{"range constraint", "lint", ">= 1.0, < 2", "linux", "x64", "1.10.0", nil},
+		{"falls back to a version built for the platform", "lint", "~> 1.2", "macOS", "x64", "1.2.0", nil},
+		{"exact version", "lint", "1.1.0", "macOS", "x64", "1.1.0", nil},
+		{"prerelease constraint", "lint", ">= 3.0.0-beta1", "linux", "x64", "3.0.0-beta1", nil},
+		{"no version matches", "lint", "> 4", "linux", "x64", "", &plugin.NoMatchError{}},
+		{"no package for platform", "lint", "", "windows", "x64", "", &plugin.NoMatchError{}},
+		{"unknown plugin", "missing", "", "linux", "x64", "", &plugin.NotFoundError{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			m, pkg, err := plugin.Resolve(context.Background(), tt.name, tt.constraint, tt.os, tt.arch)
+			if tt.err != nil {
+				if err == nil {
+					t.Fatalf("expected error %T, got version %s", tt.err, m.Version)
+				}
+
+				if reflect.TypeOf(errors.Cause(err)) != reflect.TypeOf(tt.err) {
+					t.Fatalf("expected error %T, got %T: %v", tt.err, err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			if m.Version != tt.version {
+				t.Errorf("expected version %s, got %s", tt.version, m.Version)
+			}
+
+			if pkg.OS != tt.os || pkg.Arch != tt.arch {
+				t.Errorf("expected package for %s/%s, got %s/%s", tt.os, tt.arch, pkg.OS, pkg.Arch)
+			}
+		})
+	}
+
+	if _, _, err := plugin.Resolve(context.Background(), "lint", "not a constraint", "linux", "x64"); err == nil {
+		t.Error("expected invalid constraint to fail")
+	}
+}
+
+// sha256Only is a storage provider that only reports SHA-256 digests.
+type sha256Only struct {
+	files.StorageProvider
+}
+
+func (s sha256Only) Stat(ctx context.Context, key string) (*files.Object, error) {
+	o, err := s.StorageProvider.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(o.Digests, files.SHA512)
+	return o, nil
+}
+
+func TestPublishVerifiesPackages(t *testing.T) {
+	ctx := context.Background()
+	storage := files.NewMemory()
+	plugin.Storage = storage
+
+	prefix, err := files.Prefix("acc1", "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	stored := uploadPackages(t, plugin.Storage, prefix, map[string]string{
+		"lint-linux-x64.tar.gz": "linux x64 bits",
+		"lint-macOS-x64.tar.gz": "macOS x64 bits",
+	})
+
+	// newManifest returns a manifest whose checksums match the stored packages.
+	newManifest := func() *plugin.Manifest {
+		m := validManifest()
+		m.AccountID = "acc1"
+		for _, pkg := range m.Packages {
+			pkg.Checksum = stored[pkg.Name].Digests[string(pkg.Algorithm)]
+		}
+		return m
+	}
+
+	tests := []struct {
+		desc    string
+		storage files.StorageProvider
+		change  func(m *plugin.Manifest)
+		field   string
+		err     string
+	}{
+		{"checksum mismatch", storage, func(m *plugin.Manifest) {
+			m.Packages[1].Checksum = strings.Repeat("0", 128)
+		}, "packages[1].checksum", `checksum mismatch for package "lint-macOS-x64.tar.gz"`},
+		{"checksum of another file", storage, func(m *plugin.Manifest) {
+			m.Packages[0].Checksum = stored["lint-macOS-x64.tar.gz"].Digests[files.SHA256]
+		}, "packages[0].checksum", `checksum mismatch for package "lint-linux-x64.tar.gz"`},
+		{"missing package", storage, func(m *plugin.Manifest) {
+			m.Packages[1].Name = "lint-windows-x64.tar.gz"
+			m.Packages[1].OS = "windows"
+		}, "packages[1].name", `package "lint-windows-x64.tar.gz" has not been uploaded`},
+		{"unsupported algorithm", storage, func(m *plugin.Manifest) {
+			m.Packages[0].Algorithm = "md5"
+		}, "packages[0].algorithm", `unsupported checksum algorithm "md5"`},
+		{"algorithm not computed by storage", sha256Only{storage}, func(m *plugin.Manifest) {
+		}, "packages[1].algorithm", `unsupported checksum algorithm "sha512" for package "lint-macOS-x64.tar.gz"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			plugin.Repo = plugintest.NewMemoryRepository()
+			plugin.Storage = tt.storage
+
+			m := newManifest()
+			tt.change(m)
+
+			err := plugin.Publish(ctx, m)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+
+			verr, ok := errors.Cause(err).(*plugin.ValidationError)
+			if !ok || len(verr.Fields) == 0 || verr.Fields[0].Field != tt.field {
+				t.Errorf("expected *ValidationError on %s, got %T: %v", tt.field, err, err)
+			}
+
+			if _, err := plugin.Repo.Get(ctx, "lint", "1.0.0"); err == nil {
+				t.Error("expected version to remain unpublished")
+			}
+		})
+	}
+
+	plugin.Repo = plugintest.NewMemoryRepository()
+	plugin.Storage = storage
+	if err := plugin.Publish(ctx, newManifest()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if published, err := plugin.Published(ctx, "lint", "1.0"); err != nil || !published {
+		t.Errorf("expected version to be published, got %t (%v)", published, err)
+	}
+
+	// Published versions are immutable.
+	err = plugin.Publish(ctx, newManifest())
+	if _, ok := errors.Cause(err).(*plugin.VersionExistsError); !ok {
+		t.Errorf("expected *VersionExistsError, got %T: %v", err, err)
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	tests := []struct {
+		desc     string
+		versions []string
+		sorted   []string
+	}{
+		{"numeric segments", []string{"1.10.0", "1.9.0", "1.2.0", "2.0.0"}, []string{"1.2.0", "1.9.0", "1.10.0", "2.0.0"}},
+		{"prereleases before releases", []string{"1.0.0", "1.0.0-rc.1", "1.0.0-beta.2", "0.9.0"}, []string{"0.9.0", "1.0.0-beta.2", "1.0.0-rc.1", "1.0.0"}},
+		{"prerelease identifiers", []string{"1.0.0-beta.11", "1.0.0-beta.2", "1.0.0-alpha"}, []string{"1.0.0-alpha", "1.0.0-beta.2", "1.0.0-beta.11"}},
+		{"metadata is ignored", []string{"1.2.0", "1.1.0+build.7", "1.0.0"}, []string{"1.0.0", "1.1.0+build.7", "1.2.0"}},
+		{"invalid versions first", []string{"1.0.0", "latest", "0.1.0"}, []string{"latest", "0.1.0", "1.0.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			manifests := make([]*plugin.Manifest, 0, len(tt.versions))
+			for _, v := range tt.versions {
+				manifests = append(manifests, &plugin.Manifest{Name: "lint", Version: v})
+			}
+
+			plugin.SortVersions(manifests)
+
+			sorted := make([]string, 0, len(manifests))
+			for _, m := range manifests {
+				sorted = append(sorted, m.Version)
+			}
+
+			if !reflect.DeepEqual(sorted, tt.sorted) {
+				t.Errorf("expected %v, got %v", tt.sorted, sorted)
+			}
+		})
+	}
+}
+
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+	plugin.Repo = plugintest.NewMemoryRepository(
+		&plugin.Manifest{Name: "lint", Version: "1.10.0"},
+		&plugin.Manifest{Name: "lint", Version: "1.9.0"},
+		&plugin.Manifest{Name: "lint", Version: "2.0.0-beta.1"},
+		&plugin.Manifest{Name: "other", Version: "1.0.0"},
+	)
+
+	manifests, err := plugin.Versions(ctx, "lint")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	versions := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		versions = append(versions, m.Version)
+	}
+
+	if expected := []string{"1.9.0", "1.10.0", "2.0.0-beta.1"}; !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected %v, got %v", expected, versions)
+	}
+
+	_, err = plugin.Versions(ctx, "missing")
+	if _, ok := errors.Cause(err).(*plugin.NotFoundError); !ok {
+		t.Errorf("expected *NotFoundError, got %T: %v", err, err)
+	}
+}
+
+// failingDownloads is a repository failing to add downloads.
+type failingDownloads struct {
+	*plugintest.MemoryRepository
+}
+
+func (r failingDownloads) AddDownloads(ctx context.Context, counts map[string]int64) error {
+	return errors.New("repository unavailable")
+}
+
+func TestFlushDownloads(t *testing.T) {
+	repo := plugintest.NewMemoryRepository(&plugin.Manifest{Name: "lint", Version: "1.0.0"})
+	m, err := repo.Get(context.Background(), "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	plugin.RecordDownload(m)
+	plugin.RecordDownload(m)
+
+	// Downloads are kept when the repository fails, and flushed once it recovers.
+	plugin.Repo = failingDownloads{repo}
+	if err := plugin.FlushDownloads(context.Background()); err == nil {
+		t.Fatal("expected flushing to fail")
+	}
+
+	plugin.RecordDownload(m)
+	plugin.Repo = repo
+	if err := plugin.FlushDownloads(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if m, _ := repo.Get(context.Background(), "lint", "1.0.0"); m == nil || m.Downloads != 3 {
+		t.Errorf("expected 3 downloads, got %+v", m)
+	}
+
+	// Flushed downloads are not added twice.
+	if err := plugin.FlushDownloads(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if m, _ := repo.Get(context.Background(), "lint", "1.0.0"); m == nil || m.Downloads != 3 {
+		t.Errorf("expected 3 downloads, got %+v", m)
+	}
+}
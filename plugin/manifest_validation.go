@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// archs is the list of supported CPU architectures.
+var archs = map[Arch]bool{
+	x86:   true,
+	x64:   true,
+	arm:   true,
+	arm64: true,
+}
+
+// oses is the list of supported operating systems.
+var oses = map[OS]bool{
+	windows: true,
+	macOS:   true,
+	freebsd: true,
+	linux:   true,
+}
+
+// checksumSizes maps supported hashing algorithms to the size, in bytes, of the checksums they produce.
+var checksumSizes = map[Algorithm]int{
+	sha256: 32,
+	sha512: 64,
+}
+
+// Validate checks every field of a manifest, returning a *ValidationError listing all
+// violations found.
+func Validate(p *Manifest) error {
+	verr := new(ValidationError)
+
+	validateName(verr, p.Name)
+
+	if p.Version == "" {
+		verr.Add("version", "plugin version is required")
+	} else if _, err := version.NewVersion(p.Version); err != nil {
+		verr.Add("version", fmt.Sprintf("%q is not a valid version number", p.Version))
+	}
+
+	if p.Author.Email != "" {
+		addr, err := mail.ParseAddress(p.Author.Email)
+		if err != nil || addr.Address != p.Author.Email {
+			verr.Add("author.email", fmt.Sprintf("%q is not a valid email address", p.Author.Email))
+		}
+	}
+
+	if p.Homepage != "" && !validURL(p.Homepage, "http", "https") {
+		verr.Add("homepage", fmt.Sprintf("%q is not a valid http or https URL", p.Homepage))
+	}
+
+	if p.FilesURI != "" && !validURL(p.FilesURI, "https") {
+		verr.Add("files_uri", fmt.Sprintf("%q is not a valid https URL", p.FilesURI))
+	}
+
+	if len(p.Packages) == 0 {
+		verr.Add("packages", "at least one package is required")
+	}
+
+	names := make(map[string]bool)
+	platforms := make(map[Platform]bool)
+	for i, pkg := range p.Packages {
+		field := fmt.Sprintf("packages[%d]", i)
+		if pkg == nil {
+			verr.Add(field, "package is required")
+			continue
+		}
+
+		validatePackage(verr, field, pkg)
+
+		if names[pkg.Name] {
+			verr.Add(field+".name", fmt.Sprintf("package %q is listed more than once", pkg.Name))
+		}
+		names[pkg.Name] = true
+
+		platform := Platform{OS: pkg.OS, Arch: pkg.Arch}
+		if platforms[platform] {
+			verr.Add(field, fmt.Sprintf("there is more than one package for %s/%s", pkg.OS, pkg.Arch))
+		}
+		platforms[platform] = true
+	}
+
+	return verr.ErrorOrNil()
+}
+
+// validatePackage checks every field of a package.
+func validatePackage(verr *ValidationError, field string, pkg *Package) {
+	if pkg.Name == "" {
+		verr.Add(field+".name", "package name is required")
+	} else if pkg.Name == "." || pkg.Name == ".." || strings.ContainsAny(pkg.Name, "/\\") {
+		verr.Add(field+".name", fmt.Sprintf("%q is not a valid file name", pkg.Name))
+	}
+
+	if !archs[pkg.Arch] {
+		verr.Add(field+".arch", fmt.Sprintf("unsupported CPU architecture %q, it must be one of x86, x64, arm or arm64", pkg.Arch))
+	}
+
+	if !oses[pkg.OS] {
+		verr.Add(field+".os", fmt.Sprintf("unsupported operating system %q, it must be one of windows, macOS, freebsd or linux", pkg.OS))
+	}
+
+	size, ok := checksumSizes[pkg.Algorithm]
+	if !ok {
+		verr.Add(field+".algorithm", fmt.Sprintf("unsupported checksum algorithm %q, it must be either sha256 or sha512", pkg.Algorithm))
+		return
+	}
+
+	checksum, err := hex.DecodeString(pkg.Checksum)
+	if err != nil {
+		verr.Add(field+".checksum", "checksum must be hex encoded")
+	} else if len(checksum) != size {
+		verr.Add(field+".checksum", fmt.Sprintf("%s checksums must be %d hex characters long", pkg.Algorithm, size*2))
+	}
+}
+
+// validURL returns whether rawurl is an absolute URL using any of the given schemes.
+func validURL(rawurl string, schemes ...string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	for _, s := range schemes {
+		if u.Scheme == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package plugin_test
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/plugin"
+)
+
+func validManifest() *plugin.Manifest {
+	return &plugin.Manifest{
+		Name:     "lint",
+		Version:  "1.0.0",
+		Homepage: "https://github.com/lift-plugins/lint",
+		Author: plugin.Author{
+			Name:  "Jane Doe",
+			Email: "jane@example.com",
+		},
+		Packages: []*plugin.Package{
+			{
+				Name:      "lint-linux-x64.tar.gz",
+				OS:        "linux",
+				Arch:      "x64",
+				Algorithm: "sha256",
+				Checksum:  strings.Repeat("ab", 32),
+			},
+			{
+				Name:      "lint-macOS-x64.tar.gz",
+				OS:        "macOS",
+				Arch:      "x64",
+				Algorithm: "sha512",
+				Checksum:  strings.Repeat("CD", 64),
+			},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		desc   string
+		modify func(m *plugin.Manifest)
+		fields []string
+	}{
+		{"valid manifest", func(m *plugin.Manifest) {}, nil},
+		{"missing name and version", func(m *plugin.Manifest) {
+			m.Name = ""
+			m.Version = ""
+		}, []string{"name", "version"}},
+		{"invalid version", func(m *plugin.Manifest) { m.Version = "one" }, []string{"version"}},
+		{"invalid author email", func(m *plugin.Manifest) { m.Author.Email = "Jane <jane@example.com>" }, []string{"author.email"}},
+		{"invalid homepage", func(m *plugin.Manifest) { m.Homepage = "ftp://example.com" }, []string{"homepage"}},
+		{"relative files URI", func(m *plugin.Manifest) { m.FilesURI = "/files/acc1/lint/1.0.0" }, []string{"files_uri"}},
+		{"no packages", func(m *plugin.Manifest) { m.Packages = nil }, []string{"packages"}},
+		{"invalid package fields", func(m *plugin.Manifest) {
+			m.Packages[0].Name = "../lint.tar.gz"
+			m.Packages[0].OS = "linux2"
+			m.Packages[0].Arch = "amd64"
+			m.Packages[1].Algorithm = "md5"
+		}, []string{"packages[0].arch", "packages[0].name", "packages[0].os", "packages[1].algorithm"}},
+		{"invalid checksums", func(m *plugin.Manifest) {
+			m.Packages[0].Checksum = strings.Repeat("zz", 32)
+			m.Packages[1].Checksum = strings.Repeat("ab", 32)
+		}, []string{"packages[0].checksum", "packages[1].checksum"}},
+		{"duplicated packages", func(m *plugin.Manifest) {
+			m.Packages[1].Name = m.Packages[0].Name
+			m.Packages[1].OS = m.Packages[0].OS
+		}, []string{"packages[1]", "packages[1].name"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			m := validManifest()
+			tt.modify(m)
+
+			err := plugin.Validate(m)
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+				return
+			}
+
+			verr, ok := errors.Cause(err).(*plugin.ValidationError)
+			if !ok {
+				t.Fatalf("expected *ValidationError, got %T: %v", err, err)
+			}
+
+			fields := make([]string, 0, len(verr.Fields))
+			for _, f := range verr.Fields {
+				fields = append(fields, f.Field)
+			}
+			sort.Strings(fields)
+
+			if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("expected violations on %v, got %v", tt.fields, fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// Ownership records which accounts are allowed to publish new versions of a plugin.
+// Plugin names are claimed by the first account publishing them.
+type Ownership struct {
+	// Name is the plugin name.
+	Name string `json:"name"`
+	// OwnerID is the account owning the plugin name.
+	OwnerID string `json:"owner_id"`
+	// Maintainers lists the accounts allowed to publish new versions on behalf of the owner.
+	Maintainers []string `json:"maintainers"`
+	// PendingOwnerID is the account the plugin is being transferred to, until it accepts the transfer.
+	PendingOwnerID string `json:"pending_owner_id"`
+	// Deprecation is set when the whole plugin is deprecated, and is inherited by the
+	// versions published afterwards.
+	Deprecation *Deprecation `json:"deprecation,omitempty"`
+}
+
+// IsMaintainer returns whether the account is a co-maintainer of the plugin.
+func (o *Ownership) IsMaintainer(accountID string) bool {
+	for _, m := range o.Maintainers {
+		if m == accountID {
+			return true
+		}
+	}
+	return false
+}
+
+// CanPublish returns whether the account is allowed to publish new versions of the plugin.
+func (o *Ownership) CanPublish(accountID string) bool {
+	return o.OwnerID == accountID || o.IsMaintainer(accountID)
+}
+
+// ownershipMu serializes changes to ownership records, so that reading and updating them
+// happens atomically. It is locked after sessionMu when both are needed.
+var ownershipMu sync.Mutex
+
+// GetOwnership returns the ownership record of a plugin.
+func GetOwnership(ctx context.Context, name string) (*Ownership, error) {
+	if name == "" {
+		return nil, errors.New("plugin name is required")
+	}
+
+	o, err := Repo.Ownership(ctx, name)
+	if _, ok := errors.Cause(err).(*NotFoundError); !ok {
+		return o, err
+	}
+
+	// Plugins published before ownership records existed, or whose record could not be
+	// stored when publishing, are owned by the account that published their first version.
+	manifests, err := Versions(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	first := manifests[0]
+	for _, m := range manifests[1:] {
+		if m.PublishedAt.Before(first.PublishedAt) {
+			first = m
+		}
+	}
+
+	return &Ownership{Name: name, OwnerID: first.AccountID}, nil
+}
+
+// claimName runs save, which stores a new version of a plugin, if the account is allowed to
+// publish new versions of it. If the plugin name was never published, it is claimed by the
+// account once save succeeds, so that failed publishes do not leave the name owned. save
+// receives the ownership record of the plugin, or nil if it is being claimed.
+func claimName(ctx context.Context, name, accountID string, save func(o *Ownership) error) error {
+	ownershipMu.Lock()
+	defer ownershipMu.Unlock()
+
+	o, err := GetOwnership(ctx, name)
+	_, claim := errors.Cause(err).(*NotFoundError)
+	if err != nil && !claim {
+		return err
+	}
+
+	if !claim && !o.CanPublish(accountID) {
+		return &PermissionDeniedError{AccountID: accountID, Action: "publish", Name: name}
+	}
+
+	if claim {
+		o = nil
+	}
+
+	if err := save(o); err != nil {
+		return err
+	}
+
+	if !claim {
+		return nil
+	}
+
+	if err := Repo.SaveOwnership(ctx, &Ownership{Name: name, OwnerID: accountID}); err != nil {
+		// The version is published, and its account is the owner GetOwnership falls back to.
+		glog.Errorf("failed storing ownership of %q by account %q: %+v", name, accountID, err)
+	}
+
+	return nil
+}
+
+// checkMaintainer makes sure an account owns or maintains a plugin before performing an
+// action on it. Admins are allowed to perform any action.
+func checkMaintainer(ctx context.Context, name, accountID, action string, admin bool) error {
+	if admin {
+		return nil
+	}
+
+	o, err := GetOwnership(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if !o.CanPublish(accountID) {
+		return &PermissionDeniedError{AccountID: accountID, Action: action, Name: name}
+	}
+
+	return nil
+}
+
+// TransferOwnership offers the ownership of a plugin to another account. The transfer
+// only takes effect once the receiving account accepts it.
+func TransferOwnership(ctx context.Context, name, ownerID, toAccountID string) error {
+	if toAccountID == "" {
+		verr := new(ValidationError)
+		verr.Add("account_id", "receiving account is required")
+		return verr
+	}
+
+	return updateOwnership(ctx, name, func(o *Ownership) error {
+		if o.OwnerID != ownerID {
+			return &PermissionDeniedError{AccountID: ownerID, Action: "transfer", Name: name}
+		}
+
+		if toAccountID == ownerID {
+			verr := new(ValidationError)
+			verr.Add("account_id", fmt.Sprintf("account %q already owns plugin %q", toAccountID, name))
+			return verr
+		}
+
+		o.PendingOwnerID = toAccountID
+		return nil
+	})
+}
+
+// AcceptOwnership completes the transfer of a plugin to the account it was offered to.
+func AcceptOwnership(ctx context.Context, name, accountID string) error {
+	return updateOwnership(ctx, name, func(o *Ownership) error {
+		if o.PendingOwnerID == "" || o.PendingOwnerID != accountID {
+			return &PermissionDeniedError{AccountID: accountID, Action: "accept the transfer of", Name: name}
+		}
+
+		o.OwnerID = accountID
+		o.PendingOwnerID = ""
+		o.Maintainers = removeAccount(o.Maintainers, accountID)
+		return nil
+	})
+}
+
+// AddMaintainer allows another account to publish new versions of a plugin.
+func AddMaintainer(ctx context.Context, name, ownerID, maintainerID string) error {
+	if maintainerID == "" {
+		verr := new(ValidationError)
+		verr.Add("account_id", "maintainer account is required")
+		return verr
+	}
+
+	return updateOwnership(ctx, name, func(o *Ownership) error {
+		if o.OwnerID != ownerID {
+			return &PermissionDeniedError{AccountID: ownerID, Action: "add maintainers to", Name: name}
+		}
+
+		if maintainerID != o.OwnerID && !o.IsMaintainer(maintainerID) {
+			o.Maintainers = append(o.Maintainers, maintainerID)
+		}
+		return nil
+	})
+}
+
+// RemoveMaintainer revokes the permission of an account to publish new versions of a plugin.
+// Maintainers can also remove themselves.
+func RemoveMaintainer(ctx context.Context, name, accountID, maintainerID string) error {
+	return updateOwnership(ctx, name, func(o *Ownership) error {
+		if o.OwnerID != accountID && maintainerID != accountID {
+			return &PermissionDeniedError{AccountID: accountID, Action: "remove maintainers from", Name: name}
+		}
+
+		o.Maintainers = removeAccount(o.Maintainers, maintainerID)
+		return nil
+	})
+}
+
+// updateOwnership atomically applies a change to the ownership record of a plugin.
+func updateOwnership(ctx context.Context, name string, change func(o *Ownership) error) error {
+	ownershipMu.Lock()
+	defer ownershipMu.Unlock()
+
+	o, err := GetOwnership(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if err := change(o); err != nil {
+		return err
+	}
+
+	return Repo.SaveOwnership(ctx, o)
+}
+
+func removeAccount(accounts []string, accountID string) []string {
+	res := make([]string, 0, len(accounts))
+	for _, a := range accounts {
+		if a != accountID {
+			res = append(res, a)
+		}
+	}
+	return res
+}
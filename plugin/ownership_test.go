@@ -0,0 +1,150 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+// claim claims a plugin name, as publishing a version of it successfully would.
+func claim(ctx context.Context, name, accountID string) error {
+	return plugin.ClaimName(ctx, name, accountID, func(*plugin.Ownership) error { return nil })
+}
+
+func TestClaimName(t *testing.T) {
+	epoch := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	plugin.Repo = plugintest.NewMemoryRepository(
+		&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.0.0", PublishedAt: epoch},
+		&plugin.Manifest{AccountID: "acc2", Name: "lint", Version: "2.0.0", PublishedAt: epoch.Add(time.Hour)},
+		// A fix of an older major version published after a newer one.
+		&plugin.Manifest{AccountID: "acc2", Name: "vet", Version: "1.0.1", PublishedAt: epoch.Add(time.Hour)},
+		&plugin.Manifest{AccountID: "acc1", Name: "vet", Version: "2.0.0", PublishedAt: epoch},
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		desc      string
+		name      string
+		accountID string
+		allowed   bool
+	}{
+		{"new name is claimed", "fmt", "acc2", true},
+		{"claimer publishes again", "fmt", "acc2", true},
+		{"other account cannot publish claimed name", "fmt", "acc1", false},
+		{"legacy plugin owned by first publisher", "lint", "acc1", true},
+		{"legacy plugin later publisher", "lint", "acc2", false},
+		{"legacy plugin owned by earliest publisher", "vet", "acc1", true},
+		{"legacy plugin lowest version publisher", "vet", "acc2", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := claim(ctx, tt.name, tt.accountID)
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+				return
+			}
+
+			if _, ok := errors.Cause(err).(*plugin.PermissionDeniedError); !ok {
+				t.Fatalf("expected *PermissionDeniedError, got %T: %v", err, err)
+			}
+		})
+	}
+}
+
+func TestClaimNameFailedSave(t *testing.T) {
+	plugin.Repo = plugintest.NewMemoryRepository()
+	ctx := context.Background()
+
+	failure := errors.New("save failed")
+	err := plugin.ClaimName(ctx, "lint", "acc1", func(*plugin.Ownership) error { return failure })
+	if errors.Cause(err) != failure {
+		t.Fatalf("expected save error, got %v", err)
+	}
+
+	if _, err := plugin.Repo.Ownership(ctx, "lint"); err == nil {
+		t.Fatal("expected failed publish to leave the name unclaimed")
+	}
+
+	if err := claim(ctx, "lint", "acc2"); err != nil {
+		t.Errorf("expected another account to claim the name: %+v", err)
+	}
+}
+
+func TestOwnershipTransfer(t *testing.T) {
+	plugin.Repo = plugintest.NewMemoryRepository()
+	ctx := context.Background()
+
+	if err := claim(ctx, "lint", "acc1"); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	denied := func(err error) {
+		t.Helper()
+		if _, ok := errors.Cause(err).(*plugin.PermissionDeniedError); !ok {
+			t.Fatalf("expected *PermissionDeniedError, got %T: %v", err, err)
+		}
+	}
+
+	denied(plugin.TransferOwnership(ctx, "lint", "acc2", "acc3"))
+
+	if err := plugin.TransferOwnership(ctx, "lint", "acc1", "acc2"); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// Transfer does not take effect until accepted.
+	denied(claim(ctx, "lint", "acc2"))
+	denied(plugin.AcceptOwnership(ctx, "lint", "acc3"))
+
+	if err := plugin.AcceptOwnership(ctx, "lint", "acc2"); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	o, err := plugin.GetOwnership(ctx, "lint")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if o.OwnerID != "acc2" || o.PendingOwnerID != "" {
+		t.Errorf("expected acc2 to own lint with no pending transfer, got %+v", o)
+	}
+
+	denied(claim(ctx, "lint", "acc1"))
+	denied(plugin.AcceptOwnership(ctx, "lint", "acc2"))
+}
+
+func TestMaintainers(t *testing.T) {
+	plugin.Repo = plugintest.NewMemoryRepository()
+	ctx := context.Background()
+
+	if err := claim(ctx, "lint", "acc1"); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := plugin.AddMaintainer(ctx, "lint", "acc2", "acc2"); err == nil {
+		t.Fatal("expected non-owner to be denied adding maintainers")
+	}
+
+	if err := plugin.AddMaintainer(ctx, "lint", "acc1", "acc2"); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := claim(ctx, "lint", "acc2"); err != nil {
+		t.Errorf("expected maintainer to be allowed to publish: %+v", err)
+	}
+
+	if err := plugin.RemoveMaintainer(ctx, "lint", "acc2", "acc2"); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := claim(ctx, "lint", "acc2"); err == nil {
+		t.Error("expected removed maintainer to be denied publishing")
+	}
+}
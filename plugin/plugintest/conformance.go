@@ -0,0 +1,1027 @@
+package plugintest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/files"
+	"github.com/hooklift/lift-registry/plugin"
+)
+
+// RunRepositoryTests runs the conformance suite every plugin.Repository implementation has
+// to pass. newRepo is called once per test and must return an empty repository.
+func RunRepositoryTests(t *testing.T, newRepo func(t *testing.T) plugin.Repository) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo plugin.Repository)
+	}{
+		{"SaveAndGet", testSaveAndGet},
+		{"Update", testUpdate},
+		{"AddDownloads", testAddDownloads},
+		{"Delete", testDelete},
+		{"Search", testSearch},
+		{"Pagination", testPagination},
+		{"Suggest", testSuggest},
+		{"MultiPackage", testMultiPackage},
+		{"Unicode", testUnicode},
+		{"Ownership", testOwnership},
+		{"Sessions", testSessions},
+		{"ConcurrentWrites", testConcurrentWrites},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepo(t))
+		})
+	}
+}
+
+// epoch is the publishing time of test manifests. Times are whole seconds, since not every
+// repository stores fractions of a second.
+var epoch = time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// newManifest returns a plugin version with a single package for linux/x64.
+func newManifest(name, version string) *plugin.Manifest {
+	return &plugin.Manifest{
+		ID:          name + "@" + version,
+		AccountID:   "acc1",
+		Name:        name,
+		Version:     version,
+		FilesURI:    "https://files.example.com/" + name + "/" + version,
+		Description: "A plugin for testing",
+		Author:      plugin.Author{Name: "Alice", Email: "alice@example.com"},
+		License:     "MIT",
+		Homepage:    "https://example.com/" + name,
+		Packages: []*plugin.Package{
+			{Name: name + "-linux-x64.tar.gz", OS: "linux", Arch: "x64", Checksum: "c0ffee", Algorithm: "sha512"},
+		},
+		PublishedAt: epoch,
+		UpdatedAt:   epoch,
+	}
+}
+
+func save(t *testing.T, repo plugin.Repository, manifests ...*plugin.Manifest) {
+	t.Helper()
+	for _, m := range manifests {
+		if err := repo.Save(context.Background(), m); err != nil {
+			t.Fatalf("failed saving %q: %+v", m.ID, err)
+		}
+	}
+}
+
+// normalize makes manifests comparable, as repositories may return empty lists instead of
+// nil, or times in a different location.
+func normalize(m *plugin.Manifest) plugin.Manifest {
+	n := *m
+	if n.Packages == nil {
+		n.Packages = []*plugin.Package{}
+	}
+	n.PublishedAt = n.PublishedAt.UTC()
+	n.UpdatedAt = n.UpdatedAt.UTC()
+	return n
+}
+
+func checkManifest(t *testing.T, got, want *plugin.Manifest) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("expected %q, got nil", want.ID)
+	}
+
+	if g, w := normalize(got), normalize(want); !reflect.DeepEqual(g, w) {
+		t.Errorf("manifest %q does not match:\n got: %s\nwant: %s", want.ID, describe(&g), describe(&w))
+	}
+}
+
+func describe(m *plugin.Manifest) string {
+	s := fmt.Sprintf("%+v", *m)
+	for _, p := range m.Packages {
+		s += fmt.Sprintf(" %+v", *p)
+	}
+	if m.Deprecation != nil {
+		s += fmt.Sprintf(" %+v", *m.Deprecation)
+	}
+	return s
+}
+
+func checkNotFound(t *testing.T, err error) {
+	t.Helper()
+	if _, ok := errors.Cause(err).(*plugin.NotFoundError); !ok {
+		t.Errorf("expected *plugin.NotFoundError, got %T: %v", err, err)
+	}
+}
+
+// ids returns the IDs of manifests, in order.
+func ids(manifests []*plugin.Manifest) []string {
+	res := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		res = append(res, m.ID)
+	}
+	return res
+}
+
+func sortedIDs(manifests []*plugin.Manifest) []string {
+	res := ids(manifests)
+	sort.Strings(res)
+	return res
+}
+
+// search runs a query with the defaults plugin.Search would fill in.
+func search(t *testing.T, repo plugin.Repository, q plugin.SearchQuery) *plugin.SearchResult {
+	t.Helper()
+	if q.Sort == "" {
+		q.Sort = plugin.SortRelevance
+	}
+	if q.PageNumber == 0 && q.PageToken == "" {
+		q.PageNumber = 1
+	}
+	if q.ResultsPerPage == 0 {
+		q.ResultsPerPage = 10
+	}
+
+	res, err := repo.Search(context.Background(), &q)
+	if err != nil {
+		t.Fatalf("failed searching %+v: %+v", q, err)
+	}
+	return res
+}
+
+func testSaveAndGet(t *testing.T, repo plugin.Repository) {
+	ctx := context.Background()
+
+	lint := newManifest("lint", "1.0.0")
+	lint.Deprecation = &plugin.Deprecation{Message: "use 2.x", Replacement: "vet"}
+	lint.Downloads = 42
+	lint.UpdatedAt = epoch.Add(time.Hour)
+	save(t, repo, lint, newManifest("lint", "1.1.0"), newManifest("lint", "2.0.0-beta1"), newManifest("lint-extra", "1.0.0"))
+
+	m, err := repo.Get(ctx, "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	checkManifest(t, m, lint)
+
+	err = repo.Save(ctx, newManifest("lint", "1.0.0"))
+	if _, ok := errors.Cause(err).(*plugin.VersionExistsError); !ok {
+		t.Errorf("expected *plugin.VersionExistsError saving a version twice, got %T: %v", err, err)
+	}
+
+	err = repo.Save(ctx, newManifest("Lint", "3.0.0"))
+	if e, ok := errors.Cause(err).(*plugin.NameTakenError); !ok || e.Taken != "lint" {
+		t.Errorf("expected *plugin.NameTakenError by lint saving Lint, got %T: %v", err, err)
+	}
+
+	if m, _ := repo.Get(ctx, "lint", "1.0.0"); m != nil && m.Downloads != lint.Downloads {
+		t.Errorf("saving a version twice must not overwrite it")
+	}
+
+	_, err = repo.Get(ctx, "lint", "3.0.0")
+	checkNotFound(t, err)
+
+	_, err = repo.Get(ctx, "vet", "1.0.0")
+	checkNotFound(t, err)
+
+	versions, err := repo.Versions(ctx, "lint")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := []string{"lint@1.0.0", "lint@1.1.0", "lint@2.0.0-beta1"}
+	if got := sortedIDs(versions); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected versions %v, got %v", expected, got)
+	}
+
+	versions, err = repo.Versions(ctx, "vet")
+	if err != nil || len(versions) != 0 {
+		t.Errorf("expected no versions of a missing plugin, got %v (%v)", ids(versions), err)
+	}
+
+	names, err := repo.Names(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	sort.Strings(names)
+	if expected := []string{"lint", "lint-extra"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected names %v, got %v", expected, names)
+	}
+}
+
+func testUpdate(t *testing.T, repo plugin.Repository) {
+	ctx := context.Background()
+	save(t, repo, newManifest("lint", "1.0.0"), newManifest("lint", "1.1.0"))
+
+	m, err := repo.Get(ctx, "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	m.Yanked = true
+	m.YankReason = "broken build"
+	m.Deprecation = &plugin.Deprecation{Message: "no longer maintained"}
+	m.Downloads = 7
+	m.UpdatedAt = epoch.Add(time.Hour)
+	if err := repo.Update(ctx, m); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	updated, err := repo.Get(ctx, "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// Download counts only change through AddDownloads.
+	if updated.Downloads != 0 {
+		t.Errorf("expected update to leave downloads as stored, got %d", updated.Downloads)
+	}
+	m.Downloads = 0
+	checkManifest(t, updated, m)
+
+	// Yanked versions must not be returned by searches.
+	res := search(t, repo, plugin.SearchQuery{Text: "lint"})
+	if got, expected := ids(res.Manifests), []string{"lint@1.1.0"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	m.Yanked = false
+	m.Deprecation = nil
+	if err := repo.Update(ctx, m); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if updated, _ := repo.Get(ctx, "lint", "1.0.0"); updated == nil || updated.Yanked || updated.Deprecation != nil {
+		t.Errorf("expected version to be unyanked and undeprecated, got %+v", updated)
+	}
+
+	checkNotFound(t, repo.Update(ctx, newManifest("lint", "3.0.0")))
+
+	// Several versions are updated at once, or not at all.
+	first, second := newManifest("lint", "1.0.0"), newManifest("lint", "1.1.0")
+	first.Yanked, second.Yanked = true, true
+	checkNotFound(t, repo.Update(ctx, first, newManifest("lint", "3.0.0"), second))
+
+	versions, err := repo.Versions(ctx, "lint")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for _, v := range versions {
+		if v.Yanked {
+			t.Errorf("expected failed update to leave %q untouched", v.ID)
+		}
+	}
+
+	if err := repo.Update(ctx, first, second); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if res := search(t, repo, plugin.SearchQuery{Text: "lint"}); len(res.Manifests) != 0 {
+		t.Errorf("expected both versions to be yanked, got %v", ids(res.Manifests))
+	}
+}
+
+func testAddDownloads(t *testing.T, repo plugin.Repository) {
+	ctx := context.Background()
+	lint := newManifest("lint", "1.0.0")
+	lint.Downloads = 5
+	save(t, repo, lint, newManifest("vet", "1.0.0"))
+
+	counts := map[string]int64{"lint@1.0.0": 2, "vet@1.0.0": 10, "format@1.0.0": 1}
+	if err := repo.AddDownloads(ctx, counts); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := repo.AddDownloads(ctx, map[string]int64{"lint@1.0.0": 1}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for id, expected := range map[string]int64{"lint": 8, "vet": 10} {
+		m, err := repo.Get(ctx, id, "1.0.0")
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if m.Downloads != expected {
+			t.Errorf("expected %s to have %d downloads, got %d", m.ID, expected, m.Downloads)
+		}
+	}
+
+	if _, err := repo.Get(ctx, "format", "1.0.0"); err == nil {
+		t.Errorf("expected downloads of missing versions to be skipped")
+	}
+
+	res := search(t, repo, plugin.SearchQuery{Sort: plugin.SortDownloads})
+	if got, expected := ids(res.Manifests), []string{"vet@1.0.0", "lint@1.0.0"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v sorting by downloads, got %v", expected, got)
+	}
+
+	if err := repo.AddDownloads(ctx, nil); err != nil {
+		t.Errorf("unexpected error adding no downloads: %+v", err)
+	}
+}
+
+func testDelete(t *testing.T, repo plugin.Repository) {
+	ctx := context.Background()
+	save(t, repo, newManifest("lint", "1.0.0"), newManifest("lint", "1.1.0"))
+
+	if err := repo.Delete(ctx, "lint@1.0.0"); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	_, err := repo.Get(ctx, "lint", "1.0.0")
+	checkNotFound(t, err)
+
+	checkNotFound(t, repo.Delete(ctx, "lint@1.0.0"))
+	checkNotFound(t, repo.Delete(ctx, "vet@1.0.0"))
+
+	versions, err := repo.Versions(ctx, "lint")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if got, expected := ids(versions), []string{"lint@1.1.0"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	res := search(t, repo, plugin.SearchQuery{})
+	if got, expected := ids(res.Manifests), []string{"lint@1.1.0"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected deleted version not to be found, got %v", got)
+	}
+
+	// Deleted versions can be published again.
+	save(t, repo, newManifest("lint", "1.0.0"))
+}
+
+func testSearch(t *testing.T, repo plugin.Repository) {
+	lint := newManifest("lint", "1.0.0")
+	lint.Description = "Finds style mistakes"
+	lint.Downloads = 30
+
+	format := newManifest("format", "1.0.0")
+	format.Description = "Formats source code"
+	format.Author = plugin.Author{Name: "Bob", Email: "bob@example.com"}
+	format.License = "Apache-2.0"
+	format.Packages[0].OS = "macOS"
+	format.Packages[0].Arch = "arm64"
+	format.PublishedAt = epoch.Add(time.Hour)
+	format.UpdatedAt = epoch.Add(10 * time.Hour)
+	format.Downloads = 20
+
+	vet := newManifest("vet", "1.0.0")
+	vet.Description = "Reports suspicious constructs"
+	vet.Packages[0].Arch = "arm64"
+	vet.PublishedAt = epoch.Add(2 * time.Hour)
+	vet.UpdatedAt = vet.PublishedAt
+	vet.Downloads = 10
+
+	oldlint := newManifest("oldlint", "1.0.0")
+	oldlint.Description = "Finds style mistakes too"
+	oldlint.Yanked = true
+
+	save(t, repo, lint, format, vet, oldlint)
+
+	tests := []struct {
+		desc  string
+		query plugin.SearchQuery
+		// ordered is set when results have to be returned in order.
+		ordered bool
+		ids     []string
+	}{
+		{"everything", plugin.SearchQuery{}, false, []string{"format@1.0.0", "lint@1.0.0", "vet@1.0.0"}},
+		{"name", plugin.SearchQuery{Text: "vet"}, false, []string{"vet@1.0.0"}},
+		{"description", plugin.SearchQuery{Text: "style"}, false, []string{"lint@1.0.0"}},
+		{"no match", plugin.SearchQuery{Text: "nothing"}, false, []string{}},
+		{"os", plugin.SearchQuery{OS: "linux"}, false, []string{"lint@1.0.0", "vet@1.0.0"}},
+		{"arch", plugin.SearchQuery{Arch: "arm64"}, false, []string{"format@1.0.0", "vet@1.0.0"}},
+		{"os and arch", plugin.SearchQuery{OS: "linux", Arch: "arm64"}, false, []string{"vet@1.0.0"}},
+		{"license", plugin.SearchQuery{License: "MIT"}, false, []string{"lint@1.0.0", "vet@1.0.0"}},
+		{"author name", plugin.SearchQuery{Author: "Bob"}, false, []string{"format@1.0.0"}},
+		{"author email ignores case", plugin.SearchQuery{Author: "ALICE@example.com"}, false, []string{"lint@1.0.0", "vet@1.0.0"}},
+		{"published after", plugin.SearchQuery{PublishedAfter: epoch.Add(time.Hour)}, false, []string{"vet@1.0.0"}},
+		{"text and filters", plugin.SearchQuery{Text: "style", License: "Apache-2.0"}, false, []string{}},
+		{"sort by name", plugin.SearchQuery{Sort: plugin.SortName}, true, []string{"format@1.0.0", "lint@1.0.0", "vet@1.0.0"}},
+		{"sort by newest", plugin.SearchQuery{Sort: plugin.SortNewest}, true, []string{"vet@1.0.0", "format@1.0.0", "lint@1.0.0"}},
+		{"sort by downloads", plugin.SearchQuery{Sort: plugin.SortDownloads}, true, []string{"lint@1.0.0", "format@1.0.0", "vet@1.0.0"}},
+		{"sort by updated", plugin.SearchQuery{Sort: plugin.SortUpdated}, true, []string{"format@1.0.0", "vet@1.0.0", "lint@1.0.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			res := search(t, repo, tt.query)
+
+			got := ids(res.Manifests)
+			if !tt.ordered {
+				got = sortedIDs(res.Manifests)
+			}
+
+			if !reflect.DeepEqual(got, tt.ids) {
+				t.Errorf("expected %v, got %v", tt.ids, got)
+			}
+
+			if res.Total != len(tt.ids) {
+				t.Errorf("expected a total of %d, got %d", len(tt.ids), res.Total)
+			}
+		})
+	}
+
+	t.Run("results are complete", func(t *testing.T) {
+		res := search(t, repo, plugin.SearchQuery{Text: "vet"})
+		if len(res.Manifests) != 1 {
+			t.Fatalf("expected 1 result, got %v", ids(res.Manifests))
+		}
+		checkManifest(t, res.Manifests[0], vet)
+	})
+
+	t.Run("facets", func(t *testing.T) {
+		res := search(t, repo, plugin.SearchQuery{})
+
+		expected := map[string]map[string]int{
+			"os":      {"linux": 2, "macOS": 1},
+			"arch":    {"x64": 1, "arm64": 2},
+			"license": {"MIT": 2, "Apache-2.0": 1},
+			"author":  {"Alice": 2, "Bob": 1},
+		}
+
+		got := make(map[string]map[string]int)
+		for _, f := range res.Facets {
+			terms := make(map[string]int)
+			for _, term := range f.Terms {
+				terms[term.Term] = term.Count
+			}
+			got[f.Name] = terms
+		}
+
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected facets %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("suggestions", func(t *testing.T) {
+		s, err := repo.Suggest(context.Background(), "li", 5)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if expected := []string{"lint"}; !reflect.DeepEqual(s.Completions, expected) {
+			t.Errorf("expected completions %v, got %v", expected, s.Completions)
+		}
+
+		s, err = repo.Suggest(context.Background(), "fromat", 5)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if expected := []string{"format"}; !reflect.DeepEqual(s.Corrections, expected) {
+			t.Errorf("expected corrections %v, got %v", expected, s.Corrections)
+		}
+	})
+}
+
+func testSuggest(t *testing.T, repo plugin.Repository) {
+	ctx := context.Background()
+
+	// Plugins with many versions must not crowd out other names.
+	for i := 0; i < 12; i++ {
+		save(t, repo, newManifest("lint", fmt.Sprintf("1.0.%d", i)))
+	}
+
+	yanked := newManifest("lintian", "1.0.0")
+	yanked.Yanked = true
+	save(t, repo, newManifest("linter", "1.0.0"), newManifest("Lintel", "1.0.0"), yanked, newManifest("mint", "1.0.0"))
+
+	s, err := repo.Suggest(ctx, "lin", 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if expected := []string{"lint", "Lintel", "linter"}; !reflect.DeepEqual(sortedFold(s.Completions), expected) {
+		t.Errorf("expected completions %v, got %v", expected, s.Completions)
+	}
+
+	s, err = repo.Suggest(ctx, "LIN", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(s.Completions) != 2 {
+		t.Errorf("expected 2 distinct completions, got %v", s.Completions)
+	}
+
+	s, err = repo.Suggest(ctx, "lnt", 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if expected := []string{"lint"}; !reflect.DeepEqual(s.Corrections, expected) {
+		t.Errorf("expected corrections %v, got %v", expected, s.Corrections)
+	}
+}
+
+// sortedFold sorts names ignoring case.
+func sortedFold(names []string) []string {
+	res := append([]string(nil), names...)
+	sort.Slice(res, func(i, j int) bool {
+		return strings.ToLower(res[i]) < strings.ToLower(res[j])
+	})
+	return res
+}
+
+func testPagination(t *testing.T, repo plugin.Repository) {
+	all := make([]string, 0, 7)
+	for i := 0; i < 7; i++ {
+		m := newManifest(fmt.Sprintf("plugin%d", i), "1.0.0")
+		save(t, repo, m)
+		all = append(all, m.ID)
+	}
+
+	tests := []struct {
+		desc     string
+		page     int
+		size     int
+		ids      []string
+		nextPage bool
+	}{
+		{"first page", 1, 3, all[0:3], true},
+		{"middle page", 2, 3, all[3:6], true},
+		{"last partial page", 3, 3, all[6:], false},
+		{"past the last page", 4, 3, []string{}, false},
+		{"far past the last page", 100, 3, []string{}, false},
+		{"page size equal to total", 1, 7, all, false},
+		{"page size over total", 1, 50, all, false},
+		{"last full page", 7, 1, all[6:], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			res := search(t, repo, plugin.SearchQuery{Sort: plugin.SortName, PageNumber: tt.page, ResultsPerPage: tt.size})
+
+			if got := ids(res.Manifests); !reflect.DeepEqual(got, tt.ids) {
+				t.Errorf("expected %v, got %v", tt.ids, got)
+			}
+
+			if res.Total != len(all) {
+				t.Errorf("expected a total of %d, got %d", len(all), res.Total)
+			}
+
+			if next := res.NextPageToken != ""; next != tt.nextPage {
+				t.Errorf("expected next page token to be set: %t, got %q", tt.nextPage, res.NextPageToken)
+			}
+		})
+	}
+
+	for _, size := range []int{1, 3, 7} {
+		t.Run(fmt.Sprintf("page tokens of size %d", size), func(t *testing.T) {
+			q := plugin.SearchQuery{Sort: plugin.SortName, ResultsPerPage: size}
+			got := make([]string, 0, len(all))
+
+			// Every page token has to move forward, so this many pages are always enough.
+			for i := 0; i <= len(all); i++ {
+				res := search(t, repo, q)
+				got = append(got, ids(res.Manifests)...)
+
+				if res.NextPageToken == "" || len(res.Manifests) == 0 {
+					break
+				}
+				q.PageToken = res.NextPageToken
+			}
+
+			if !reflect.DeepEqual(got, all) {
+				t.Errorf("expected every result exactly once %v, got %v", all, got)
+			}
+		})
+	}
+
+	// Relevance scores and download counts tie a lot, so tokens rely on the score and document
+	// ID tiebreakers to resume at the right result.
+	ctx := context.Background()
+	if err := repo.AddDownloads(ctx, map[string]int64{all[2]: 3, all[5]: 3, all[4]: 1}); err != nil {
+		t.Fatalf("unexpected error adding downloads: %+v", err)
+	}
+
+	for _, order := range []plugin.SortOrder{plugin.SortRelevance, plugin.SortDownloads} {
+		expected := ids(search(t, repo, plugin.SearchQuery{Sort: order, ResultsPerPage: len(all)}).Manifests)
+		if len(expected) != len(all) {
+			t.Fatalf("expected %d results sorting by %s, got %v", len(all), order, expected)
+		}
+
+		for _, size := range []int{1, 3} {
+			t.Run(fmt.Sprintf("page tokens of size %d sorting by %s", size, order), func(t *testing.T) {
+				q := plugin.SearchQuery{Sort: order, ResultsPerPage: size}
+				got := make([]string, 0, len(all))
+				for i := 0; i <= len(all); i++ {
+					res := search(t, repo, q)
+					got = append(got, ids(res.Manifests)...)
+
+					if res.NextPageToken == "" || len(res.Manifests) == 0 {
+						break
+					}
+					q.PageToken = res.NextPageToken
+				}
+
+				if !reflect.DeepEqual(got, expected) {
+					t.Errorf("expected every result exactly once %v, got %v", expected, got)
+				}
+			})
+		}
+	}
+
+	t.Run("invalid page token", func(t *testing.T) {
+		q := &plugin.SearchQuery{Sort: plugin.SortName, ResultsPerPage: 3, PageToken: "not a token"}
+		_, err := repo.Search(context.Background(), q)
+		if _, ok := errors.Cause(err).(*plugin.ValidationError); !ok {
+			t.Errorf("expected *plugin.ValidationError, got %T: %v", err, err)
+		}
+	})
+
+	// Versions published between pages sort before the page already returned, so they must
+	// not shift the following pages, as page numbers would.
+	t.Run("page tokens while publishing", func(t *testing.T) {
+		q := plugin.SearchQuery{Sort: plugin.SortNewest, ResultsPerPage: 3}
+		res := search(t, repo, q)
+		if got := ids(res.Manifests); !reflect.DeepEqual(got, all[0:3]) {
+			t.Fatalf("expected %v, got %v", all[0:3], got)
+		}
+
+		newer := newManifest("plugin9", "1.0.0")
+		newer.PublishedAt = epoch.Add(time.Hour)
+		// Published at the same time as the other plugins, and sorted before the last result.
+		tied := newManifest("plugin0a", "1.0.0")
+		save(t, repo, newer, tied)
+
+		got := make([]string, 0, len(all))
+		for i := 0; i <= len(all) && res.NextPageToken != ""; i++ {
+			q.PageToken = res.NextPageToken
+			res = search(t, repo, q)
+			got = append(got, ids(res.Manifests)...)
+		}
+
+		if !reflect.DeepEqual(got, all[3:]) {
+			t.Errorf("expected the remaining results %v, got %v", all[3:], got)
+		}
+	})
+}
+
+func testMultiPackage(t *testing.T, repo plugin.Repository) {
+	m := newManifest("lint", "1.0.0")
+	m.Packages = []*plugin.Package{
+		{Name: "lint-linux-x64.tar.gz", OS: "linux", Arch: "x64", Checksum: "aaa", Algorithm: "sha512"},
+		{Name: "lint-linux-arm64.tar.gz", OS: "linux", Arch: "arm64", Checksum: "bbb", Algorithm: "sha512"},
+		{Name: "lint-macOS-x64.tar.gz", OS: "macOS", Arch: "x64", Checksum: "ccc", Algorithm: "sha256"},
+		{Name: "lint-windows-x86.zip", OS: "windows", Arch: "x86", Checksum: "ddd", Algorithm: "sha256"},
+	}
+	save(t, repo, m, newManifest("vet", "1.0.0"))
+
+	got, err := repo.Get(context.Background(), "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// Packages must keep their order, with every field matched to the right package.
+	checkManifest(t, got, m)
+
+	tests := []struct {
+		desc  string
+		query plugin.SearchQuery
+		ids   []string
+	}{
+		{"any package os", plugin.SearchQuery{OS: "windows"}, []string{"lint@1.0.0"}},
+		{"any package arch", plugin.SearchQuery{Arch: "arm64"}, []string{"lint@1.0.0"}},
+		{"shared os", plugin.SearchQuery{OS: "linux"}, []string{"lint@1.0.0", "vet@1.0.0"}},
+		{"missing os", plugin.SearchQuery{OS: "freebsd"}, []string{}},
+		{"os and arch of one package", plugin.SearchQuery{OS: "linux", Arch: "arm64"}, []string{"lint@1.0.0"}},
+		// Both have to match the same package, not just any of them.
+		{"os and arch of different packages", plugin.SearchQuery{OS: "macOS", Arch: "x86"}, []string{}},
+		{"os and arch of another plugin", plugin.SearchQuery{OS: "windows", Arch: "x64"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			res := search(t, repo, tt.query)
+			if got := sortedIDs(res.Manifests); !reflect.DeepEqual(got, tt.ids) {
+				t.Errorf("expected %v, got %v", tt.ids, got)
+			}
+		})
+	}
+}
+
+func testUnicode(t *testing.T, repo plugin.Repository) {
+	ctx := context.Background()
+
+	m := newManifest("übersetzer", "1.0.0")
+	m.Description = "Übersetzt Kommentare ins Deutsche — 日本語もサポート"
+	m.Author = plugin.Author{Name: "Zoë Ñúñez", Email: "zoe@example.com"}
+	m.Packages[0].Name = "übersetzer-linux-x64.tar.gz"
+	save(t, repo, m, newManifest("lint", "1.0.0"))
+
+	got, err := repo.Get(ctx, "übersetzer", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	checkManifest(t, got, m)
+
+	versions, err := repo.Versions(ctx, "übersetzer")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if got, expected := ids(versions), []string{m.ID}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	for _, q := range []plugin.SearchQuery{
+		{Text: "übersetzer"},
+		{Author: "Zoë Ñúñez"},
+	} {
+		res := search(t, repo, q)
+		if got, expected := ids(res.Manifests), []string{m.ID}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("searching %+v: expected %v, got %v", q, expected, got)
+		}
+	}
+
+	s, err := repo.Suggest(ctx, "über", 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if expected := []string{"übersetzer"}; !reflect.DeepEqual(s.Completions, expected) {
+		t.Errorf("expected completions %v, got %v", expected, s.Completions)
+	}
+}
+
+func testOwnership(t *testing.T, repo plugin.Repository) {
+	ctx := context.Background()
+
+	_, err := repo.Ownership(ctx, "lint")
+	checkNotFound(t, err)
+
+	o := &plugin.Ownership{
+		Name:           "lint",
+		OwnerID:        "acc1",
+		Maintainers:    []string{"acc3", "acc2"},
+		PendingOwnerID: "acc4",
+		Deprecation:    &plugin.Deprecation{Message: "use vet", Replacement: "vet"},
+	}
+	if err := repo.SaveOwnership(ctx, o); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	checkOwnership(t, repo, o)
+
+	// Saving replaces the whole record.
+	o = &plugin.Ownership{Name: "lint", OwnerID: "acc4", Maintainers: []string{"acc2"}}
+	if err := repo.SaveOwnership(ctx, o); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	checkOwnership(t, repo, o)
+}
+
+func checkOwnership(t *testing.T, repo plugin.Repository, want *plugin.Ownership) {
+	t.Helper()
+	got, err := repo.Ownership(context.Background(), want.Name)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(got.Maintainers) == 0 && len(want.Maintainers) == 0 {
+		got.Maintainers = want.Maintainers
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected ownership %+v, got %+v", want, got)
+	}
+}
+
+func testSessions(t *testing.T, repo plugin.Repository) {
+	ctx := context.Background()
+
+	_, err := repo.Session(ctx, "s1")
+	checkSessionNotFound(t, err)
+	checkSessionNotFound(t, repo.DeleteSession(ctx, "s1"))
+
+	s1 := &plugin.Session{
+		ID:        "s1",
+		AccountID: "acc1",
+		Name:      "lint",
+		Version:   "1.0.0",
+		Files:     []*files.Object{},
+		CreatedAt: epoch,
+		ExpiresAt: epoch.Add(time.Hour),
+	}
+	if err := repo.SaveSession(ctx, s1); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	checkSession(t, repo, s1)
+
+	// Saving replaces the whole session.
+	s1.Files = []*files.Object{
+		{Key: ".staging/s1/lint-linux-x64.tar.gz", Size: 42, ContentType: "application/gzip", Digests: map[string]string{"sha512": "c0ffee"}},
+	}
+	if err := repo.SaveSession(ctx, s1); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	checkSession(t, repo, s1)
+
+	// Expired sessions are still listed, so that they can be collected.
+	s2 := &plugin.Session{ID: "s2", AccountID: "acc2", Name: "vet", Version: "2.0.0", Files: []*files.Object{}, CreatedAt: epoch, ExpiresAt: epoch}
+	if err := repo.SaveSession(ctx, s2); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	sessions, err := repo.Sessions(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	listed := make([]string, 0, len(sessions))
+	for _, s := range sessions {
+		listed = append(listed, s.ID)
+	}
+	sort.Strings(listed)
+
+	if expected := []string{"s1", "s2"}; !reflect.DeepEqual(listed, expected) {
+		t.Errorf("expected sessions %v, got %v", expected, listed)
+	}
+
+	if err := repo.DeleteSession(ctx, "s1"); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	_, err = repo.Session(ctx, "s1")
+	checkSessionNotFound(t, err)
+	checkSession(t, repo, s2)
+}
+
+func checkSession(t *testing.T, repo plugin.Repository, want *plugin.Session) {
+	t.Helper()
+	got, err := repo.Session(context.Background(), want.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(got.Files) == 0 && len(want.Files) == 0 {
+		got.Files = want.Files
+	}
+
+	got.CreatedAt = got.CreatedAt.UTC()
+	got.ExpiresAt = got.ExpiresAt.UTC()
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected session %+v, got %+v", want, got)
+	}
+}
+
+func checkSessionNotFound(t *testing.T, err error) {
+	t.Helper()
+	if _, ok := errors.Cause(err).(*plugin.SessionNotFoundError); !ok {
+		t.Errorf("expected *plugin.SessionNotFoundError, got %T: %v", err, err)
+	}
+}
+
+func testConcurrentWrites(t *testing.T, repo plugin.Repository) {
+	ctx := context.Background()
+	const writers = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repo.Save(ctx, newManifest("lint", fmt.Sprintf("1.0.%d", i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error saving distinct versions: %+v", err)
+		}
+	}
+
+	versions, err := repo.Versions(ctx, "lint")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(versions) != writers {
+		t.Errorf("expected %d versions, got %d", writers, len(versions))
+	}
+
+	// Only one of several concurrent saves of the same version can succeed.
+	errs = make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- repo.Save(ctx, newManifest("vet", "1.0.0"))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	saved := 0
+	for err := range errs {
+		if err == nil {
+			saved++
+			continue
+		}
+
+		if _, ok := errors.Cause(err).(*plugin.VersionExistsError); !ok {
+			t.Errorf("expected *plugin.VersionExistsError, got %T: %v", err, err)
+		}
+	}
+
+	if saved != 1 {
+		t.Errorf("expected exactly one save to succeed, %d did", saved)
+	}
+
+	// Only one of several concurrent saves of names differing in case can succeed.
+	errs = make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "format"
+			if i%2 == 1 {
+				name = "Format"
+			}
+			errs <- repo.Save(ctx, newManifest(name, fmt.Sprintf("1.0.%d", i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	taken := 0
+	for err := range errs {
+		if _, ok := errors.Cause(err).(*plugin.NameTakenError); ok {
+			taken++
+		} else if err != nil {
+			t.Errorf("unexpected error: %+v", err)
+		}
+	}
+
+	names, err := repo.Names(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	formats := 0
+	for _, name := range names {
+		if strings.EqualFold(name, "format") {
+			formats++
+		}
+	}
+
+	if formats != 1 || taken != writers/2 {
+		t.Errorf("expected a single format plugin and %d taken names, got %d plugins and %d taken names", writers/2, formats, taken)
+	}
+
+	// Concurrent updates, downloads and ownership changes do not interfere.
+	for i := 0; i < writers; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			m := newManifest("lint", fmt.Sprintf("1.0.%d", i))
+			m.YankReason = fmt.Sprintf("reason %d", i)
+			if err := repo.Update(ctx, m); err != nil {
+				t.Errorf("unexpected error: %+v", err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			if err := repo.AddDownloads(ctx, map[string]int64{fmt.Sprintf("lint@1.0.%d", i): int64(i)}); err != nil {
+				t.Errorf("unexpected error: %+v", err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			o := &plugin.Ownership{Name: fmt.Sprintf("plugin%d", i), OwnerID: "acc1"}
+			if err := repo.SaveOwnership(ctx, o); err != nil {
+				t.Errorf("unexpected error: %+v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < writers; i++ {
+		m, err := repo.Get(ctx, "lint", fmt.Sprintf("1.0.%d", i))
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if m.Downloads != int64(i) || m.YankReason != fmt.Sprintf("reason %d", i) {
+			t.Errorf("expected %q to have %d downloads and to be updated, got %+v", m.ID, i, m)
+		}
+
+		if _, err := repo.Ownership(ctx, fmt.Sprintf("plugin%d", i)); err != nil {
+			t.Errorf("unexpected error: %+v", err)
+		}
+	}
+}
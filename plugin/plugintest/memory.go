@@ -0,0 +1,608 @@
+package plugintest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/files"
+	"github.com/hooklift/lift-registry/plugin"
+)
+
+// Facets computed for every search, named as in the other repositories.
+const (
+	osFacet      = "os"
+	archFacet    = "arch"
+	licenseFacet = "license"
+	authorFacet  = "author"
+)
+
+// MemoryRepository is a plugin.Repository keeping everything in memory, for tests. It
+// behaves like the other repositories, except for full text search which only matches whole
+// words, without stemming.
+type MemoryRepository struct {
+	mu        sync.Mutex
+	manifests map[string]*plugin.Manifest
+	owners    map[string]*plugin.Ownership
+	sessions  map[string]*plugin.Session
+}
+
+// NewMemoryRepository returns an empty in-memory repository, optionally holding the given
+// plugin versions. Manifests missing an ID get one assigned.
+func NewMemoryRepository(manifests ...*plugin.Manifest) *MemoryRepository {
+	r := &MemoryRepository{
+		manifests: make(map[string]*plugin.Manifest),
+		owners:    make(map[string]*plugin.Ownership),
+		sessions:  make(map[string]*plugin.Session),
+	}
+
+	for _, m := range manifests {
+		if m.ID == "" {
+			m.ID = m.Name + "@" + m.Version
+		}
+		r.manifests[m.ID] = copyManifest(m)
+	}
+	return r
+}
+
+// Search finds plugin versions whose name, description or author contains any of the
+// words in the query text.
+func (r *MemoryRepository) Search(ctx context.Context, q *plugin.SearchQuery) (*plugin.SearchResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var after *plugin.Manifest
+	var afterScore int
+	if q.PageToken != "" {
+		var err error
+		if after, afterScore, err = decodeMemoryToken(q.PageToken); err != nil {
+			return nil, err
+		}
+	}
+
+	matches := make([]*plugin.Manifest, 0)
+	scores := make(map[string]int)
+	for _, m := range r.manifests {
+		if score := memoryScore(m, q); score > 0 {
+			matches = append(matches, m)
+			scores[m.ID] = score
+		}
+	}
+	sortMemoryResults(matches, q.Sort, scores)
+
+	res := &plugin.SearchResult{
+		Manifests: make([]*plugin.Manifest, 0, q.ResultsPerPage),
+		Total:     len(matches),
+		Facets:    memoryFacets(matches),
+	}
+
+	// Page tokens resume right after the last result of the previous page.
+	offset := (q.PageNumber - 1) * q.ResultsPerPage
+	if after != nil {
+		offset = sort.Search(len(matches), func(i int) bool {
+			return memoryLess(after, matches[i], q.Sort, afterScore, scores[matches[i].ID])
+		})
+	}
+
+	for i := offset; i >= 0 && i < len(matches) && len(res.Manifests) < q.ResultsPerPage; i++ {
+		res.Manifests = append(res.Manifests, copyManifest(matches[i]))
+	}
+
+	if next := offset + len(res.Manifests); len(res.Manifests) > 0 && next < len(matches) {
+		last := res.Manifests[len(res.Manifests)-1]
+		token, err := encodeMemoryToken(last, scores[last.ID])
+		if err != nil {
+			return nil, err
+		}
+		res.NextPageToken = token
+	}
+
+	return res, nil
+}
+
+// memoryToken holds the sort values of the last result of a page.
+type memoryToken struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	PublishedAt time.Time `json:"published_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Downloads   int64     `json:"downloads"`
+	Score       int       `json:"score"`
+}
+
+// encodeMemoryToken encodes the sort values of the last result of a page into a page token.
+func encodeMemoryToken(m *plugin.Manifest, score int) (string, error) {
+	data, err := json.Marshal(memoryToken{
+		ID:          m.ID,
+		Name:        m.Name,
+		PublishedAt: m.PublishedAt,
+		UpdatedAt:   m.UpdatedAt,
+		Downloads:   m.Downloads,
+		Score:       score,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed encoding page token")
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeMemoryToken decodes the sort values a page token was created from, as a manifest
+// that can be compared to search results and its score.
+func decodeMemoryToken(token string) (*plugin.Manifest, int, error) {
+	var t memoryToken
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &t)
+	}
+
+	if err != nil || t.ID == "" {
+		verr := new(plugin.ValidationError)
+		verr.Add("page_token", "invalid page token")
+		return nil, 0, verr
+	}
+	m := &plugin.Manifest{ID: t.ID, Name: t.Name, PublishedAt: t.PublishedAt, UpdatedAt: t.UpdatedAt, Downloads: t.Downloads}
+	return m, t.Score, nil
+}
+
+// memoryScore returns how well a plugin version matches a query, or 0 if it does not match
+// at all. Name matches weigh more than description or author matches.
+func memoryScore(m *plugin.Manifest, q *plugin.SearchQuery) int {
+	if m.Yanked ||
+		q.License != "" && m.License != q.License ||
+		q.Author != "" && m.Author.Name != q.Author && !strings.EqualFold(m.Author.Email, q.Author) ||
+		!q.PublishedAfter.IsZero() && !m.PublishedAt.After(q.PublishedAfter) {
+		return 0
+	}
+
+	if q.OS != "" || q.Arch != "" {
+		found := false
+		for _, p := range m.Packages {
+			if (q.OS == "" || p.OS == q.OS) && (q.Arch == "" || p.Arch == q.Arch) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return 0
+		}
+	}
+
+	terms := memoryWords(q.Text)
+	if len(terms) == 0 {
+		return 1
+	}
+
+	score := 0
+	for _, term := range terms {
+		score += 5*countWord(memoryWords(m.Name), term) + countWord(memoryWords(m.Description), term) + countWord(memoryWords(m.Author.Name), term)
+	}
+	return score
+}
+
+// memoryWords splits text into lower case words.
+func memoryWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// countWord returns how many times value appears in values.
+func countWord(values []string, value string) int {
+	n := 0
+	for _, v := range values {
+		if v == value {
+			n++
+		}
+	}
+	return n
+}
+
+// sortMemoryResults sorts search results. Document IDs break ties, like in the other repositories.
+func sortMemoryResults(manifests []*plugin.Manifest, order plugin.SortOrder, scores map[string]int) {
+	sort.Slice(manifests, func(i, j int) bool {
+		return memoryLess(manifests[i], manifests[j], order, scores[manifests[i].ID], scores[manifests[j].ID])
+	})
+}
+
+// memoryLess returns whether a, matching with scoreA, sorts before b, matching with scoreB,
+// in search results.
+func memoryLess(a, b *plugin.Manifest, order plugin.SortOrder, scoreA, scoreB int) bool {
+	switch order {
+	case plugin.SortNewest:
+		if !a.PublishedAt.Equal(b.PublishedAt) {
+			return a.PublishedAt.After(b.PublishedAt)
+		}
+	case plugin.SortName:
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+	case plugin.SortDownloads:
+		if a.Downloads != b.Downloads {
+			return a.Downloads > b.Downloads
+		}
+		if scoreA != scoreB {
+			return scoreA > scoreB
+		}
+	case plugin.SortUpdated:
+		if !a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.UpdatedAt.After(b.UpdatedAt)
+		}
+	default:
+		if scoreA != scoreB {
+			return scoreA > scoreB
+		}
+	}
+	return a.ID < b.ID
+}
+
+// memoryFacets summarizes search results by operating system, CPU architecture, license and
+// author.
+func memoryFacets(manifests []*plugin.Manifest) []*plugin.Facet {
+	counts := map[string]map[string]int{
+		archFacet:    make(map[string]int),
+		authorFacet:  make(map[string]int),
+		licenseFacet: make(map[string]int),
+		osFacet:      make(map[string]int),
+	}
+
+	for _, m := range manifests {
+		oses := make(map[string]bool)
+		archs := make(map[string]bool)
+		for _, p := range m.Packages {
+			oses[string(p.OS)] = true
+			archs[string(p.Arch)] = true
+		}
+
+		for os := range oses {
+			counts[osFacet][os]++
+		}
+		for arch := range archs {
+			counts[archFacet][arch]++
+		}
+		if m.License != "" {
+			counts[licenseFacet][m.License]++
+		}
+		if m.Author.Name != "" {
+			counts[authorFacet][m.Author.Name]++
+		}
+	}
+
+	res := make([]*plugin.Facet, 0, len(counts))
+	for name, terms := range counts {
+		facet := &plugin.Facet{Name: name, Terms: make([]plugin.FacetTerm, 0, len(terms))}
+		for term, n := range terms {
+			facet.Terms = append(facet.Terms, plugin.FacetTerm{Term: term, Count: n})
+		}
+
+		sort.Slice(facet.Terms, func(i, j int) bool {
+			if facet.Terms[i].Count != facet.Terms[j].Count {
+				return facet.Terms[i].Count > facet.Terms[j].Count
+			}
+			return facet.Terms[i].Term < facet.Terms[j].Term
+		})
+		res = append(res, facet)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Suggest completes plugin names starting with text, and corrects names within two typos of it.
+func (r *MemoryRepository) Suggest(ctx context.Context, text string, limit int) (*plugin.Suggestions, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	text = strings.ToLower(text)
+	distances := make(map[string]int)
+	res := &plugin.Suggestions{Completions: make([]string, 0), Corrections: make([]string, 0)}
+
+	for _, name := range r.names(false) {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, text) {
+			res.Completions = append(res.Completions, name)
+			continue
+		}
+
+		if text == "" || lower[0] != text[0] {
+			continue
+		}
+
+		if d := editDistance(text, lower); d <= 2 {
+			distances[name] = d
+			res.Corrections = append(res.Corrections, name)
+		}
+	}
+
+	sort.Strings(res.Completions)
+	sort.Slice(res.Corrections, func(i, j int) bool {
+		a, b := res.Corrections[i], res.Corrections[j]
+		if distances[a] != distances[b] {
+			return distances[a] < distances[b]
+		}
+		return a < b
+	})
+
+	if len(res.Completions) > limit {
+		res.Completions = res.Completions[:limit]
+	}
+	if len(res.Corrections) > limit {
+		res.Corrections = res.Corrections[:limit]
+	}
+	return res, nil
+}
+
+// Save stores a new plugin version.
+func (r *MemoryRepository) Save(ctx context.Context, p *plugin.Manifest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.manifests[p.ID]; ok {
+		return &plugin.VersionExistsError{Name: p.Name, Version: p.Version}
+	}
+
+	for _, m := range r.manifests {
+		if m.Name != p.Name && strings.EqualFold(m.Name, p.Name) {
+			return &plugin.NameTakenError{Name: p.Name, Taken: m.Name}
+		}
+	}
+	r.manifests[p.ID] = copyManifest(p)
+	return nil
+}
+
+// Update replaces existing plugin versions.
+func (r *MemoryRepository) Update(ctx context.Context, manifests ...*plugin.Manifest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range manifests {
+		if _, ok := r.manifests[p.ID]; !ok {
+			return &plugin.NotFoundError{Name: p.Name, Version: p.Version}
+		}
+	}
+
+	for _, p := range manifests {
+		downloads := r.manifests[p.ID].Downloads
+		r.manifests[p.ID] = copyManifest(p)
+		r.manifests[p.ID].Downloads = downloads
+	}
+	return nil
+}
+
+// AddDownloads adds to the download counts of plugin versions.
+func (r *MemoryRepository) AddDownloads(ctx context.Context, counts map[string]int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, n := range counts {
+		if m, ok := r.manifests[id]; ok {
+			m.Downloads += n
+		}
+	}
+	return nil
+}
+
+// Delete removes a plugin version.
+func (r *MemoryRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.manifests[id]; !ok {
+		return &plugin.NotFoundError{Name: id}
+	}
+	delete(r.manifests, id)
+	return nil
+}
+
+// Versions returns all the stored versions of a plugin.
+func (r *MemoryRepository) Versions(ctx context.Context, name string) ([]*plugin.Manifest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	manifests := make([]*plugin.Manifest, 0)
+	for _, m := range r.manifests {
+		if m.Name == name {
+			manifests = append(manifests, copyManifest(m))
+		}
+	}
+	return manifests, nil
+}
+
+// Get returns a specific plugin version.
+func (r *MemoryRepository) Get(ctx context.Context, name, version string) (*plugin.Manifest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.manifests[name+"@"+version]
+	if !ok {
+		return nil, &plugin.NotFoundError{Name: name, Version: version}
+	}
+	return copyManifest(m), nil
+}
+
+// Names returns the names of all stored plugins.
+func (r *MemoryRepository) Names(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.names(true), nil
+}
+
+// names returns the sorted names of all stored plugins, optionally including plugins whose
+// versions were all yanked.
+func (r *MemoryRepository) names(yanked bool) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, m := range r.manifests {
+		if !seen[m.Name] && (yanked || !m.Yanked) {
+			seen[m.Name] = true
+			names = append(names, m.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Ownership returns the ownership record of a plugin.
+func (r *MemoryRepository) Ownership(ctx context.Context, name string) (*plugin.Ownership, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, ok := r.owners[name]
+	if !ok {
+		return nil, &plugin.NotFoundError{Name: name}
+	}
+	return copyOwnership(o), nil
+}
+
+// SaveOwnership creates or replaces the ownership record of a plugin.
+func (r *MemoryRepository) SaveOwnership(ctx context.Context, o *plugin.Ownership) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.owners[o.Name] = copyOwnership(o)
+	return nil
+}
+
+// Session returns a publish session.
+func (r *MemoryRepository) Session(ctx context.Context, id string) (*plugin.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[id]
+	if !ok {
+		return nil, &plugin.SessionNotFoundError{ID: id}
+	}
+	return copySession(s), nil
+}
+
+// Sessions returns all publish sessions, sorted by ID.
+func (r *MemoryRepository) Sessions(ctx context.Context) ([]*plugin.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions := make([]*plugin.Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, copySession(s))
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, nil
+}
+
+// SaveSession creates or replaces a publish session.
+func (r *MemoryRepository) SaveSession(ctx context.Context, s *plugin.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessions[s.ID] = copySession(s)
+	return nil
+}
+
+// DeleteSession removes a publish session.
+func (r *MemoryRepository) DeleteSession(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.sessions[id]; !ok {
+		return &plugin.SessionNotFoundError{ID: id}
+	}
+	delete(r.sessions, id)
+	return nil
+}
+
+// copyManifest copies a manifest, so that callers cannot change stored manifests in place.
+func copyManifest(m *plugin.Manifest) *plugin.Manifest {
+	copied := *m
+	copied.Packages = make([]*plugin.Package, 0, len(m.Packages))
+	for _, p := range m.Packages {
+		pkg := *p
+		copied.Packages = append(copied.Packages, &pkg)
+	}
+
+	copied.Deprecation = copyDeprecation(m.Deprecation)
+	return &copied
+}
+
+func copyOwnership(o *plugin.Ownership) *plugin.Ownership {
+	copied := *o
+	copied.Maintainers = append([]string(nil), o.Maintainers...)
+	copied.Deprecation = copyDeprecation(o.Deprecation)
+	return &copied
+}
+
+func copySession(s *plugin.Session) *plugin.Session {
+	copied := *s
+	copied.Files = make([]*files.Object, 0, len(s.Files))
+	for _, f := range s.Files {
+		o := *f
+		o.Digests = make(map[string]string, len(f.Digests))
+		for algorithm, digest := range f.Digests {
+			o.Digests[algorithm] = digest
+		}
+		copied.Files = append(copied.Files, &o)
+	}
+	return &copied
+}
+
+// copyDeprecation copies a deprecation notice, so that stored records do not share it.
+func copyDeprecation(d *plugin.Deprecation) *plugin.Deprecation {
+	if d == nil {
+		return nil
+	}
+
+	copied := *d
+	return &copied
+}
+
+// editDistance returns the number of insertions, deletions, substitutions or transpositions
+// of adjacent characters needed to turn a into b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
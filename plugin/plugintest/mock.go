@@ -0,0 +1,162 @@
+// Package plugintest provides an in-memory plugin repository and a conformance suite for
+// testing plugin.Repository implementations, as well as code built on top of them, such
+// as plugin.Service.
+package plugintest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hooklift/lift-registry/plugin"
+)
+
+// MockRepository is a MemoryRepository that can be made to fail, and that records the
+// queries it receives.
+type MockRepository struct {
+	mu   sync.Mutex
+	repo *MemoryRepository
+
+	// Err, if set, is returned by every method, to test how failures are handled.
+	Err error
+	// Queries records every query received by Search.
+	Queries []*plugin.SearchQuery
+}
+
+// NewMockRepository returns an empty in-memory repository, optionally holding the given
+// plugin versions. Manifests missing an ID get one assigned.
+func NewMockRepository(manifests ...*plugin.Manifest) *MockRepository {
+	return &MockRepository{repo: NewMemoryRepository(manifests...)}
+}
+
+// err returns the error every method should fail with, if any.
+func (r *MockRepository) err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Err
+}
+
+// Search finds plugin versions whose name, description or author contains any of the
+// words in the query text.
+func (r *MockRepository) Search(ctx context.Context, q *plugin.SearchQuery) (*plugin.SearchResult, error) {
+	r.mu.Lock()
+	r.Queries = append(r.Queries, q)
+	err := r.Err
+	r.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	return r.repo.Search(ctx, q)
+}
+
+// Suggest completes plugin names starting with text, and corrects names within two typos of it.
+func (r *MockRepository) Suggest(ctx context.Context, text string, limit int) (*plugin.Suggestions, error) {
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return r.repo.Suggest(ctx, text, limit)
+}
+
+// Save stores a new plugin version.
+func (r *MockRepository) Save(ctx context.Context, p *plugin.Manifest) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+	return r.repo.Save(ctx, p)
+}
+
+// Update replaces existing plugin versions.
+func (r *MockRepository) Update(ctx context.Context, manifests ...*plugin.Manifest) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+	return r.repo.Update(ctx, manifests...)
+}
+
+// AddDownloads adds to the download counts of plugin versions.
+func (r *MockRepository) AddDownloads(ctx context.Context, counts map[string]int64) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+	return r.repo.AddDownloads(ctx, counts)
+}
+
+// Delete removes a plugin version.
+func (r *MockRepository) Delete(ctx context.Context, id string) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+	return r.repo.Delete(ctx, id)
+}
+
+// Versions returns all the stored versions of a plugin.
+func (r *MockRepository) Versions(ctx context.Context, name string) ([]*plugin.Manifest, error) {
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return r.repo.Versions(ctx, name)
+}
+
+// Get returns a specific plugin version.
+func (r *MockRepository) Get(ctx context.Context, name, version string) (*plugin.Manifest, error) {
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return r.repo.Get(ctx, name, version)
+}
+
+// Names returns the names of all stored plugins.
+func (r *MockRepository) Names(ctx context.Context) ([]string, error) {
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return r.repo.Names(ctx)
+}
+
+// Ownership returns the ownership record of a plugin.
+func (r *MockRepository) Ownership(ctx context.Context, name string) (*plugin.Ownership, error) {
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return r.repo.Ownership(ctx, name)
+}
+
+// SaveOwnership creates or replaces the ownership record of a plugin.
+func (r *MockRepository) SaveOwnership(ctx context.Context, o *plugin.Ownership) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+	return r.repo.SaveOwnership(ctx, o)
+}
+
+// Session returns a publish session.
+func (r *MockRepository) Session(ctx context.Context, id string) (*plugin.Session, error) {
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return r.repo.Session(ctx, id)
+}
+
+// Sessions returns all publish sessions.
+func (r *MockRepository) Sessions(ctx context.Context) ([]*plugin.Session, error) {
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return r.repo.Sessions(ctx)
+}
+
+// SaveSession creates or replaces a publish session.
+func (r *MockRepository) SaveSession(ctx context.Context, s *plugin.Session) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+	return r.repo.SaveSession(ctx, s)
+}
+
+// DeleteSession removes a publish session.
+func (r *MockRepository) DeleteSession(ctx context.Context, id string) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+	return r.repo.DeleteSession(ctx, id)
+}
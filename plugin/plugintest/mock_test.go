@@ -0,0 +1,13 @@
+package plugintest
+
+import (
+	"testing"
+
+	"github.com/hooklift/lift-registry/plugin"
+)
+
+func TestMockRepository(t *testing.T) {
+	RunRepositoryTests(t, func(t *testing.T) plugin.Repository {
+		return NewMockRepository()
+	})
+}
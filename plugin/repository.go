@@ -0,0 +1,23 @@
+package plugin
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/config"
+)
+
+// NewRepository returns the repository selected by config.RepositoryDriver.
+func NewRepository() (Repository, error) {
+	switch config.RepositoryDriver {
+	case "sqlite":
+		return NewSQLiteRepository(config.SQLiteFile)
+	case "bleve":
+		index, err := OpenIndex(config.IndexFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewBleveRepository(index), nil
+	default:
+		return nil, errors.Errorf("unsupported repository driver %q", config.RepositoryDriver)
+	}
+}
@@ -0,0 +1,25 @@
+package plugin_test
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve"
+
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+func TestBleveRepositoryConformance(t *testing.T) {
+	plugintest.RunRepositoryTests(t, func(t *testing.T) plugin.Repository {
+		im, err := plugin.NewIndexMapping()
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		index, err := bleve.NewMemOnly(im)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		return plugin.NewBleveRepository(index)
+	})
+}
@@ -0,0 +1,25 @@
+// +build sqlite
+
+package plugin_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+func TestSQLiteRepositoryConformance(t *testing.T) {
+	plugintest.RunRepositoryTests(t, func(t *testing.T) plugin.Repository {
+		repo, err := plugin.NewSQLiteRepository(":memory:")
+		if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+			t.Skip("SQLite was built without FTS5, use -tags sqlite_fts5")
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		return repo
+	})
+}
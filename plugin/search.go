@@ -0,0 +1,201 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Facets computed for every search, keyed by the name they are returned under.
+const (
+	osFacet      = "os"
+	archFacet    = "arch"
+	licenseFacet = "license"
+	authorFacet  = "author"
+)
+
+// Search results per page.
+const (
+	defaultResultsPerPage = 10
+	maxResultsPerPage     = 50
+)
+
+// SortOrder is the order in which search results are returned.
+type SortOrder string
+
+// Supported sort orders.
+const (
+	// SortRelevance returns the best matches first.
+	SortRelevance SortOrder = "relevance"
+	// SortNewest returns the most recently published versions first.
+	SortNewest SortOrder = "newest"
+	// SortName returns plugins in alphabetical order.
+	SortName SortOrder = "name"
+	// SortDownloads returns the most downloaded versions first.
+	SortDownloads SortOrder = "downloads"
+	// SortUpdated returns the most recently updated versions first.
+	SortUpdated SortOrder = "updated"
+)
+
+// sortOrders is the list of supported sort orders.
+var sortOrders = map[SortOrder]bool{
+	SortRelevance: true,
+	SortNewest:    true,
+	SortName:      true,
+	SortDownloads: true,
+	SortUpdated:   true,
+}
+
+// SearchQuery describes the plugins to search for. Filters are optional and combined
+// with each other.
+type SearchQuery struct {
+	// Text is matched against plugin names, descriptions and authors.
+	Text string
+	// OS only returns plugins with a package built for this operating system.
+	OS OS
+	// Arch only returns plugins with a package built for this CPU architecture.
+	Arch Arch
+	// License only returns plugins published under this license.
+	License string
+	// Author only returns plugins developed by this author, either by name or email.
+	Author string
+	// PublishedAfter only returns plugin versions published after this time.
+	PublishedAfter time.Time
+	// Sort is the order of results, SortRelevance by default.
+	Sort SortOrder
+	// PageNumber is the page of results to return, starting at 1.
+	PageNumber int
+	// ResultsPerPage is the maximum number of plugins returned.
+	ResultsPerPage int
+	// PageToken continues a search right after the last result of a previous page, as
+	// returned in SearchResult.NextPageToken. Unlike page numbers, tokens keep pages stable
+	// while new plugins are published. Results tied on the sort order are ordered by
+	// relevance score and then by document ID, so tokens resume at a well defined result
+	// whatever the sort order.
+	PageToken string
+}
+
+// FacetTerm is the number of search results having a given value in a faceted field.
+type FacetTerm struct {
+	Term  string
+	Count int
+}
+
+// Facet summarizes the values of a field across all search results, i.e. how many plugins
+// match the search for each operating system.
+type Facet struct {
+	Name  string
+	Terms []FacetTerm
+}
+
+// SearchResult is a page of plugins matching a search, along with the facets of all matches.
+type SearchResult struct {
+	Manifests []*Manifest
+	Facets    []*Facet
+	// Total is the number of plugins matching the search, across all pages.
+	Total int
+	// Took is how long the search took.
+	Took time.Duration
+	// NextPageToken gets the next page of results. It is empty on the last page.
+	NextPageToken string
+}
+
+// Search finds plugin versions matching the given query.
+func Search(ctx context.Context, q *SearchQuery) (*SearchResult, error) {
+	if q == nil {
+		return nil, errors.New("search query is required")
+	}
+
+	verr := new(ValidationError)
+	if q.OS != "" && !oses[q.OS] {
+		verr.Add("os", fmt.Sprintf("unsupported operating system %q, it must be one of windows, macOS, freebsd or linux", q.OS))
+	}
+
+	if q.Arch != "" && !archs[q.Arch] {
+		verr.Add("arch", fmt.Sprintf("unsupported CPU architecture %q, it must be one of x86, x64, arm or arm64", q.Arch))
+	}
+
+	if q.Sort != "" && !sortOrders[q.Sort] {
+		verr.Add("sort", fmt.Sprintf("unsupported sort order %q, it must be one of relevance, newest, name, downloads or updated", q.Sort))
+	}
+
+	if q.PageNumber < 0 {
+		verr.Add("page_number", "page number must be a positive number")
+	}
+
+	if q.PageNumber > 1 && q.PageToken != "" {
+		verr.Add("page_number", "page number cannot be combined with a page token")
+	}
+
+	sortOrder := q.Sort
+	if sortOrder == "" {
+		sortOrder = SortRelevance
+	}
+
+	if q.ResultsPerPage < 0 {
+		verr.Add("results_per_page", "results per page must be a positive number")
+	}
+
+	if err := verr.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+
+	query := *q
+	query.Sort = sortOrder
+
+	if query.PageNumber == 0 {
+		query.PageNumber = 1
+	}
+	if query.ResultsPerPage == 0 {
+		query.ResultsPerPage = defaultResultsPerPage
+	}
+
+	if query.ResultsPerPage > maxResultsPerPage {
+		query.ResultsPerPage = maxResultsPerPage
+	}
+
+	return Repo.Search(ctx, &query)
+}
+
+// Suggestions returned per request.
+const (
+	defaultSuggestions = 5
+	maxSuggestions     = 20
+)
+
+// Suggestions are plugin names suggested while users type a search.
+type Suggestions struct {
+	// Completions are plugin names starting with the text typed so far.
+	Completions []string
+	// Corrections are plugin names close to the text typed, in case it was misspelled.
+	Corrections []string
+}
+
+// Suggest returns plugin name completions and corrections for the given text.
+func Suggest(ctx context.Context, text string, limit int) (*Suggestions, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		verr := new(ValidationError)
+		verr.Add("text", "text is required")
+		return nil, verr
+	}
+
+	if limit < 0 {
+		verr := new(ValidationError)
+		verr.Add("limit", "limit must be a positive number")
+		return nil, verr
+	}
+
+	if limit == 0 {
+		limit = defaultSuggestions
+	}
+
+	if limit > maxSuggestions {
+		limit = maxSuggestions
+	}
+
+	return Repo.Suggest(ctx, text, limit)
+}
@@ -0,0 +1,140 @@
+package plugin_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		desc           string
+		query          plugin.SearchQuery
+		resultsPerPage int
+		fields         []string
+	}{
+		{"defaults results per page", plugin.SearchQuery{Text: "lint"}, plugin.DefaultResultsPerPage, nil},
+		{"caps results per page", plugin.SearchQuery{ResultsPerPage: 500}, plugin.MaxResultsPerPage, nil},
+		{"filters", plugin.SearchQuery{OS: "macOS", Arch: "arm64", License: "MIT", ResultsPerPage: 5}, 5, nil},
+		{"invalid filters", plugin.SearchQuery{OS: "darwin", Arch: "amd64", ResultsPerPage: -1}, 0, []string{"arch", "os", "results_per_page"}},
+		{"sort order", plugin.SearchQuery{Sort: plugin.SortDownloads}, plugin.DefaultResultsPerPage, nil},
+		{"invalid sort order", plugin.SearchQuery{Sort: "stars"}, 0, []string{"sort"}},
+		{"negative page", plugin.SearchQuery{PageNumber: -1}, 0, []string{"page_number"}},
+		{"page number with page token", plugin.SearchQuery{Sort: plugin.SortName, PageNumber: 2, PageToken: "abc"}, 0, []string{"page_number"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			repo := plugintest.NewMockRepository()
+			plugin.Repo = repo
+
+			_, err := plugin.Search(context.Background(), &tt.query)
+			if tt.fields != nil {
+				verr, ok := errors.Cause(err).(*plugin.ValidationError)
+				if !ok {
+					t.Fatalf("expected *ValidationError, got %T: %v", err, err)
+				}
+
+				fields := make([]string, 0, len(verr.Fields))
+				for _, f := range verr.Fields {
+					fields = append(fields, f.Field)
+				}
+				sort.Strings(fields)
+
+				if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+					t.Errorf("expected violations on %v, got %v", tt.fields, fields)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			q := repo.Queries[0]
+			if tt.query.PageNumber == 0 && q.PageNumber != 1 {
+				t.Errorf("expected page number to default to 1, got %d", q.PageNumber)
+			}
+
+			if sort := tt.query.Sort; sort == "" && q.Sort != plugin.SortRelevance || sort != "" && q.Sort != sort {
+				t.Errorf("expected sort order %q to default to relevance, got %q", sort, q.Sort)
+			}
+
+			if q.ResultsPerPage != tt.resultsPerPage {
+				t.Errorf("expected %d results per page, got %d", tt.resultsPerPage, q.ResultsPerPage)
+			}
+
+			if q.Text != tt.query.Text || q.OS != tt.query.OS || q.Arch != tt.query.Arch || q.License != tt.query.License {
+				t.Errorf("expected query %+v to be passed down to the repository, got %+v", tt.query, q)
+			}
+		})
+	}
+}
+
+func TestSearchPageTokens(t *testing.T) {
+	plugin.Repo = plugintest.NewMemoryRepository(
+		&plugin.Manifest{Name: "lint", Version: "1.0.0"},
+		&plugin.Manifest{Name: "fmt", Version: "1.0.0"},
+		&plugin.Manifest{Name: "vet", Version: "1.0.0"},
+	)
+
+	for order := range plugin.SortOrders {
+		seen := make(map[string]bool)
+		q := &plugin.SearchQuery{Sort: order, ResultsPerPage: 1}
+		for {
+			res, err := plugin.Search(context.Background(), q)
+			if err != nil {
+				t.Fatalf("unexpected error sorting by %s: %+v", order, err)
+			}
+
+			for _, m := range res.Manifests {
+				if seen[m.Name] {
+					t.Errorf("expected %s to be returned once sorting by %s", m.Name, order)
+				}
+				seen[m.Name] = true
+			}
+
+			if res.NextPageToken == "" {
+				break
+			}
+			q.PageToken = res.NextPageToken
+		}
+
+		if len(seen) != 3 {
+			t.Errorf("expected page tokens to get all 3 plugins sorting by %s, got %d", order, len(seen))
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	plugin.Repo = plugintest.NewMemoryRepository(
+		&plugin.Manifest{Name: "lint", Version: "1.0.0"},
+		&plugin.Manifest{Name: "fmt", Version: "1.0.0"},
+	)
+	ctx := context.Background()
+
+	for _, text := range []string{"", "   "} {
+		if _, err := plugin.Suggest(ctx, text, 0); err == nil {
+			t.Errorf("expected empty text %q to fail", text)
+		}
+	}
+
+	if _, err := plugin.Suggest(ctx, "li", -1); err == nil {
+		t.Error("expected negative limit to fail")
+	}
+
+	s, err := plugin.Suggest(ctx, " li ", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(s.Completions) != 1 || s.Completions[0] != "lint" {
+		t.Errorf("expected lint to be suggested, got %v", s.Completions)
+	}
+}
@@ -0,0 +1,168 @@
+package plugin_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	api "github.com/hooklift/apis/go/lift"
+	"github.com/hooklift/lift-registry/pkg/auth"
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+func TestServiceSearch(t *testing.T) {
+	published := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	repo := plugintest.NewMockRepository(
+		&plugin.Manifest{Name: "lint", Version: "1.0.0", Description: "Finds style mistakes", License: "MIT", PublishedAt: published, Downloads: 3},
+		&plugin.Manifest{Name: "lint", Version: "1.1.0", Description: "Finds style mistakes", License: "MIT", PublishedAt: published, Yanked: true},
+		&plugin.Manifest{Name: "format", Version: "1.0.0", Description: "Formats source code", License: "MIT", PublishedAt: published},
+	)
+	plugin.Repo = repo
+
+	res, err := new(plugin.Service).Search(context.Background(), &api.SearchRequest{
+		Query:   "style",
+		License: "MIT",
+		Sort:    string(plugin.SortDownloads),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(res.Plugins) != 1 || res.Plugins[0].Name != "lint" || res.Plugins[0].Version != "1.0.0" {
+		t.Fatalf("expected lint 1.0.0, got %+v", res.Plugins)
+	}
+
+	if res.Total != 1 || res.Plugins[0].Downloads != 3 {
+		t.Errorf("expected a total of 1 and 3 downloads, got %d and %d", res.Total, res.Plugins[0].Downloads)
+	}
+
+	q := repo.Queries[0]
+	if q.Text != "style" || q.License != "MIT" || q.Sort != plugin.SortDownloads || q.PageNumber != 1 {
+		t.Errorf("unexpected query received by repository: %+v", q)
+	}
+
+	_, err = new(plugin.Service).Search(context.Background(), &api.SearchRequest{Sort: "stars"})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("expected code %s, got %s: %v", codes.InvalidArgument, code, err)
+	}
+}
+
+func TestServiceErrors(t *testing.T) {
+	failure := errors.New("disk on fire")
+
+	tests := []struct {
+		desc string
+		err  error
+		call func(s *plugin.Service) error
+		code codes.Code
+	}{
+		{"missing plugin", nil, func(s *plugin.Service) error {
+			_, err := s.Get(context.Background(), &api.GetRequest{Name: "vet"})
+			return err
+		}, codes.NotFound},
+		{"missing version", nil, func(s *plugin.Service) error {
+			_, err := s.Get(context.Background(), &api.GetRequest{Name: "lint", Version: "2.0.0"})
+			return err
+		}, codes.NotFound},
+		{"invalid version", nil, func(s *plugin.Service) error {
+			_, err := s.Get(context.Background(), &api.GetRequest{Name: "lint", Version: "next"})
+			return err
+		}, codes.InvalidArgument},
+		{"repository failure", failure, func(s *plugin.Service) error {
+			_, err := s.Get(context.Background(), &api.GetRequest{Name: "lint"})
+			return err
+		}, codes.Unknown},
+		{"repository failure on search", failure, func(s *plugin.Service) error {
+			_, err := s.Search(context.Background(), &api.SearchRequest{Query: "lint"})
+			return err
+		}, codes.Unknown},
+		{"non-maintainer yanks", nil, func(s *plugin.Service) error {
+			ctx := auth.NewContext(context.Background(), &auth.Account{ID: "acc2", Scopes: map[string]bool{"write": true}})
+			_, err := s.Yank(ctx, &api.YankRequest{Name: "lint", Version: "1.0.0"})
+			return err
+		}, codes.PermissionDenied},
+		{"unauthenticated yank", nil, func(s *plugin.Service) error {
+			_, err := s.Yank(context.Background(), &api.YankRequest{Name: "lint", Version: "1.0.0"})
+			return err
+		}, codes.Unauthenticated},
+		{"found", nil, func(s *plugin.Service) error {
+			_, err := s.Get(context.Background(), &api.GetRequest{Name: "lint"})
+			return err
+		}, codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			repo := plugintest.NewMockRepository(&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.0.0"})
+			repo.Err = tt.err
+			plugin.Repo = repo
+
+			err := tt.call(new(plugin.Service))
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("expected code %s, got %s: %v", tt.code, code, err)
+			}
+		})
+	}
+}
+
+func TestServiceOwnership(t *testing.T) {
+	repo := plugintest.NewMockRepository(&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.0.0"})
+	plugin.Repo = repo
+
+	as := func(accountID string) context.Context {
+		return auth.NewContext(context.Background(), &auth.Account{ID: accountID, Scopes: map[string]bool{"write": true}})
+	}
+
+	checkCode := func(err error, code codes.Code) {
+		t.Helper()
+		if c := status.Code(err); c != code {
+			t.Fatalf("expected code %s, got %s: %v", code, c, err)
+		}
+	}
+
+	checkOwnership := func(ownerID string, maintainers ...string) {
+		t.Helper()
+		o, err := new(plugin.Service).GetOwnership(context.Background(), &api.GetOwnershipRequest{Name: "lint"})
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if o.OwnerId != ownerID || strings.Join(o.Maintainers, ",") != strings.Join(maintainers, ",") {
+			t.Errorf("expected owner %s and maintainers %v, got %+v", ownerID, maintainers, o)
+		}
+	}
+
+	s := new(plugin.Service)
+	_, err := s.AddMaintainer(as("acc2"), &api.AddMaintainerRequest{Name: "lint", AccountId: "acc3"})
+	checkCode(err, codes.PermissionDenied)
+
+	_, err = s.Yank(as("acc3"), &api.YankRequest{Name: "lint", Version: "1.0.0", Reason: "broken"})
+	checkCode(err, codes.PermissionDenied)
+
+	_, err = s.AddMaintainer(as("acc1"), &api.AddMaintainerRequest{Name: "lint", AccountId: "acc3"})
+	checkCode(err, codes.OK)
+	checkOwnership("acc1", "acc3")
+
+	_, err = s.Yank(as("acc3"), &api.YankRequest{Name: "lint", Version: "1.0.0", Reason: "broken"})
+	checkCode(err, codes.OK)
+
+	if m, err := repo.Get(context.Background(), "lint", "1.0.0"); err != nil || !m.Yanked {
+		t.Errorf("expected version to be yanked by maintainer, got %+v (%v)", m, err)
+	}
+
+	_, err = s.TransferOwnership(as("acc1"), &api.TransferOwnershipRequest{Name: "lint", AccountId: "acc3"})
+	checkCode(err, codes.OK)
+
+	_, err = s.AcceptOwnership(as("acc2"), &api.AcceptOwnershipRequest{Name: "lint"})
+	checkCode(err, codes.PermissionDenied)
+
+	_, err = s.AcceptOwnership(as("acc3"), &api.AcceptOwnershipRequest{Name: "lint"})
+	checkCode(err, codes.OK)
+	checkOwnership("acc3")
+}
@@ -0,0 +1,550 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	version "github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/files"
+)
+
+// sessionTTL is how long publish sessions stay open. Sessions not finalized by then are
+// considered abandoned, and are garbage collected along with the files uploaded to them.
+const sessionTTL = 24 * time.Hour
+
+// sessionMu serializes changes to publish sessions. Publishing, with or without a session,
+// holds it while the version is stored, so that publishes of the same version never race
+// each other.
+var sessionMu sync.Mutex
+
+// finalizing holds the document IDs of the plugin versions being published through a session.
+// Packages are moved into place without holding sessionMu, so publishing the same version from
+// elsewhere meanwhile is refused, rather than having packages moved from under each other. It
+// is guarded by sessionMu.
+var finalizing = make(map[string]bool)
+
+// Session is a publish session. Publishers open a session for a plugin version, upload its
+// packages into the session and finalize it with the plugin manifest. Uploaded packages are
+// kept in a staging area, and only become visible once the session is finalized.
+type Session struct {
+	// ID is the random, unguessable identifier of the session.
+	ID string `json:"id"`
+	// AccountID is the account that opened the session. Only it can use the session.
+	AccountID string `json:"account_id"`
+	// Name is the name of the plugin being published.
+	Name string `json:"name"`
+	// Version is the normalized version number being published.
+	Version string `json:"version"`
+	// Files lists the files uploaded to the staging area of the session so far.
+	Files []*files.Object `json:"files"`
+	// CreatedAt is the time when the session was opened.
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is the time after which the session can no longer be used.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired returns whether the session can no longer be used.
+func (s *Session) Expired() bool {
+	return !time.Now().Before(s.ExpiresAt)
+}
+
+// OpenSession opens a publish session for a new version of a plugin, on behalf of an account
+// allowed to publish it. The plugin name is checked as when publishing, so that uploads bound
+// to be refused are never made.
+func OpenSession(ctx context.Context, accountID, name, pluginVersion string) (*Session, error) {
+	if accountID == "" {
+		return nil, errors.New("account ID is required")
+	}
+
+	verr := new(ValidationError)
+	validateName(verr, name)
+
+	ver, err := version.NewVersion(pluginVersion)
+	if err != nil {
+		verr.Add("version", fmt.Sprintf("%q is not a valid version number", pluginVersion))
+	}
+
+	if err := verr.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+
+	// Permissions and the name policy are checked again when finalizing, since both can change
+	// while the session is open.
+	o, err := GetOwnership(ctx, name)
+	if _, ok := errors.Cause(err).(*NotFoundError); !ok {
+		if err != nil {
+			return nil, err
+		}
+
+		if !o.CanPublish(accountID) {
+			return nil, &PermissionDeniedError{AccountID: accountID, Action: "publish", Name: name}
+		}
+	}
+
+	_, err = Repo.Get(ctx, name, ver.String())
+	if err == nil {
+		return nil, &VersionExistsError{Name: name, Version: ver.String()}
+	}
+
+	if _, ok := errors.Cause(err).(*NotFoundError); !ok {
+		return nil, err
+	}
+
+	if err := checkNamePolicy(ctx, &Manifest{Name: name, AccountID: accountID}); err != nil {
+		return nil, err
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s := &Session{
+		ID:        id,
+		AccountID: accountID,
+		Name:      name,
+		Version:   ver.String(),
+		Files:     make([]*files.Object, 0),
+		CreatedAt: now,
+		ExpiresAt: now.Add(sessionTTL),
+	}
+
+	if err := Repo.SaveSession(ctx, s); err != nil {
+		return nil, errors.Wrapf(err, "failed opening publish session for %q", manifestID(name, s.Version))
+	}
+
+	glog.Infof("account %q opened publish session %q for %q", accountID, id, manifestID(name, s.Version))
+	return s, nil
+}
+
+// newSessionID returns a random session ID.
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed generating session ID")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// getSession returns an open session owned by the given account.
+func getSession(ctx context.Context, id, accountID string) (*Session, error) {
+	s, err := Repo.Session(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Expired() {
+		return nil, &SessionNotFoundError{ID: id}
+	}
+
+	if s.AccountID != accountID {
+		return nil, &PermissionDeniedError{AccountID: accountID, Action: "use the publish session of", Name: s.Name}
+	}
+
+	return s, nil
+}
+
+// UploadSessionFiles stores every file part found in reader in the staging area of a publish
+// session. Files uploaded again under the same name replace the previous upload.
+func UploadSessionFiles(ctx context.Context, id, accountID string, reader *multipart.Reader) (*Session, error) {
+	if _, err := getSession(ctx, id, accountID); err != nil {
+		return nil, err
+	}
+
+	uploadID, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, err := files.StagingPrefix(id, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Uploads are streamed without holding the lock, since they can take a while.
+	objects, err := Storage.Upload(ctx, prefix, reader)
+	if err != nil {
+		deleteStagedPrefix(ctx, prefix)
+		return nil, err
+	}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	// The session may have been finalized or collected in the meantime.
+	s, err := getSession(ctx, id, accountID)
+	if err != nil {
+		deleteStagedPrefix(ctx, prefix)
+		return nil, err
+	}
+
+	replaced := make([]*files.Object, 0)
+	for _, o := range objects {
+		if i := s.stagedFile(path.Base(o.Key)); i >= 0 {
+			replaced = append(replaced, s.Files[i])
+			s.Files[i] = o
+			continue
+		}
+		s.Files = append(s.Files, o)
+	}
+
+	if err := Repo.SaveSession(ctx, s); err != nil {
+		deleteStagedPrefix(ctx, prefix)
+		return nil, errors.Wrapf(err, "failed updating publish session %q", id)
+	}
+
+	deleteStagedFiles(ctx, replaced)
+	return s, nil
+}
+
+// stagedFile returns the index of the file uploaded to the session under the given name, or
+// -1 if there is none.
+func (s *Session) stagedFile(name string) int {
+	for i, f := range s.Files {
+		if path.Base(f.Key) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// statStaged returns the package files uploaded to a publish session.
+func statStaged(ctx context.Context, s *Session) func(pkg *Package) (*files.Object, error) {
+	return func(pkg *Package) (*files.Object, error) {
+		i := s.stagedFile(pkg.Name)
+		if i < 0 {
+			return nil, errors.Wrapf(files.ErrNotFound, "package %q was not uploaded to publish session %q", pkg.Name, s.ID)
+		}
+		return Storage.Stat(ctx, s.Files[i].Key)
+	}
+}
+
+// FinalizeSession publishes the plugin version a session was opened for. Every package listed
+// in the manifest must have been uploaded to the session, with matching checksums. Packages are
+// then moved out of the staging area, and the version becomes visible at once when its manifest
+// is stored. If anything fails the session is left as it was, so that it can be finalized again.
+func FinalizeSession(ctx context.Context, id, accountID string, p *Manifest) error {
+	if p == nil {
+		return errors.New("a valid manifest is required")
+	}
+
+	s, err := getSession(ctx, id, accountID)
+	if err != nil {
+		return err
+	}
+
+	verr := new(ValidationError)
+	if p.Name != "" && p.Name != s.Name {
+		verr.Add("name", fmt.Sprintf("publish session %q is for plugin %q", id, s.Name))
+	}
+
+	if ver, err := version.NewVersion(p.Version); p.Version != "" && (err != nil || ver.String() != s.Version) {
+		verr.Add("version", fmt.Sprintf("publish session %q is for version %s", id, s.Version))
+	}
+
+	if err := verr.ErrorOrNil(); err != nil {
+		return err
+	}
+
+	p.Name = s.Name
+	p.Version = s.Version
+	p.AccountID = s.AccountID
+
+	if err := prepareManifest(ctx, p); err != nil {
+		return err
+	}
+
+	// Packages are verified and moved without holding sessionMu, since rehashing and moving
+	// them can take a while.
+	if err := verifyPackages(p, statStaged(ctx, s)); err != nil {
+		return err
+	}
+
+	if err := startFinalizing(p); err != nil {
+		return err
+	}
+	defer stopFinalizing(p)
+
+	// Packages of an already published version are never moved onto, but checking first
+	// reports the right error.
+	_, err = Repo.Get(ctx, p.Name, p.Version)
+	if err == nil {
+		return &VersionExistsError{Name: p.Name, Version: p.Version}
+	}
+
+	if _, ok := errors.Cause(err).(*NotFoundError); !ok {
+		return err
+	}
+
+	moved, err := moveSessionPackages(ctx, s, p)
+	if err != nil {
+		return err
+	}
+
+	// Packages are checked again once moved, so that what gets published is exactly what the
+	// manifest declares, whatever happened to the staging area in the meantime.
+	if err := verifyPackages(p, statPublished(ctx, p)); err != nil {
+		restoreSessionPackages(ctx, moved)
+		return err
+	}
+
+	if err := publishSession(ctx, s, p); err != nil {
+		restoreSessionPackages(ctx, moved)
+		return err
+	}
+
+	glog.Infof("account %q published %q through session %q", accountID, p.ID, id)
+
+	// The version is published at this point, leftovers are collected later if cleaning up fails.
+	if prefix, err := files.SessionPrefix(id); err == nil {
+		deleteStagedPrefix(ctx, prefix)
+	}
+
+	return nil
+}
+
+// startFinalizing marks a plugin version as being published through a session. It fails if the
+// version is already being published through another session.
+func startFinalizing(p *Manifest) error {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	id := manifestID(p.Name, p.Version)
+	if finalizing[id] {
+		return &PublishInProgressError{Name: p.Name, Version: p.Version}
+	}
+
+	finalizing[id] = true
+	return nil
+}
+
+// stopFinalizing marks a plugin version as no longer being published through a session.
+func stopFinalizing(p *Manifest) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	delete(finalizing, manifestID(p.Name, p.Version))
+}
+
+// publishSession stores the manifest of a version whose packages were moved out of session s,
+// as saveManifest does, and closes the session.
+func publishSession(ctx context.Context, s *Session, p *Manifest) error {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	// The session may have expired, and been collected, while packages were being moved.
+	if _, err := getSession(ctx, s.ID, s.AccountID); err != nil {
+		return err
+	}
+
+	// Only the account owning the plugin name, or its maintainers, can publish new versions.
+	err := claimName(ctx, p.Name, p.AccountID, func(o *Ownership) error {
+		return saveManifest(ctx, p, o)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := Repo.DeleteSession(ctx, s.ID); err != nil {
+		glog.Errorf("failed closing publish session %q: %+v", s.ID, err)
+	}
+
+	return nil
+}
+
+// move is a file moved from one storage key to another.
+type move struct {
+	from, to string
+}
+
+// moveSessionPackages moves the packages of a manifest from the staging area of a session to
+// the namespace they are downloaded from. Packages already moved are moved back on failure.
+// Files already stored there, such as packages uploaded outside of a session, are published
+// as they are if their content is the same as the staged packages, or fail with a
+// *PackageExistsError otherwise, since stored files are never replaced.
+func moveSessionPackages(ctx context.Context, s *Session, p *Manifest) ([]move, error) {
+	moved := make([]move, 0, len(p.Packages))
+	for _, pkg := range p.Packages {
+		i := s.stagedFile(pkg.Name)
+		if i < 0 {
+			restoreSessionPackages(ctx, moved)
+			return nil, errors.Errorf("package %q was not uploaded to publish session %q", pkg.Name, s.ID)
+		}
+
+		from := s.Files[i].Key
+		to, err := files.Key(p.AccountID, p.Name, p.Version, pkg.Name)
+		if err != nil {
+			restoreSessionPackages(ctx, moved)
+			return nil, errors.Wrapf(err, "invalid package %q", pkg.Name)
+		}
+
+		err = Storage.Move(ctx, from, to)
+		if errors.Cause(err) == files.ErrExists {
+			err = checkStoredPackage(ctx, p, pkg.Name, s.Files[i], to)
+			if err == nil {
+				continue
+			}
+		}
+
+		if err != nil {
+			restoreSessionPackages(ctx, moved)
+			return nil, errors.Wrapf(err, "failed publishing package %q", pkg.Name)
+		}
+
+		moved = append(moved, move{from: from, to: to})
+	}
+
+	return moved, nil
+}
+
+// checkStoredPackage makes sure the file stored under key, where a package staged in a session
+// is about to be published, has the same content as the staged file.
+func checkStoredPackage(ctx context.Context, p *Manifest, name string, staged *files.Object, key string) error {
+	stored, err := Storage.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	matches := 0
+	for algorithm, digest := range staged.Digests {
+		if d, ok := stored.Digests[algorithm]; ok {
+			if !strings.EqualFold(d, digest) {
+				return &PackageExistsError{Name: p.Name, Version: p.Version, Package: name}
+			}
+			matches++
+		}
+	}
+
+	if matches == 0 {
+		return &PackageExistsError{Name: p.Name, Version: p.Version, Package: name}
+	}
+
+	glog.Infof("publishing package %q of %q as already stored", name, manifestID(p.Name, p.Version))
+	return nil
+}
+
+// restoreSessionPackages moves packages back into the staging area of their session.
+func restoreSessionPackages(ctx context.Context, moved []move) {
+	for _, m := range moved {
+		if err := Storage.Move(ctx, m.to, m.from); err != nil {
+			glog.Errorf("failed moving %q back to %q: %+v", m.to, m.from, err)
+		}
+	}
+}
+
+// deleteStagedFiles removes files from the staging area of a session.
+func deleteStagedFiles(ctx context.Context, objects []*files.Object) {
+	for _, o := range objects {
+		err := Storage.Delete(ctx, o.Key)
+		if err != nil && errors.Cause(err) != files.ErrNotFound {
+			glog.Errorf("failed deleting staged file %q: %+v", o.Key, err)
+		}
+	}
+}
+
+// deleteStagedPrefix removes every file staged under a prefix. Files left behind if it fails
+// are collected later on.
+func deleteStagedPrefix(ctx context.Context, prefix string) {
+	if err := Storage.DeletePrefix(ctx, prefix); err != nil {
+		glog.Errorf("failed deleting staged files under %q: %+v", prefix, err)
+	}
+}
+
+// CollectSessions removes expired publish sessions, along with every file staged for them.
+// Staged files of sessions that no longer exist, such as files uploaded while a session was
+// being finalized, are removed as well. It returns the number of sessions collected. Sessions
+// failing to be collected are logged, and collected again on the next run.
+func CollectSessions(ctx context.Context) (int, error) {
+	sessions, err := Repo.Sessions(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed listing publish sessions")
+	}
+
+	staged, err := files.StagedSessions(ctx, Storage)
+	if err != nil {
+		return 0, err
+	}
+
+	known := make(map[string]bool, len(sessions))
+	ids := make([]string, 0, len(sessions)+len(staged))
+	for _, s := range sessions {
+		known[s.ID] = true
+		if s.Expired() {
+			ids = append(ids, s.ID)
+		}
+	}
+
+	for _, id := range staged {
+		if !known[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	collected := 0
+	for _, id := range ids {
+		ok, err := collectSession(ctx, id)
+		if err != nil {
+			glog.Errorf("failed collecting publish session %q: %+v", id, err)
+			continue
+		}
+
+		if ok {
+			collected++
+		}
+	}
+
+	return collected, nil
+}
+
+// collectSession removes a publish session and its staged files if the session is still
+// expired, which it may no longer be if it was finalized in the meantime, or if it no longer
+// exists.
+func collectSession(ctx context.Context, id string) (bool, error) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	s, err := Repo.Session(ctx, id)
+	if _, ok := errors.Cause(err).(*SessionNotFoundError); ok {
+		s, err = nil, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if s != nil && !s.Expired() {
+		return false, nil
+	}
+
+	prefix, err := files.SessionPrefix(id)
+	if err != nil {
+		return false, err
+	}
+
+	if err := Storage.DeletePrefix(ctx, prefix); err != nil {
+		return false, errors.Wrapf(err, "failed deleting files staged for publish session %q", id)
+	}
+
+	if s == nil {
+		glog.Infof("collected files left behind by publish session %q", id)
+		return true, nil
+	}
+
+	if err := Repo.DeleteSession(ctx, id); err != nil {
+		return false, errors.Wrapf(err, "failed deleting publish session %q", id)
+	}
+
+	glog.Infof("collected abandoned publish session %q for %q", id, manifestID(s.Name, s.Version))
+	return true, nil
+}
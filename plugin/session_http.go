@@ -0,0 +1,126 @@
+package plugin
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/config"
+	"github.com/hooklift/lift-registry/files"
+	"github.com/hooklift/lift-registry/pkg/auth"
+	"github.com/hooklift/lift-registry/pkg/render"
+)
+
+// sessionUploadURL returns the URL packages are uploaded to for a publish session.
+func sessionUploadURL(id string) string {
+	u := url.URL{
+		Scheme: "https",
+		Host:   config.PrimaryDomain,
+		Path:   "/plugins/sessions/" + id + "/files",
+	}
+	return u.String()
+}
+
+// httpSession is the body sent back after uploading files to a publish session. Files are listed
+// by name, since where they are staged is an implementation detail.
+type httpSession struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Version   string            `json:"version"`
+	Files     []httpSessionFile `json:"files"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// httpSessionFile is a file uploaded to a publish session.
+type httpSessionFile struct {
+	Name    string            `json:"name"`
+	Size    int64             `json:"size"`
+	Digests map[string]string `json:"digests"`
+}
+
+// toHTTPSession converts a publish session to the body sent back to clients.
+func toHTTPSession(s *Session) *httpSession {
+	res := &httpSession{
+		ID:        s.ID,
+		Name:      s.Name,
+		Version:   s.Version,
+		Files:     make([]httpSessionFile, 0, len(s.Files)),
+		ExpiresAt: s.ExpiresAt,
+	}
+
+	for _, f := range s.Files {
+		res.Files = append(res.Files, httpSessionFile{Name: path.Base(f.Key), Size: f.Size, Digests: f.Digests})
+	}
+	return res
+}
+
+// uploadSessionFiles streams up packages to the staging area of a publish session and sends back
+// the session with every file uploaded to it so far: /plugins/sessions/<session>/files.
+func uploadSessionFiles(w http.ResponseWriter, r *http.Request) {
+	account, ok := auth.FromContext(r.Context())
+	if !ok {
+		renderError(w, http.StatusUnauthorized, &httpError{Error: "Unauthorized"})
+		return
+	}
+
+	if !account.HasScope("admin", "write") {
+		renderError(w, http.StatusForbidden, &httpError{Error: "Forbidden"})
+		return
+	}
+
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/plugins"), "/"), "/")
+	if len(segments) != 3 || segments[0] != "sessions" || segments[2] != "files" {
+		renderError(w, http.StatusNotFound, &httpError{Error: "Not Found"})
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		renderError(w, http.StatusBadRequest, &httpError{Error: err.Error()})
+		return
+	}
+
+	id := segments[1]
+	s, err := UploadSessionFiles(r.Context(), id, account.ID, reader)
+	if err != nil {
+		status := uploadStatus(err)
+		if status == http.StatusInternalServerError {
+			glog.Errorf("failed uploading files to publish session %q: %+v", id, err)
+			renderError(w, status, &httpError{Error: "Internal Server Error"})
+			return
+		}
+
+		renderError(w, status, &httpError{Error: err.Error()})
+		return
+	}
+
+	if err := render.JSON(w, render.WithBody(toHTTPSession(s))); err != nil {
+		glog.Errorf("failed rendering publish session: %+v", err)
+	}
+}
+
+// uploadStatus returns the HTTP status reporting why uploading files to a publish session failed.
+func uploadStatus(err error) int {
+	switch errors.Cause(err).(type) {
+	case *SessionNotFoundError:
+		return http.StatusNotFound
+	case *PermissionDeniedError:
+		return http.StatusForbidden
+	case *ValidationError:
+		return http.StatusBadRequest
+	}
+
+	switch errors.Cause(err) {
+	case files.ErrInvalidUpload:
+		return http.StatusBadRequest
+	case files.ErrExists:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,639 @@
+package plugin_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/config"
+	"github.com/hooklift/lift-registry/files"
+	"github.com/hooklift/lift-registry/pkg/auth"
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+// uploadToSession sends the given files, keyed by name, to the upload endpoint of a publish session.
+func uploadToSession(t *testing.T, id, accountID string, packages map[string]string) *httptest.ResponseRecorder {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for name, content := range packages {
+		fw, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("failed creating form file: %+v", err)
+		}
+
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed writing form file: %+v", err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed closing multipart writer: %+v", err)
+	}
+
+	r := httptest.NewRequest("POST", "/plugins/sessions/"+id+"/files", body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	if accountID != "" {
+		account := &auth.Account{ID: accountID, Scopes: map[string]bool{"write": true}}
+		r = r.WithContext(auth.NewContext(r.Context(), account))
+	}
+
+	w := httptest.NewRecorder()
+	plugin.Handler(http.NotFoundHandler()).ServeHTTP(w, r)
+	return w
+}
+
+// sessionManifest returns a manifest for the packages uploaded to a publish session, with the
+// given checksums keyed by package name. Name and version are taken from the session.
+func sessionManifest(checksums map[string]string) *plugin.Manifest {
+	m := validManifest()
+	m.Name = ""
+	m.Version = ""
+	for _, pkg := range m.Packages {
+		pkg.Algorithm = "sha512"
+		pkg.Checksum = checksums[pkg.Name]
+	}
+	return m
+}
+
+// uploadedSession is the body sent back by the upload endpoint of a publish session.
+type uploadedSession struct {
+	ID    string `json:"id"`
+	Files []struct {
+		Name    string            `json:"name"`
+		Size    int64             `json:"size"`
+		Digests map[string]string `json:"digests"`
+	} `json:"files"`
+}
+
+// decodeUploadedSession decodes the body sent back by the upload endpoint of a publish session.
+func decodeUploadedSession(t *testing.T, w *httptest.ResponseRecorder) *uploadedSession {
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if strings.Contains(w.Body.String(), "staging") {
+		t.Errorf("expected staging keys to be left out, got %s", w.Body.String())
+	}
+
+	s := new(uploadedSession)
+	if err := json.Unmarshal(w.Body.Bytes(), s); err != nil {
+		t.Fatalf("failed decoding session: %+v", err)
+	}
+	return s
+}
+
+// stagedChecksums returns the checksums of the files uploaded to a publish session.
+func stagedChecksums(s *plugin.Session) map[string]string {
+	checksums := make(map[string]string)
+	for _, f := range s.Files {
+		checksums[path.Base(f.Key)] = f.Digests[files.SHA512]
+	}
+	return checksums
+}
+
+func TestPublishSession(t *testing.T) {
+	ctx := context.Background()
+	plugin.Storage = files.NewMemory()
+	plugin.Repo = plugintest.NewMemoryRepository()
+
+	s, err := plugin.OpenSession(ctx, "acc1", "lint", "1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if s.Version != "1.0.0" || s.Expired() {
+		t.Fatalf("expected an open session for version 1.0.0, got %+v", s)
+	}
+
+	packages := map[string]string{
+		"lint-linux-x64.tar.gz": "linux x64 bits",
+		"lint-macOS-x64.tar.gz": "macOS x64 bits",
+	}
+
+	for _, tt := range []struct {
+		desc      string
+		id        string
+		accountID string
+		status    int
+	}{
+		{"unauthenticated", s.ID, "", http.StatusUnauthorized},
+		{"other account", s.ID, "acc2", http.StatusForbidden},
+		{"unknown session", "c0ffee", "acc1", http.StatusNotFound},
+		{"invalid file name", s.ID, "acc1", http.StatusBadRequest},
+	} {
+		sent := packages
+		if tt.status == http.StatusBadRequest {
+			sent = map[string]string{"..": "bits"}
+		}
+
+		if w := uploadToSession(t, tt.id, tt.accountID, sent); w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.desc, tt.status, w.Code, w.Body.String())
+		}
+	}
+
+	uploaded := decodeUploadedSession(t, uploadToSession(t, s.ID, "acc1", packages))
+	if uploaded.ID != s.ID || len(uploaded.Files) != 2 {
+		t.Fatalf("expected 2 staged files, got %+v", uploaded)
+	}
+
+	for _, f := range uploaded.Files {
+		if f.Size != int64(len(packages[f.Name])) || f.Digests[files.SHA512] == "" {
+			t.Errorf("expected %q to be listed with its size and digests, got %+v", f.Name, f)
+		}
+	}
+
+	published, err := files.Key("acc1", "lint", "1.0.0", "lint-linux-x64.tar.gz")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// Uploading a file again replaces the staged one.
+	stored, err := plugin.Repo.Session(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	first := stored.Files[stored.StagedFile("lint-linux-x64.tar.gz")].Key
+	uploaded = decodeUploadedSession(t, uploadToSession(t, s.ID, "acc1", map[string]string{"lint-linux-x64.tar.gz": "fixed linux x64 bits"}))
+	if len(uploaded.Files) != 2 {
+		t.Fatalf("expected 2 staged files, got %+v", uploaded.Files)
+	}
+
+	if stored, err = plugin.Repo.Session(ctx, s.ID); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	staged := stored.Files[stored.StagedFile("lint-linux-x64.tar.gz")].Key
+	if staged == first {
+		t.Fatalf("expected lint-linux-x64.tar.gz to be replaced, got %+v", stored.Files)
+	}
+
+	if _, err := plugin.Storage.Stat(ctx, first); errors.Cause(err) != files.ErrNotFound {
+		t.Errorf("expected replaced file to be deleted, got %v", err)
+	}
+
+	checksums := stagedChecksums(stored)
+	tampered := stagedChecksums(stored)
+	tampered["lint-linux-x64.tar.gz"] = strings.Repeat("0", 128)
+
+	// Nothing becomes visible when finalizing fails.
+	err = plugin.FinalizeSession(ctx, s.ID, "acc1", sessionManifest(tampered))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	err = plugin.FinalizeSession(ctx, s.ID, "acc2", sessionManifest(checksums))
+	if _, ok := errors.Cause(err).(*plugin.PermissionDeniedError); !ok {
+		t.Errorf("expected *PermissionDeniedError, got %T: %v", err, err)
+	}
+
+	m := sessionManifest(checksums)
+	m.Version = "2.0.0"
+	err = plugin.FinalizeSession(ctx, s.ID, "acc1", m)
+	if _, ok := errors.Cause(err).(*plugin.ValidationError); !ok {
+		t.Errorf("expected *ValidationError, got %T: %v", err, err)
+	}
+
+	if _, err := plugin.Repo.Get(ctx, "lint", "1.0.0"); err == nil {
+		t.Fatal("expected version to remain unpublished")
+	}
+
+	if _, err := plugin.Storage.Stat(ctx, published); errors.Cause(err) != files.ErrNotFound {
+		t.Fatalf("expected package to remain unpublished, got %v", err)
+	}
+
+	if _, err := plugin.Storage.Stat(ctx, staged); err != nil {
+		t.Fatalf("expected package to remain staged, got %+v", err)
+	}
+
+	m = sessionManifest(checksums)
+	if err := plugin.FinalizeSession(ctx, s.ID, "acc1", m); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if m.ID != "lint@1.0.0" || m.AccountID != "acc1" || m.PublishedAt.IsZero() {
+		t.Errorf("expected manifest to be published by acc1, got %+v", m)
+	}
+
+	if _, err := plugin.Repo.Get(ctx, "lint", "1.0.0"); err != nil {
+		t.Errorf("expected version to be published, got %+v", err)
+	}
+
+	if _, err := plugin.Storage.Stat(ctx, published); err != nil {
+		t.Errorf("expected package to be published, got %+v", err)
+	}
+
+	if _, err := plugin.Storage.Stat(ctx, staged); errors.Cause(err) != files.ErrNotFound {
+		t.Errorf("expected staged package to be gone, got %v", err)
+	}
+
+	err = plugin.FinalizeSession(ctx, s.ID, "acc1", sessionManifest(checksums))
+	if _, ok := errors.Cause(err).(*plugin.SessionNotFoundError); !ok {
+		t.Errorf("expected *SessionNotFoundError, got %T: %v", err, err)
+	}
+
+	_, err = plugin.OpenSession(ctx, "acc1", "lint", "1.0.0")
+	if _, ok := errors.Cause(err).(*plugin.VersionExistsError); !ok {
+		t.Errorf("expected *VersionExistsError, got %T: %v", err, err)
+	}
+
+	_, err = plugin.OpenSession(ctx, "acc2", "lint", "2.0.0")
+	if _, ok := errors.Cause(err).(*plugin.PermissionDeniedError); !ok {
+		t.Errorf("expected *PermissionDeniedError, got %T: %v", err, err)
+	}
+}
+
+func TestOpenSession(t *testing.T) {
+	config.ReservedNames = []string{"lift"}
+	plugin.Storage = files.NewMemory()
+	plugin.Repo = plugintest.NewMemoryRepository(
+		&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.0.0"},
+	)
+
+	tests := []struct {
+		desc      string
+		accountID string
+		name      string
+		version   string
+		err       error
+	}{
+		{"new plugin", "acc2", "vet", "1.0.0", nil},
+		{"new version", "acc1", "lint", "1.1.0", nil},
+		{"missing name", "acc2", "", "1.0.0", &plugin.ValidationError{}},
+		{"invalid name", "acc2", "-vet", "1.0.0", &plugin.ValidationError{}},
+		{"invalid version", "acc2", "vet", "one", &plugin.ValidationError{}},
+		{"reserved name", "acc2", "lift", "1.0.0", &plugin.ValidationError{}},
+		{"lookalike name", "acc2", "l1nt", "1.0.0", &plugin.ValidationError{}},
+		{"plugin owned by another account", "acc2", "lint", "1.1.0", &plugin.PermissionDeniedError{}},
+		{"published version", "acc1", "lint", "1.0.0", &plugin.VersionExistsError{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := plugin.OpenSession(context.Background(), tt.accountID, tt.name, tt.version)
+			if tt.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+				return
+			}
+
+			if reflect.TypeOf(errors.Cause(err)) != reflect.TypeOf(tt.err) {
+				t.Fatalf("expected %T, got %T: %v", tt.err, err, err)
+			}
+		})
+	}
+}
+
+func TestFinalizeSessionNeverReplaces(t *testing.T) {
+	ctx := context.Background()
+	plugin.Storage = files.NewMemory()
+	plugin.Repo = plugintest.NewMemoryRepository()
+
+	// A package was uploaded for the version beforehand, outside of any session.
+	uploadPackages(t, plugin.Storage, "acc1/lint/1.0.0", map[string]string{"lint-macOS-x64.tar.gz": "legacy bits"})
+
+	s, err := plugin.OpenSession(ctx, "acc1", "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	w := uploadToSession(t, s.ID, "acc1", map[string]string{
+		"lint-linux-x64.tar.gz": "linux x64 bits",
+		"lint-macOS-x64.tar.gz": "macOS x64 bits",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if s, err = plugin.Repo.Session(ctx, s.ID); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	err = plugin.FinalizeSession(ctx, s.ID, "acc1", sessionManifest(stagedChecksums(s)))
+	if _, ok := errors.Cause(err).(*plugin.PackageExistsError); !ok {
+		t.Fatalf("expected *PackageExistsError, got %T: %v", err, err)
+	}
+
+	if _, err := plugin.Repo.Get(ctx, "lint", "1.0.0"); err == nil {
+		t.Error("expected version to remain unpublished")
+	}
+
+	legacy, err := files.Key("acc1", "lint", "1.0.0", "lint-macOS-x64.tar.gz")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	reader, err := plugin.Storage.Get(ctx, legacy)
+	if err != nil {
+		t.Fatalf("expected %q to be kept, got %+v", legacy, err)
+	}
+	defer reader.Close()
+
+	if data, err := ioutil.ReadAll(reader); err != nil || string(data) != "legacy bits" {
+		t.Errorf("expected %q to keep its content, got %q (%v)", legacy, data, err)
+	}
+
+	moved, err := files.Key("acc1", "lint", "1.0.0", "lint-linux-x64.tar.gz")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if _, err := plugin.Storage.Stat(ctx, moved); errors.Cause(err) != files.ErrNotFound {
+		t.Errorf("expected %q to be moved back, got %v", moved, err)
+	}
+
+	for _, f := range s.Files {
+		if _, err := plugin.Storage.Stat(ctx, f.Key); err != nil {
+			t.Errorf("expected %q to remain staged, got %+v", f.Key, err)
+		}
+	}
+}
+
+func TestFinalizeSessionReusesStoredPackages(t *testing.T) {
+	ctx := context.Background()
+	plugin.Storage = files.NewMemory()
+	plugin.Repo = plugintest.NewMemoryRepository()
+
+	// The same package was uploaded for the version beforehand, outside of any session.
+	uploadPackages(t, plugin.Storage, "acc1/lint/1.0.0", map[string]string{"lint-macOS-x64.tar.gz": "macOS x64 bits"})
+
+	s, err := plugin.OpenSession(ctx, "acc1", "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	decodeUploadedSession(t, uploadToSession(t, s.ID, "acc1", map[string]string{
+		"lint-linux-x64.tar.gz": "linux x64 bits",
+		"lint-macOS-x64.tar.gz": "macOS x64 bits",
+	}))
+
+	if s, err = plugin.Repo.Session(ctx, s.ID); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := plugin.FinalizeSession(ctx, s.ID, "acc1", sessionManifest(stagedChecksums(s))); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for _, name := range []string{"lint-linux-x64.tar.gz", "lint-macOS-x64.tar.gz"} {
+		key, err := files.Key("acc1", "lint", "1.0.0", name)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if _, err := plugin.Storage.Stat(ctx, key); err != nil {
+			t.Errorf("expected %q to be published, got %+v", key, err)
+		}
+	}
+
+	for _, f := range s.Files {
+		if _, err := plugin.Storage.Stat(ctx, f.Key); errors.Cause(err) != files.ErrNotFound {
+			t.Errorf("expected staged %q to be gone, got %v", f.Key, err)
+		}
+	}
+}
+
+func TestCollectSessions(t *testing.T) {
+	ctx := context.Background()
+	plugin.Storage = files.NewMemory()
+	plugin.Repo = plugintest.NewMemoryRepository()
+
+	abandoned, err := plugin.OpenSession(ctx, "acc1", "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	open, err := plugin.OpenSession(ctx, "acc1", "lint", "1.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for _, id := range []string{abandoned.ID, open.ID} {
+		if w := uploadToSession(t, id, "acc1", map[string]string{"lint-linux-x64.tar.gz": "bits"}); w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+
+	abandoned, err = plugin.Repo.Session(ctx, abandoned.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	abandoned.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := plugin.Repo.SaveSession(ctx, abandoned); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// Expired sessions can no longer be used, even before being collected.
+	err = plugin.FinalizeSession(ctx, abandoned.ID, "acc1", sessionManifest(stagedChecksums(abandoned)))
+	if _, ok := errors.Cause(err).(*plugin.SessionNotFoundError); !ok {
+		t.Errorf("expected *SessionNotFoundError, got %T: %v", err, err)
+	}
+
+	collected, err := plugin.CollectSessions(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if collected != 1 {
+		t.Errorf("expected 1 collected session, got %d", collected)
+	}
+
+	if _, err := plugin.Repo.Session(ctx, abandoned.ID); err == nil {
+		t.Error("expected abandoned session to be deleted")
+	}
+
+	if _, err := plugin.Storage.Stat(ctx, abandoned.Files[0].Key); errors.Cause(err) != files.ErrNotFound {
+		t.Errorf("expected staged file of abandoned session to be deleted, got %v", err)
+	}
+
+	s, err := plugin.Repo.Session(ctx, open.ID)
+	if err != nil {
+		t.Fatalf("expected open session to be kept, got %+v", err)
+	}
+
+	if _, err := plugin.Storage.Stat(ctx, s.Files[0].Key); err != nil {
+		t.Errorf("expected staged file of open session to be kept, got %+v", err)
+	}
+}
+
+// failingPrefix is a storage provider failing to delete the files under a prefix.
+type failingPrefix struct {
+	files.StorageProvider
+	prefix string
+}
+
+func (s failingPrefix) DeletePrefix(ctx context.Context, prefix string) error {
+	if prefix == s.prefix {
+		return errors.New("storage unavailable")
+	}
+	return s.StorageProvider.DeletePrefix(ctx, prefix)
+}
+
+func TestCollectStagedFiles(t *testing.T) {
+	ctx := context.Background()
+	plugin.Storage = failingPrefix{StorageProvider: files.NewMemory(), prefix: ".staging/broken"}
+	plugin.Repo = plugintest.NewMemoryRepository()
+
+	open, err := plugin.OpenSession(ctx, "acc1", "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// Files are left behind by sessions no longer stored, such as uploads finishing after
+	// their session was finalized.
+	for _, prefix := range []string{".staging/" + open.ID + "/upload1", ".staging/orphan/upload1", ".staging/broken/upload1"} {
+		uploadPackages(t, plugin.Storage, prefix, map[string]string{"lint-linux-x64.tar.gz": "bits"})
+	}
+
+	// Sessions failing to be collected do not keep the others from being collected.
+	collected, err := plugin.CollectSessions(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if collected != 1 {
+		t.Errorf("expected 1 collected session, got %d", collected)
+	}
+
+	staged, err := files.StagedSessions(ctx, plugin.Storage)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := []string{"broken", open.ID}
+	sort.Strings(expected)
+	sort.Strings(staged)
+	if len(staged) != 2 || staged[0] != expected[0] || staged[1] != expected[1] {
+		t.Errorf("expected files of sessions %v to be left, got %v", expected, staged)
+	}
+}
+
+// racingStorage runs a function after every upload, before returning its files.
+type racingStorage struct {
+	files.StorageProvider
+	race     func()
+	uploaded []*files.Object
+}
+
+func (s *racingStorage) Upload(ctx context.Context, prefix string, reader *multipart.Reader) ([]*files.Object, error) {
+	objects, err := s.StorageProvider.Upload(ctx, prefix, reader)
+	s.uploaded = append(s.uploaded, objects...)
+	s.race()
+	return objects, err
+}
+
+func TestUploadToClosedSession(t *testing.T) {
+	ctx := context.Background()
+	plugin.Repo = plugintest.NewMemoryRepository()
+
+	s, err := plugin.OpenSession(ctx, "acc1", "lint", "1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// The session is finalized, or collected, while files are being uploaded to it.
+	storage := &racingStorage{StorageProvider: files.NewMemory(), race: func() {
+		if err := plugin.Repo.DeleteSession(ctx, s.ID); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	}}
+	plugin.Storage = storage
+
+	if w := uploadToSession(t, s.ID, "acc1", map[string]string{"lint-linux-x64.tar.gz": "bits"}); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNotFound, w.Code, w.Body.String())
+	}
+
+	if len(storage.uploaded) != 1 {
+		t.Fatalf("expected 1 uploaded file, got %+v", storage.uploaded)
+	}
+
+	if _, err := plugin.Storage.Stat(ctx, storage.uploaded[0].Key); errors.Cause(err) != files.ErrNotFound {
+		t.Errorf("expected file uploaded to a closed session to be deleted, got %v", err)
+	}
+}
+
+// movingStorage runs a function on the first move, before moving the file.
+type movingStorage struct {
+	files.StorageProvider
+	once sync.Once
+	race func()
+}
+
+func (s *movingStorage) Move(ctx context.Context, from, to string) error {
+	s.once.Do(s.race)
+	return s.StorageProvider.Move(ctx, from, to)
+}
+
+func TestFinalizeSessionWhileMoving(t *testing.T) {
+	ctx := context.Background()
+	plugin.Repo = plugintest.NewMemoryRepository()
+	packages := map[string]string{
+		"lint-linux-x64.tar.gz": "linux x64 bits",
+		"lint-macOS-x64.tar.gz": "macOS x64 bits",
+	}
+
+	sessions := make([]*plugin.Session, 0, 2)
+	for i := 0; i < 2; i++ {
+		s, err := plugin.OpenSession(ctx, "acc1", "lint", "1.0.0")
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	// Packages are moved without holding the lock, so the other session can be finalized
+	// meanwhile, but not for the version being published.
+	storage := &movingStorage{StorageProvider: files.NewMemory(), race: func() {
+		done := make(chan error, 1)
+		go func() {
+			s := sessions[1]
+			done <- plugin.FinalizeSession(ctx, s.ID, s.AccountID, sessionManifest(stagedChecksums(s)))
+		}()
+
+		select {
+		case err := <-done:
+			if _, ok := errors.Cause(err).(*plugin.PublishInProgressError); !ok {
+				t.Errorf("expected *PublishInProgressError, got %T: %v", err, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("expected finalizing another session not to wait for packages to be moved")
+		}
+	}}
+	plugin.Storage = storage
+
+	for i, s := range sessions {
+		decodeUploadedSession(t, uploadToSession(t, s.ID, "acc1", packages))
+		stored, err := plugin.Repo.Session(ctx, s.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		sessions[i] = stored
+	}
+
+	s := sessions[0]
+	if err := plugin.FinalizeSession(ctx, s.ID, "acc1", sessionManifest(stagedChecksums(s))); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	s = sessions[1]
+	err := plugin.FinalizeSession(ctx, s.ID, "acc1", sessionManifest(stagedChecksums(s)))
+	if _, ok := errors.Cause(err).(*plugin.VersionExistsError); !ok {
+		t.Errorf("expected *VersionExistsError once published, got %T: %v", err, err)
+	}
+}
@@ -0,0 +1,205 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// storedFields decodes the stored fields of a document returned by a search engine, such
+// as Bleve. Search engines flatten documents into dotted field paths, i.e. packages.os,
+// and return a single value instead of a list when an array has only one element.
+// Missing optional fields decode to their zero value.
+type storedFields struct {
+	fields map[string]interface{}
+	errs   []string
+}
+
+// stringValue decodes a string field.
+func (f *storedFields) stringValue(name string) string {
+	v, ok := f.fields[name]
+	if !ok || v == nil {
+		return ""
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		f.errs = append(f.errs, fmt.Sprintf("%s: expected string, got %T", name, v))
+	}
+	return s
+}
+
+// requiredString decodes a string field that must be present.
+func (f *storedFields) requiredString(name string) string {
+	s := f.stringValue(name)
+	if _, ok := f.fields[name]; !ok || s == "" {
+		f.errs = append(f.errs, fmt.Sprintf("%s: missing", name))
+	}
+	return s
+}
+
+// stringValues decodes a field holding a list of strings.
+func (f *storedFields) stringValues(name string) []string {
+	v, ok := f.fields[name]
+	if !ok || v == nil {
+		return nil
+	}
+
+	values, ok := v.([]interface{})
+	if !ok {
+		values = []interface{}{v}
+	}
+
+	res := make([]string, 0, len(values))
+	for _, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			f.errs = append(f.errs, fmt.Sprintf("%s: expected string, got %T", name, value))
+			return nil
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// boolValue decodes a boolean field.
+func (f *storedFields) boolValue(name string) bool {
+	v, ok := f.fields[name]
+	if !ok || v == nil {
+		return false
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		f.errs = append(f.errs, fmt.Sprintf("%s: expected bool, got %T", name, v))
+	}
+	return b
+}
+
+// intValue decodes a numeric field. Numbers are stored as floating point values.
+func (f *storedFields) intValue(name string) int64 {
+	v, ok := f.fields[name]
+	if !ok || v == nil {
+		return 0
+	}
+
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	}
+
+	f.errs = append(f.errs, fmt.Sprintf("%s: expected number, got %T", name, v))
+	return 0
+}
+
+// timeValue decodes a datetime field, stored in RFC3339 format.
+func (f *storedFields) timeValue(name string) time.Time {
+	s := f.stringValue(name)
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		f.errs = append(f.errs, fmt.Sprintf("%s: %v", name, err))
+	}
+	return t
+}
+
+// err returns all decoding errors found so far.
+func (f *storedFields) err() error {
+	if len(f.errs) == 0 {
+		return nil
+	}
+	return errors.Errorf("invalid stored fields: %s", strings.Join(f.errs, "; "))
+}
+
+// manifestFromFields converts the stored fields of a search hit back into a manifest.
+// It fails if the document is corrupt, i.e. fields have unexpected types or package
+// fields are inconsistent.
+func manifestFromFields(id string, fields map[string]interface{}) (*Manifest, error) {
+	f := &storedFields{fields: fields}
+
+	manifest := &Manifest{
+		ID:          f.stringValue("_id"),
+		AccountID:   f.stringValue("_account_id"),
+		Name:        f.requiredString("name"),
+		FilesURI:    f.stringValue("files_uri"),
+		Version:     f.requiredString("version"),
+		Description: f.stringValue("description"),
+		Author: Author{
+			Name:  f.stringValue("author.name"),
+			Email: f.stringValue("author.email"),
+		},
+		License:     f.stringValue("license"),
+		Homepage:    f.stringValue("homepage"),
+		PublishedAt: f.timeValue("published_at"),
+		UpdatedAt:   f.timeValue("updated_at"),
+		Downloads:   f.intValue("downloads"),
+		Yanked:      f.boolValue("yanked"),
+		YankReason:  f.stringValue("yank_reason"),
+	}
+
+	if manifest.ID == "" {
+		manifest.ID = id
+	}
+
+	// Documents indexed before updates were tracked were never updated after being published.
+	if manifest.UpdatedAt.IsZero() {
+		manifest.UpdatedAt = manifest.PublishedAt
+	}
+
+	if message := f.stringValue("deprecation.message"); message != "" {
+		manifest.Deprecation = &Deprecation{
+			Message:     message,
+			Replacement: f.stringValue("deprecation.replacement"),
+		}
+	}
+
+	names := f.stringValues("packages.name")
+	pkgOSes := f.stringValues("packages.os")
+	pkgArchs := f.stringValues("packages.arch")
+	checksums := f.stringValues("packages.checksum")
+	algorithms := f.stringValues("packages.algorithm")
+
+	if err := f.err(); err != nil {
+		return nil, errors.Wrapf(err, "corrupt document %q", id)
+	}
+
+	for field, values := range map[string][]string{
+		"packages.os":        pkgOSes,
+		"packages.arch":      pkgArchs,
+		"packages.checksum":  checksums,
+		"packages.algorithm": algorithms,
+	} {
+		if values != nil && len(values) != len(names) {
+			return nil, errors.Errorf("corrupt document %q: %s has %d values for %d packages", id, field, len(values), len(names))
+		}
+	}
+
+	manifest.Packages = make([]*Package, 0, len(names))
+	for i, name := range names {
+		p := &Package{Name: name}
+		if pkgOSes != nil {
+			p.OS = OS(pkgOSes[i])
+		}
+		if pkgArchs != nil {
+			p.Arch = Arch(pkgArchs[i])
+		}
+		if checksums != nil {
+			p.Checksum = checksums[i]
+		}
+		if algorithms != nil {
+			p.Algorithm = Algorithm(algorithms[i])
+		}
+		manifest.Packages = append(manifest.Packages, p)
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManifestFromFields(t *testing.T) {
+	tests := []struct {
+		desc     string
+		fields   map[string]interface{}
+		packages int
+		valid    bool
+	}{
+		{"single package", map[string]interface{}{
+			"name":               "lint",
+			"version":            "1.0.0",
+			"published_at":       "2017-06-01T10:00:00Z",
+			"packages.name":      "lint-linux-x64.tar.gz",
+			"packages.os":        "linux",
+			"packages.arch":      "x64",
+			"packages.checksum":  "abcd",
+			"packages.algorithm": "sha256",
+		}, 1, true},
+		{"several packages", map[string]interface{}{
+			"name":          "lint",
+			"version":       "1.0.0",
+			"downloads":     float64(42),
+			"yanked":        true,
+			"packages.name": []interface{}{"lint-linux-x64.tar.gz", "lint-macOS-x64.tar.gz"},
+			"packages.os":   []interface{}{"linux", "macOS"},
+		}, 2, true},
+		{"optional fields missing", map[string]interface{}{"name": "lint", "version": "1.0.0"}, 0, true},
+		{"name missing", map[string]interface{}{"version": "1.0.0"}, 0, false},
+		{"unexpected type", map[string]interface{}{"name": "lint", "version": 1.0}, 0, false},
+		{"invalid timestamp", map[string]interface{}{"name": "lint", "version": "1.0.0", "published_at": "yesterday"}, 0, false},
+		{"inconsistent packages", map[string]interface{}{
+			"name":          "lint",
+			"version":       "1.0.0",
+			"packages.name": []interface{}{"lint-linux-x64.tar.gz", "lint-macOS-x64.tar.gz"},
+			"packages.os":   "linux",
+		}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			m, err := manifestFromFields("lint@1.0.0", tt.fields)
+			if !tt.valid {
+				if err == nil {
+					t.Fatalf("expected corrupt document to fail decoding, got %+v", m)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			if m.ID != "lint@1.0.0" {
+				t.Errorf("expected document ID to be used, got %q", m.ID)
+			}
+
+			if len(m.Packages) != tt.packages {
+				t.Errorf("expected %d packages, got %d", tt.packages, len(m.Packages))
+			}
+
+			if !m.UpdatedAt.Equal(m.PublishedAt) {
+				t.Errorf("expected missing update time to default to publishing time, got %s", m.UpdatedAt)
+			}
+		})
+	}
+
+	m, err := manifestFromFields("lint@1.0.0", tests[0].fields)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	want := Package{Name: "lint-linux-x64.tar.gz", OS: linux, Arch: x64, Checksum: "abcd", Algorithm: sha256}
+	if *m.Packages[0] != want {
+		t.Errorf("expected package %+v, got %+v", want, *m.Packages[0])
+	}
+
+	if !m.PublishedAt.Equal(time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected publishing time %s", m.PublishedAt)
+	}
+}
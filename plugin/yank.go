@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	version "github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
+)
+
+// manifestMu serializes changes to published manifests, so that reading and updating them
+// happens atomically.
+var manifestMu sync.Mutex
+
+// Yank hides a plugin version from search results and version resolution, without breaking
+// clients that pinned it. Only the plugin owner and its maintainers can yank versions, unless
+// admin is set.
+func Yank(ctx context.Context, name, pluginVersion, accountID, reason string, admin bool) error {
+	return updateManifest(ctx, name, pluginVersion, accountID, "yank", admin, func(m *Manifest) {
+		m.Yanked = true
+		m.YankReason = reason
+	})
+}
+
+// Unyank makes a yanked plugin version visible again.
+func Unyank(ctx context.Context, name, pluginVersion, accountID string, admin bool) error {
+	return updateManifest(ctx, name, pluginVersion, accountID, "unyank", admin, func(m *Manifest) {
+		m.Yanked = false
+		m.YankReason = ""
+	})
+}
+
+// updateManifest atomically applies a change to a published plugin version, on behalf of
+// one of its maintainers.
+func updateManifest(ctx context.Context, name, pluginVersion, accountID, action string, admin bool, change func(m *Manifest)) error {
+	if name == "" {
+		return errors.New("plugin name is required")
+	}
+
+	if accountID == "" {
+		return errors.New("account ID is required")
+	}
+
+	ver, err := version.NewVersion(pluginVersion)
+	if err != nil {
+		verr := new(ValidationError)
+		verr.Add("version", fmt.Sprintf("%q is not a valid version number", pluginVersion))
+		return verr
+	}
+
+	if err := checkMaintainer(ctx, name, accountID, action, admin); err != nil {
+		return err
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	m, err := Repo.Get(ctx, name, ver.String())
+	if err != nil {
+		return err
+	}
+
+	change(m)
+	m.UpdatedAt = time.Now()
+	return Repo.Update(ctx, m)
+}
@@ -0,0 +1,62 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/hooklift/lift-registry/plugin"
+	"github.com/hooklift/lift-registry/plugin/plugintest"
+)
+
+func TestYank(t *testing.T) {
+	pkgs := []*plugin.Package{{Name: "linux.tar.gz", OS: "linux", Arch: "x64"}}
+	repo := plugintest.NewMemoryRepository(
+		&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.0.0", Packages: pkgs},
+		&plugin.Manifest{AccountID: "acc1", Name: "lint", Version: "1.1.0", Packages: pkgs},
+	)
+	if err := repo.SaveOwnership(context.Background(), &plugin.Ownership{Name: "lint", OwnerID: "acc1"}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	plugin.Repo = repo
+	ctx := context.Background()
+
+	err := plugin.Yank(ctx, "lint", "1.1.0", "acc2", "", false)
+	if _, ok := errors.Cause(err).(*plugin.PermissionDeniedError); !ok {
+		t.Fatalf("expected *PermissionDeniedError, got %T: %v", err, err)
+	}
+
+	if err := plugin.Yank(ctx, "lint", "1.1", "acc1", "broken on Windows", false); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	m, err := plugin.Get(ctx, "lint", "1.1.0")
+	if err != nil {
+		t.Fatalf("expected yanked version to be found by its exact version: %+v", err)
+	}
+
+	if !m.Yanked || m.YankReason != "broken on Windows" {
+		t.Errorf("expected version to be yanked with its reason, got %+v", m)
+	}
+
+	if m, err := plugin.Get(ctx, "lint", ""); err != nil || m.Version != "1.0.0" {
+		t.Errorf("expected latest version to skip yanked versions, got %+v, %v", m, err)
+	}
+
+	if m, _, err := plugin.Resolve(ctx, "lint", "~> 1.0", "linux", "x64"); err != nil || m.Version != "1.0.0" {
+		t.Errorf("expected resolution to skip yanked versions, got %+v, %v", m, err)
+	}
+
+	if err := plugin.Unyank(ctx, "lint", "1.1.0", "acc2", true); err != nil {
+		t.Fatalf("expected admin to unyank: %+v", err)
+	}
+
+	if m, err := plugin.Get(ctx, "lint", ""); err != nil || m.Version != "1.1.0" || m.YankReason != "" {
+		t.Errorf("expected unyanked version to be the latest again, got %+v, %v", m, err)
+	}
+
+	if err := plugin.Yank(ctx, "lint", "2.0.0", "acc1", "", false); err == nil {
+		t.Error("expected yanking a missing version to fail")
+	}
+}
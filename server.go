@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/blevesearch/bleve"
 	"github.com/c4milo/handlers/grpcutil"
 	"github.com/c4milo/handlers/logger"
 	"github.com/golang/glog"
@@ -34,32 +35,48 @@ func init() {
 	flag.Set("logtostderr", "true")
 }
 
-// Initializes plugins database
-func initBleve() {
-	glog.Infof("Opening Bleve index at %q...", config.IndexFile)
+// initRepos initializes all the domain modules with their respective
+// repository implementation.
+func initRepos() {
+	// The repository implementation is selected by configuration
+	glog.Infof("Opening %s plugin repository...", config.RepositoryDriver)
 
-	index, err := bleve.Open(config.IndexFile)
-	if err == bleve.ErrorIndexPathDoesNotExist {
-		glog.Info("Bleve index does not exist, creating it....")
+	repo, err := plugin.NewRepository()
+	if err != nil {
+		glog.Fatalf("unable to open %s plugin repository: %+v", config.RepositoryDriver, err)
+	}
+	plugin.Repo = repo
+}
 
-		index, err = bleve.New(config.IndexFile, bleve.NewIndexMapping())
+// collectSessions periodically removes abandoned publish sessions, along with the packages
+// uploaded to them.
+func collectSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		collected, err := plugin.CollectSessions(context.Background())
 		if err != nil {
-			glog.Fatalf("unable to create Bleve index: %+v", err)
+			glog.Errorf("failed collecting publish sessions: %+v", err)
 		}
-	}
 
-	if err != nil {
-		glog.Fatalf("unable to open Bleve index at %q", config.IndexFile)
+		if collected > 0 {
+			glog.Infof("Collected %d abandoned publish sessions", collected)
+		}
 	}
-
-	initRepos(index)
 }
 
-// initRepos initializes all the domain modules with their respective
-// repository implementation.
-func initRepos(index bleve.Index) {
-	// The repository layer compiled is determined by build flags
-	plugin.Repo = plugin.NewRepository(index)
+// flushDownloads periodically adds the downloads counted in memory to the repository. Downloads
+// not yet flushed are lost if the server stops.
+func flushDownloads(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := plugin.FlushDownloads(context.Background()); err != nil {
+			glog.Errorf("failed flushing downloads: %+v", err)
+		}
+	}
 }
 
 func main() {
@@ -69,8 +86,16 @@ func main() {
 	// Reads configurations values
 	config.Read()
 
-	// Initializes Bleve index
-	initBleve()
+	// Initializes plugins database
+	initRepos()
+
+	// Initializes storage provider for plugin packages
+	storage := files.NewProvider()
+	plugin.Storage = storage
+
+	// Removes abandoned publish sessions
+	go collectSessions(time.Hour)
+	go flushDownloads(10 * time.Second)
 
 	// Initializes metrics sink
 	// sink, _ := metrics.NewStatsiteSink(config.StatsiteAddr)
@@ -101,7 +126,9 @@ func main() {
 	// Single Page Application  web UI
 	handler := ui.Handler(http.DefaultServeMux)
 	// File management API to upload or download packages
-	handler = files.Handler(handler)
+	handler = files.Handler(handler, storage, plugin.Published)
+	// Plugin package downloads by platform
+	handler = plugin.Handler(handler)
 	// HTTP security filter
 	handler = identity.TokenHandler(handler, identityConn, config.ClientURI)
 	// gRPC services, uses unary interceptor to verify authorization tokens.